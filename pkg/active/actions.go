@@ -0,0 +1,121 @@
+package active
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/jmoiron/sqlx/types"
+)
+
+type (
+	// Row of action log
+	ActionRecord struct {
+		RowId     string         `db:"row_id"`
+		Name      string         `db:"name"`
+		Data      types.JSONText `db:"data"`
+		CreatedAt time.Time      `db:"created_at"`
+	}
+
+	// Cell touched by action as persisted in changes column of action log
+	affectedCell struct {
+		RowId      string `json:"row_id"`
+		ColumnName string `json:"column_name"`
+		Type       string `json:"type"`
+	}
+)
+
+// Page of logged actions newest first in keyset order over (created_at, row_id). Cursor is opaque
+// rather than bare time: with time alone, next page would either skip or repeat actions logged within
+// the same microsecond as last one of page. Empty cursor starts from the newest action, next cursor
+// is empty once the last page is returned
+func (pg *pg) Actions(ctx context.Context, limit int, cursor string) ([]ActionRecord, string, error) {
+	ctx, err := pg.enter(ctx)
+	if err != nil {
+		return nil, "", err
+	}
+	if limit <= 0 {
+		limit = DefaultListLimit
+	}
+	query, args := pg.q(ctx).actions, []interface{}{limit}
+	if cursor != "" {
+		createdAt, rowId, err := decodeCursor(cursor)
+		if err != nil {
+			return nil, "", err
+		}
+		query, args = pg.q(ctx).actionsBefore, []interface{}{createdAt, rowId, limit}
+	}
+	ctx, cancel := pg.withDefaultTimeout(ctx)
+	defer cancel()
+	var arr []ActionRecord
+	if err := sqlx.SelectContext(ctx, pg.queryer(ctx), &arr, query, args...); err != nil {
+		return nil, "", err
+	}
+	return arr, actionsCursor(arr, limit), nil
+}
+
+// Cursor of page following full page of actions
+func actionsCursor(arr []ActionRecord, limit int) string {
+	if len(arr) < limit {
+		return ""
+	}
+	last := arr[len(arr)-1]
+	return encodeCursor(last.CreatedAt, last.RowId)
+}
+
+// Changes applied by logged action, entities carry only row and column of touched cells.
+// Actions logged without running them have no changes
+func (pg *pg) AffectedBy(ctx context.Context, actionId string) ([]Change, error) {
+	ctx, err := pg.enter(ctx)
+	if err != nil {
+		return nil, err
+	}
+	ctx, cancel := pg.withDefaultTimeout(ctx)
+	defer cancel()
+	var doc []byte
+	if err := pg.queryer(ctx).QueryRowxContext(ctx, pg.q(ctx).affectedBy, actionId).Scan(&doc); errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrNotFound
+	} else if err != nil {
+		return nil, err
+	} else if doc == nil {
+		return nil, nil
+	}
+	var cells []affectedCell
+	if err := pg.json.unmarshal(doc, &cells); err != nil {
+		return nil, err
+	}
+	arr := make([]Change, len(cells))
+	for i, c := range cells {
+		t, err := parseChangeType(c.Type)
+		if err != nil {
+			return nil, err
+		}
+		arr[i] = Change{V: &Entity{Ref: Ref{RowId: c.RowId, ColumnName: c.ColumnName}}, T: t}
+	}
+	return arr, nil
+}
+
+// Changes column of log row of action applying changes
+func (pg *pg) affectedDoc(changes []Change) (types.NullJSONText, error) {
+	cells := make([]affectedCell, len(changes))
+	for i, c := range changes {
+		cells[i] = affectedCell{RowId: c.V.Ref.RowId, ColumnName: c.V.Ref.ColumnName, Type: c.T.String()}
+	}
+	doc, err := pg.json.marshal(cells)
+	if err != nil {
+		return types.NullJSONText{}, err
+	}
+	return types.NullJSONText{JSONText: doc, Valid: true}, nil
+}
+
+func parseChangeType(s string) (ChangeType, error) {
+	for _, t := range []ChangeType{AddChangeType, UpdateChangeType, DeleteChangeType} {
+		if t.String() == s {
+			return t, nil
+		}
+	}
+	return 0, fmt.Errorf("model: unknown change type %q", s)
+}
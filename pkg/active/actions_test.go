@@ -0,0 +1,115 @@
+package active
+
+import (
+	"context"
+	"database/sql/driver"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/lib/pq"
+)
+
+func TestActionsCursor(t *testing.T) {
+	at := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name     string
+		cursor   string
+		query    string
+		args     []driver.Value
+		rows     []string
+		wantNext string
+		wantErr  error
+	}{
+		{
+			name:     "first page",
+			query:    `ORDER BY created_at DESC, row_id DESC LIMIT \$1`,
+			args:     []driver.Value{2},
+			rows:     []string{"b", "a"},
+			wantNext: encodeCursor(at, "a"),
+		},
+		{
+			name:   "next page after cursor",
+			cursor: encodeCursor(at, "a"),
+			query:  `WHERE \(created_at, row_id\) < \(\$1, \$2\) ORDER BY created_at DESC, row_id DESC LIMIT \$3`,
+			args:   []driver.Value{at, "a", 2},
+			rows:   []string{"0"},
+		},
+		{
+			name:    "invalid cursor",
+			cursor:  "not a cursor",
+			wantErr: ErrInvalidCursor,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store, mock := newMockStore(t)
+			if tt.query != "" {
+				rows := sqlmock.NewRows([]string{"row_id", "name", "data", "created_at"})
+				for _, rowId := range tt.rows {
+					rows.AddRow(rowId, "noop", []byte(`null`), at)
+				}
+				mock.ExpectQuery(tt.query).WithArgs(tt.args...).WillReturnRows(rows)
+			}
+			arr, next, err := store.Actions(context.Background(), 2, tt.cursor)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("expected %v, got %v", tt.wantErr, err)
+			} else if len(arr) != len(tt.rows) || next != tt.wantNext {
+				t.Fatalf("expected %d actions and cursor %q, got %d and %q", len(tt.rows), tt.wantNext, len(arr), next)
+			}
+		})
+	}
+}
+
+func TestRunActionIdempotencyKey(t *testing.T) {
+	duplicate := &pq.Error{Code: pqUniqueViolation}
+	tests := []struct {
+		name    string
+		key     string
+		logErr  error
+		wantErr error
+	}{
+		{
+			name: "first submission applies changes",
+			key:  "k1",
+		},
+		{
+			name:    "repeated key is already processed without changes",
+			key:     "k1",
+			logErr:  duplicate,
+			wantErr: ErrAlreadyProcessed,
+		},
+		{
+			name:    "duplicate without key is not treated as repeated submission",
+			logErr:  duplicate,
+			wantErr: ErrDuplicate,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store, mock := newMockStore(t)
+			var key interface{}
+			if tt.key != "" {
+				key = tt.key
+			}
+			mock.ExpectBegin()
+			log := mock.ExpectExec(`INSERT INTO action_models`).
+				WithArgs(sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg(), key, sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg())
+			if tt.logErr != nil {
+				log.WillReturnError(tt.logErr)
+				mock.ExpectRollback()
+			} else {
+				log.WillReturnResult(sqlmock.NewResult(0, 1))
+				mock.ExpectPrepare(`INSERT INTO models`).ExpectExec().WillReturnResult(sqlmock.NewResult(0, 1))
+				mock.ExpectCommit()
+			}
+			err := store.RunAction(context.Background(), addAction{}, Params{IdempotencyKey: tt.key})
+			if !errors.Is(err, tt.wantErr) || tt.wantErr == nil && err != nil {
+				t.Fatalf("expected %v, got %v", tt.wantErr, err)
+			} else if tt.wantErr == ErrDuplicate && errors.Is(err, ErrAlreadyProcessed) {
+				t.Fatalf("expected plain duplicate, got %v", err)
+			}
+		})
+	}
+}
@@ -1,21 +1,34 @@
 package active
 
 import (
+	"bytes"
 	"context"
 	"database/sql"
 	"encoding/json"
 	"errors"
+	"fmt"
+	"io"
+	"reflect"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
+	"github.com/avast/retry-go"
 	"github.com/google/uuid"
 	"github.com/jmoiron/sqlx"
 	"github.com/jmoiron/sqlx/types"
-	_ "github.com/lib/pq"
+	"github.com/lib/pq"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 )
 
 type (
 	Params struct {
 		Data struct{}
+
+		// Unique key of action submission, repeated submission is not applied again
+		IdempotencyKey string
 	}
 
 	// Binary data
@@ -24,6 +37,12 @@ type (
 		E error
 	}
 
+	// Identity of cell
+	Key struct {
+		RowId      string
+		ColumnName string
+	}
+
 	// Reference
 	Ref struct {
 		RowId      string
@@ -43,6 +62,11 @@ type (
 		Unmarshall(ref Ref, data types.JSONText) error
 	}
 
+	// Model declaring column it is stored under
+	ColumnNamer interface {
+		ColumnName() string
+	}
+
 	// Base class entity
 	Entity struct {
 		Model
@@ -53,8 +77,22 @@ type (
 	Batch struct {
 		add    []*Entity
 		update []*Entity
+		delete []*Entity
+
+		// Changes in registration order, used instead of lists above in ordered mode
+		ordered bool
+		changes []Change
+
+		// Register clones of entities instead of entities themselves
+		copyOnAdd bool
+
+		// Updates dropped by UpdateChanged
+		unchanged int
 	}
 
+	// Tunes batch
+	BatchOption func(*Batch)
+
 	// Single change
 	Change struct {
 		V *Entity
@@ -63,23 +101,382 @@ type (
 
 	ChangeType int
 
+	// Adjust transaction options
+	TxOption func(*sql.TxOptions)
+
+	// Statement with its bound arguments
+	PlannedStatement struct {
+		SQL  string
+		Args []interface{}
+	}
+
+	// Unit of work producing model changes
 	Action interface {
-		Exec(params Params, batch Batch)
+		Exec(params Params, batch *Batch) error
+	}
+
+	// Models storage
+	Store interface {
+
+		// Apply batch in single transaction
+		ApplyChanges(batch Batch) error
+
+		// Apply batch in single transaction bound to ctx
+		ApplyChangesContext(ctx context.Context, batch Batch, opts ...TxOption) error
+
+		// Apply batch in single transaction reporting what was written
+		ApplyChangesResult(ctx context.Context, batch Batch, opts ...TxOption) (*ApplyResult, error)
+
+		// Apply batch once per fingerprint, repeated fingerprint is a no-op
+		ApplyIdempotent(ctx context.Context, batch Batch, fingerprint string) error
+
+		// Remove fingerprints recorded before given time
+		PurgeFingerprints(ctx context.Context, before time.Time) (int64, error)
+
+		// Apply batch in transactions of at most chunkSize changes
+		ApplyChangesChunked(ctx context.Context, batch Batch, chunkSize int, opts ...ChunkOption) error
+
+		// Apply batch retrying on optimistic lock with default backoff
+		ApplyWithRetry(ctx context.Context, maxAttempts int, reload func() (Batch, error)) error
+
+		// Apply batch retrying on optimistic lock with given backoff
+		ApplyWithRetryOptions(ctx context.Context, maxAttempts int, opts RetryOptions, reload func() (Batch, error)) error
+
+		// Load stored model by row and column
+		Load(ctx context.Context, m Model, rowId, columnName string) (*Entity, error)
+
+		// Load stored models of many keys at once, missing keys are omitted
+		LoadMany(ctx context.Context, keys []Key, factory func(Key) Model) (map[Key]*Entity, error)
+
+		// Load stored model by row and column even if it was soft deleted
+		LoadIncludingDeleted(ctx context.Context, m Model, rowId, columnName string) (*Entity, error)
+
+		// Mark entity deleted keeping its row
+		SoftDelete(ctx context.Context, e *Entity) error
+
+		// Run action and apply its changes together with action log
+		RunAction(ctx context.Context, action Action, params Params) error
+
+		// Run action at serializable isolation retrying serialization failures
+		RunActionSerializable(ctx context.Context, action Action, params Params, maxAttempts int) error
+
+		// Record action in log without changes
+		LogAction(ctx context.Context, name string, params Params) error
+
+		// Insert or overwrite entity regardless of version
+		Upsert(ctx context.Context, e *Entity) error
+
+		// Insert entity never stored before or update it otherwise
+		Save(ctx context.Context, e *Entity) error
+
+		// Load model or store one made by init when missing, reporting whether it was created
+		LoadOrCreate(ctx context.Context, m Model, rowId, columnName string, init func() Model) (*Entity, bool, error)
+
+		// Save entity unless it equals baseline
+		SaveChanged(ctx context.Context, e, baseline *Entity) error
+
+		// Merge top level fields into stored JSON data without rewriting it
+		PatchJSON(ctx context.Context, ref Ref, patch map[string]any) error
+
+		// Find entities of column by top level field of their JSON data
+		FindByJSON(ctx context.Context, columnName, jsonPath string, value any, factory func() Model) ([]*Entity, error)
+
+		// Delete entities of column by top level field of their JSON data, without optimistic locking
+		DeleteWhere(ctx context.Context, columnName, jsonPath string, value any) (int64, error)
+
+		// Create tables store works with unless they exist
+		EnsureSchema(ctx context.Context) error
+
+		// Remove all rows of tables, test fixtures only
+		Truncate(ctx context.Context, tables ...string) error
+
+		// Rewrite stored JSON data of column with transform, returns number of changed cells
+		MigrateData(ctx context.Context, columnName string, transform func(types.JSONText) (types.JSONText, error)) (int64, error)
+
+		// Page of entities of column ordered by creation, with cursor of next page
+		List(ctx context.Context, columnName string, opts ListOptions) ([]*Entity, string, error)
+
+		// Iterate entities of column without loading them all at once
+		Stream(ctx context.Context, columnName string, factory func() Model) (*EntityIterator, error)
+
+		// Archived versions of cell, oldest first
+		History(ctx context.Context, rowId, columnName string) ([]VersionRecord, error)
+
+		// Check primary database is reachable
+		Ping(ctx context.Context) error
+
+		// Wait for in-flight transactions and close databases, new ones fail with ErrClosed
+		Close(ctx context.Context) error
+
+		// Run fn with reads and writes sharing single transaction
+		WithTx(ctx context.Context, fn func(tx Tx) error) error
+
+		// Write not deleted cells of column as NDJSON, returns number of lines
+		ExportNDJSON(ctx context.Context, columnName string, w io.Writer) (int64, error)
+
+		// Upsert cells of NDJSON written by ExportNDJSON, returns number of lines
+		ImportNDJSON(ctx context.Context, r io.Reader) (int64, error)
+
+		// Bump version and updated_at of cell keeping its data
+		Touch(ctx context.Context, ref Ref) error
+
+		// Keys of refs whose stored version differs
+		PrecheckVersions(ctx context.Context, refs []Ref) ([]Key, error)
+
+		// Blob with ref and stored data of cell, for Restore
+		Snapshot(ctx context.Context, rowId, columnName string) ([]byte, error)
+
+		// Write cell of snapshot back, newer cell is overwritten only with force
+		Restore(ctx context.Context, blob []byte, force bool) error
+
+		// Load model locking its row until transaction of tx ends
+		LoadForUpdate(ctx context.Context, tx Tx, m Model, rowId, columnName string) (*Entity, error)
+
+		// Run fn with reads sharing single snapshot
+		InReadTx(ctx context.Context, fn func(ReadStore) error) error
+
+		// Run fn in transaction, store calls made with ctx passed to fn join it
+		InTx(ctx context.Context, fn func(ctx context.Context) error, opts ...TxOption) error
+
+		// Statements batch would execute, without executing them
+		Plan(batch Batch) ([]PlannedStatement, error)
+
+		// Changes applied by logged action
+		AffectedBy(ctx context.Context, actionId string) ([]Change, error)
+
+		// Page of logged actions newest first and cursor of the next one, empty after the last page
+		Actions(ctx context.Context, limit int, cursor string) ([]ActionRecord, string, error)
+
+		// Number of cells stored under column
+		Count(ctx context.Context, columnName string) (int64, error)
+
+		// Number of cells stored under row
+		CountByRow(ctx context.Context, rowId string) (int64, error)
+
+		// Check cell presence without loading its data
+		Exists(ctx context.Context, rowId, columnName string) (bool, error)
+
+		// Cells of row by column name, data kept in RawModel
+		LoadRow(ctx context.Context, rowId string) (map[string]*Entity, error)
+
+		// Save entities of row keyed by column name atomically, stale column rolls back all of them
+		SaveRow(ctx context.Context, rowId string, entities map[string]*Entity) error
 	}
 )
 
 const (
 	AddChangeType = ChangeType(iota)
 	UpdateChangeType
+	DeleteChangeType
+)
+
+const (
+	pqUniqueViolation      = pq.ErrorCode("23505")
+	pqSerializationFailure = pq.ErrorCode("40001")
+	pqDeadlockDetected     = pq.ErrorCode("40P01")
 )
 
+func (t ChangeType) String() string {
+	switch t {
+	case AddChangeType:
+		return "add"
+	case UpdateChangeType:
+		return "update"
+	case DeleteChangeType:
+		return "delete"
+	default:
+		return fmt.Sprintf("ChangeType(%d)", int(t))
+	}
+}
+
 var (
-	ErrOptimisticLock               = errors.New("model: optimistic lock")
-	_defaultLvl       sql.TxOptions = sql.TxOptions{Isolation: sql.LevelDefault, ReadOnly: false}
+	ErrOptimisticLock                 = errors.New("model: optimistic lock")
+	ErrNotFound                       = errors.New("model: not found")
+	ErrDuplicate                      = errors.New("model: duplicate")
+	ErrAlreadyProcessed               = errors.New("model: action already processed")
+	_defaultLvl         sql.TxOptions = sql.TxOptions{Isolation: sql.LevelDefault, ReadOnly: false}
 )
 
+func (k Key) String() string {
+	return k.RowId + "/" + k.ColumnName
+}
+
+// Order keys by row, then by column: -1 when k goes first, 0 when equal, +1 otherwise
+func (k Key) Compare(other Key) int {
+	if c := strings.Compare(k.RowId, other.RowId); c != 0 {
+		return c
+	}
+	return strings.Compare(k.ColumnName, other.ColumnName)
+}
+
+// Identity of referenced cell
+func (r Ref) Key() Key {
+	return Key{RowId: r.RowId, ColumnName: r.ColumnName}
+}
+
+// Create empty batch, changes are applied grouped: adds, then updates, then deletes
+func NewBatch(opts ...BatchOption) *Batch {
+	b := &Batch{}
+	for _, opt := range opts {
+		opt(b)
+	}
+	return b
+}
+
+// Create empty batch applying changes in exact order they were registered, so update
+// may depend on add made earlier in the same batch
+func NewOrderedBatch(opts ...BatchOption) *Batch {
+	b := NewBatch(opts...)
+	b.ordered = true
+	return b
+}
+
+// Register clone of every entity, so caller may keep mutating the original. Applied clones
+// are not written back, originals keep versions they were registered with
+func WithCopyOnAdd() BatchOption {
+	return func(b *Batch) {
+		b.copyOnAdd = true
+	}
+}
+
+// Register new entity to insert
+func (b *Batch) Add(e *Entity) *Batch {
+	return b.push(Change{V: e, T: AddChangeType})
+}
+
+// Register existing entity to update
+func (b *Batch) Update(e *Entity) *Batch {
+	return b.push(Change{V: e, T: UpdateChangeType})
+}
+
+// Register existing entity to update unless it equals baseline, the state it was loaded in
+func (b *Batch) UpdateChanged(e, baseline *Entity) *Batch {
+	if e.Equals(baseline) {
+		b.unchanged++
+		return b
+	}
+	return b.Update(e)
+}
+
+// Register existing entity to delete
+func (b *Batch) Delete(e *Entity) *Batch {
+	return b.push(Change{V: e, T: DeleteChangeType})
+}
+
+func (b *Batch) push(c Change) *Batch {
+	if b.copyOnAdd {
+		c.V = c.V.Clone()
+	}
+	if b.ordered {
+		b.changes = append(b.changes, c)
+		return b
+	}
+	switch c.T {
+	case AddChangeType:
+		b.add = append(b.add, c.V)
+	case UpdateChangeType:
+		b.update = append(b.update, c.V)
+	case DeleteChangeType:
+		b.delete = append(b.delete, c.V)
+	}
+	return b
+}
+
+// Number of changes in batch
+func (b *Batch) Len() int {
+	return len(b.changes) + len(b.add) + len(b.update) + len(b.delete)
+}
+
+// Append changes of other batch and collapse duplicates, see Dedup
+func (b *Batch) Merge(other Batch) *Batch {
+	for _, c := range other.Items() {
+		b.push(c)
+	}
+	b.unchanged += other.unchanged
+	return b.Dedup()
+}
+
+// Keep single change per (row_id, column_name): delete supersedes update, update supersedes add,
+// later entity of same kind wins keeping position of first one
+func (b *Batch) Dedup() *Batch {
+	if b.ordered {
+		b.changes = dedupChanges(b.changes)
+		return b
+	}
+	deleted := map[Key]bool{}
+	b.delete = dedupEntities(b.delete, deleted)
+	updated := map[Key]bool{}
+	for k := range deleted {
+		updated[k] = true
+	}
+	b.update = dedupEntities(b.update, updated)
+	b.add = dedupEntities(b.add, updated)
+	return b
+}
+
+// Collapse entities with same key, entities with keys in seen are dropped.
+// Keys of kept entities are added to seen
+func dedupEntities(arr []*Entity, seen map[Key]bool) []*Entity {
+	var (
+		result []*Entity
+		pos    = map[Key]int{}
+	)
+	for _, e := range arr {
+		k := e.Ref.Key()
+		if i, ok := pos[k]; ok {
+			result[i] = e
+		} else if !seen[k] {
+			pos[k] = len(result)
+			result = append(result, e)
+		}
+	}
+	for k := range pos {
+		seen[k] = true
+	}
+	return result
+}
+
+// Dedup of ordered changes, change types are declared in order of precedence
+func dedupChanges(arr []Change) []Change {
+	winner := map[Key]ChangeType{}
+	for _, c := range arr {
+		if t, ok := winner[c.V.Ref.Key()]; !ok || c.T > t {
+			winner[c.V.Ref.Key()] = c.T
+		}
+	}
+	var (
+		result []Change
+		pos    = map[Key]int{}
+	)
+	for _, c := range arr {
+		k := c.V.Ref.Key()
+		if c.T != winner[k] {
+			continue
+		} else if i, ok := pos[k]; ok {
+			result[i] = c
+		} else {
+			pos[k] = len(result)
+			result = append(result, c)
+		}
+	}
+	return result
+}
+
+// Copy of batch holding clones of its entities
+func (b Batch) Clone() Batch {
+	c := Batch{ordered: b.ordered, unchanged: b.unchanged}
+	for _, change := range b.Items() {
+		c.push(Change{V: change.V.Clone(), T: change.T})
+	}
+	c.copyOnAdd = b.copyOnAdd
+	return c
+}
+
 // All chages available in batch
 func (b *Batch) Items() []Change {
+	if b.ordered {
+		return append([]Change(nil), b.changes...)
+	}
 	var arr []Change
 	for _, e := range b.add {
 		arr = append(arr, Change{V: e, T: AddChangeType})
@@ -87,120 +484,821 @@ func (b *Batch) Items() []Change {
 	for _, e := range b.update {
 		arr = append(arr, Change{V: e, T: UpdateChangeType})
 	}
+	for _, e := range b.delete {
+		arr = append(arr, Change{V: e, T: DeleteChangeType})
+	}
 	return arr
 }
 
 type pg struct {
-	db *sqlx.DB
+	db                *sqlx.DB
+	replicas          []*sqlx.DB
+	nextReplica       atomic.Uint64
+	lifecycle         sync.Mutex
+	closed            bool
+	inflight          sync.WaitGroup
+	pool              *PoolOptions
+	resolver          ConflictResolver
+	statementTimeout  time.Duration
+	defaultTimeout    time.Duration
+	schemaResolver    func(ctx context.Context) string
+	json              jsonFuncs
+	emptyJSON         string
+	lock              LockStrategy
+	truncateAllowed   bool
+	fingerprintTable  string
+	dialect           Dialect
+	table             string
+	actionTable       string
+	historyTable      string
+	versioning        bool
+	sql               statements
+	codec             Codec
+	codecs            map[string]Codec
+	compressor        Compressor
+	cipher            Cipher
+	ciphers           map[string]Cipher
+	compressThreshold int
+	maxParams         int
+	lenientDecode     bool
+	insertReturning   bool
+	backoff           Backoff
+	slowThreshold     time.Duration
+	slowHandler       SlowQueryHandler
+	correlationKey    any
+	strictVersions    bool
+	notifyChannel     string
+	multiRowPolicy    MultiRowPolicy
+	versionPrecheck   bool
+	rawSchemas        map[string][]byte
+	schemas           map[string]SchemaValidator
+	schemaCompiler    SchemaCompiler
+	now               func() time.Time
+	logger            Logger
+	tracer            trace.Tracer
+	metrics           *metrics
+	txRetries         int
+	txBackoff         RetryOptions
+	connRetries       int
+	connBackoff       RetryOptions
+	routes            sync.Map
+	routeCount        atomic.Int64
+	newID             func() (uuid.UUID, error)
+	err               error
 }
 
-func (pg *pg) ApplyChanges(batch Batch) error {
-	return pg.inTx(context.Background(), func(tx *sqlx.Tx) error {
-		for _, change := range batch.Items() {
-			switch change.T {
-			case AddChangeType:
-				if err := add(tx, change.V); err != nil {
-					return err
-				}
-			case UpdateChangeType:
-				if err := update(tx, change.V); err != nil {
-					return err
-				}
-			}
+var _ Store = (*pg)(nil)
+
+// Create Postgres backed store
+func New(db *sqlx.DB, opts ...Option) Store {
+	return NewWithDialect(db, Postgres, opts...)
+}
+
+// Create store speaking SQL of given dialect, nil means Postgres
+func NewWithDialect(db *sqlx.DB, d Dialect, opts ...Option) Store {
+	if d == nil {
+		d = Postgres
+	}
+	aPg := newPg(db, d)
+	for _, opt := range opts {
+		opt(aPg)
+	}
+	// applied once options are known, so replicas registered in any order get tuned too
+	if aPg.pool != nil {
+		aPg.pool.apply(db)
+		for _, replica := range aPg.replicas {
+			aPg.pool.apply(replica)
 		}
+	}
+	aPg.compileSchemas()
+	aPg.sql = buildStatements(aPg.dialect, aPg.table, aPg.actionTable, aPg.historyTable, aPg.fingerprintTable)
+	return aPg
+}
+
+// Store with default settings options are applied to
+func newPg(db *sqlx.DB, d Dialect) *pg {
+	return &pg{
+		db:               db,
+		dialect:          d,
+		table:            defaultTable,
+		actionTable:      defaultActionTable,
+		historyTable:     defaultVersionsTable,
+		fingerprintTable: defaultFingerprintTable,
+		codec:            JSON,
+		codecs:           map[string]Codec{JSONFormat: JSON},
+		ciphers:          map[string]Cipher{},
+		json:             stdJSON,
+		emptyJSON:        "null",
+		maxParams:        DefaultMaxParams,
+		schemaCompiler:   DefaultSchemaCompiler,
+		now:              time.Now,
+		newID:            uuid.NewRandom,
+		logger:           nopLogger{},
+		tracer:           trace.NewNoopTracerProvider().Tracer(""),
+	}
+}
+
+func (pg *pg) ApplyChanges(batch Batch) error {
+	return pg.ApplyChangesContext(context.Background(), batch)
+}
+
+// Apply all batch changes in single transaction bound to ctx
+func (pg *pg) ApplyChangesContext(ctx context.Context, batch Batch, opts ...TxOption) (err error) {
+	started := time.Now()
+	defer func() {
+		pg.metrics.observeApply(started, err)
+	}()
+	items := batch.Items()
+	if len(items) == 0 {
 		return nil
+	} else if err := validate(items); err != nil {
+		return err
+	}
+	return pg.inTxOpts(ctx, opts, func(ctx context.Context, tx *sqlx.Tx) error {
+		return pg.apply(ctx, tx, items)
+	})
+}
+
+// Apply batch splitting it into transactions of at most chunkSize changes, order is preserved.
+// Chunks committed before failing one stay committed, PartialError reports them when ctx expires.
+// Non positive chunkSize applies all at once
+func (pg *pg) ApplyChangesChunked(ctx context.Context, batch Batch, chunkSize int, opts ...ChunkOption) error {
+	return applyChunked(ctx, batch, chunkSize, opts, func(changes []Change) error {
+		return pg.inTx(ctx, func(ctx context.Context, tx *sqlx.Tx) error {
+			return pg.apply(ctx, tx, changes)
+		})
 	})
 }
 
-func (p *pg) inTx(ctx context.Context, fn func(tx *sqlx.Tx) error) error {
-	if tx, err := p.db.BeginTxx(ctx, &_defaultLvl); err != nil {
+// Run action and apply its changes together with action log in single transaction
+func (pg *pg) RunAction(ctx context.Context, action Action, params Params) error {
+	return pg.inTx(ctx, func(ctx context.Context, tx *sqlx.Tx) error {
+		return pg.runAction(ctx, tx, action, params)
+	})
+}
+
+// Run action at serializable isolation, whole transaction including action Exec is run again
+// on serialization failure, at most maxAttempts times. Within transaction bound to ctx isolation
+// of that transaction applies and failure is not retried
+func (pg *pg) RunActionSerializable(ctx context.Context, action Action, params Params, maxAttempts int) error {
+	if maxAttempts < 1 {
+		maxAttempts = 1
+	}
+	opts := []TxOption{WithIsolation(sql.LevelSerializable)}
+	return retry.Do(func() error {
+		return pg.inTxOpts(ctx, opts, func(ctx context.Context, tx *sqlx.Tx) error {
+			return pg.runAction(ctx, tx, action, params)
+		})
+	}, retryOptions(ctx, maxAttempts, pg.backoffOr(jittered(DefaultRetryOptions)), func(err error) bool {
+		var pqErr *pq.Error
+		return txFromContext(ctx) == nil && errors.As(err, &pqErr) && pqErr.Code == pqSerializationFailure
+	})...)
+}
+
+func (pg *pg) runAction(ctx context.Context, tx *sqlx.Tx, action Action, params Params) error {
+	batch := NewBatch()
+	if err := action.Exec(params, batch); err != nil {
 		return err
-	} else {
-		if err := fn(tx); err != nil {
-			defer tx.Rollback()
+	} else if err := validate(batch.Items()); err != nil {
+		return err
+	}
+	// log goes first, so repeated submission is refused before changes are applied
+	doc, err := pg.affectedDoc(batch.Items())
+	if err != nil {
+		return err
+	} else if err := pg.writeLog(ctx, tx, actionName(action), params, doc); err != nil {
+		return err
+	}
+	return pg.apply(ctx, tx, batch.Items())
+}
+
+// Apply changes within transaction reusing prepared statements, which are closed before return
+func (pg *pg) apply(ctx context.Context, tx *sqlx.Tx, changes []Change) (err error) {
+	if err := pg.checkParams(changes); err != nil {
+		return err
+	} else if err := pg.checkSchemas(changes); err != nil {
+		return err
+	} else if err := pg.precheck(ctx, tx, changes); err != nil {
+		return err
+	}
+	stmts := newStmtCache(tx)
+	stmts.observe = pg.observeSlow
+	defer func() {
+		if closeErr := stmts.Close(); err == nil {
+			err = closeErr
+		}
+	}()
+
+	for _, group := range pg.groupChanges(changes) {
+		if len(group) > 1 && group[0].T == UpdateChangeType {
+			if err := pg.applyUpdates(ctx, stmts, group); err != nil {
+				return err
+			}
+		} else if len(group) > 1 {
+			if err := pg.applyAdds(ctx, stmts, group); err != nil {
+				return err
+			}
+		} else if err := pg.applyOne(ctx, stmts, group[0]); err != nil {
 			return err
-		} else {
-			return tx.Commit()
 		}
 	}
+	return pg.notify(ctx, stmts, changes)
 }
 
-const (
-	sqlActionsInsert = `INSERT INTO action_models (row_id, name, data, created_at) VALUES ($1, $2, $3, $4)`
+func (pg *pg) applyOne(ctx context.Context, tx execer, change Change) (err error) {
+	ctx, span := pg.tracer.Start(ctx, "active."+change.T.String(), trace.WithAttributes(
+		attribute.String("row_id", change.V.Ref.RowId),
+		attribute.String("column_name", change.V.Ref.ColumnName),
+		attribute.Int64("version", int64(change.V.Ref.Version))))
+	defer func() {
+		endSpan(span, err)
+	}()
 
-	sqlGet    = `SELECT row_id, column_name, version, data, created_at, updated_at FROM models WHERE row_id = $1 AND column_name = $2`
-	sqlInsert = `INSERT INTO models (row_id, column_name, version, data, created_at, updated_at) VALUES ($1, $2, $3, $4, $5)`
-	sqlUpdate = `UPDATE models 
-		SET data = $1, version = $2, updated_at = $3 
-		WHERE row_id = $4 AND column_name = $5 AND version = $6`
-)
+	if err = beforeChange(ctx, change); err == nil {
+		switch change.T {
+		case AddChangeType:
+			err = pg.add(ctx, tx, change.V)
+		case UpdateChangeType:
+			err = pg.update(ctx, tx, change.V)
+		case DeleteChangeType:
+			err = pg.remove(ctx, tx, change.V)
+		}
+		if err == nil {
+			afterChange(ctx, change)
+		}
+	}
+	pg.logger.LogChange(ctx, change, err)
+	pg.metrics.observeChange(err)
+	return err
+}
+
+// Log changes of group each with its own error, changes past errors were not attempted
+func (pg *pg) logChanges(ctx context.Context, changes []Change, errs []error) {
+	for i, err := range errs {
+		pg.logger.LogChange(ctx, changes[i], err)
+		pg.metrics.observeChange(err)
+	}
+}
+
+// Errors of group failed by statement all of its changes were part of
+func sameErr(errs []error, err error) []error {
+	for i := range errs {
+		errs[i] = err
+	}
+	return errs
+}
+
+// Load stored model by row and column, soft deleted models are not found
+func (pg *pg) Load(ctx context.Context, m Model, rowId, columnName string) (*Entity, error) {
+	ctx, err := pg.enter(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return pg.load(ctx, pg.q(ctx).get, m, rowId, columnName)
+}
+
+// Load stored model by row and column even if it was soft deleted
+func (pg *pg) LoadIncludingDeleted(ctx context.Context, m Model, rowId, columnName string) (*Entity, error) {
+	ctx, err := pg.enter(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return pg.load(ctx, pg.q(ctx).getAny, m, rowId, columnName)
+}
+
+func (pg *pg) load(ctx context.Context, query string, m Model, rowId, columnName string) (*Entity, error) {
+	ctx, cancel := pg.withDefaultTimeout(ctx)
+	defer cancel()
+	if aCell, err := get(ctx, pg.queryer(ctx), query, rowId, columnName); err != nil {
+		return nil, err
+	} else if err := pg.decode(aCell, m); err != nil {
+		return nil, err
+	} else {
+		return &Entity{Model: m, Ref: aCell.toRef()}, nil
+	}
+}
+
+func get(ctx context.Context, db sqlx.QueryerContext, query, row, col string) (cell, error) {
+	aCell := cell{}
+	if err := sqlx.GetContext(ctx, db, &aCell, query, row, col); errors.Is(err, sql.ErrNoRows) {
+		return cell{}, ErrNotFound
+	} else if err != nil {
+		return cell{}, err
+	}
+	return aCell, nil
+}
 
-func get(tx *sqlx.DB, row, col string) error {
-	aCell := &cell{}
-	if err := tx.Get(aCell, sqlGet, row, col); err != nil {
+// Single row of models table
+type cell struct {
+	RowId      string         `db:"row_id"`
+	ColumnName string         `db:"column_name"`
+	Version    uint           `db:"version"`
+	Data       types.JSONText `db:"data"`
+	Format     sql.NullString `db:"format"`
+	Compressed bool           `db:"compressed"`
+	KeyId      sql.NullString `db:"key_id"`
+	CreatedAt  time.Time      `db:"created_at"`
+	UpdatedAt  time.Time      `db:"updated_at"`
+}
+
+func (c cell) toRef() Ref {
+	return Ref{
+		RowId:      c.RowId,
+		ColumnName: c.ColumnName,
+		CreatedAt:  c.CreatedAt,
+		UpdatedAt:  c.UpdatedAt,
+		Version:    c.Version,
+	}
+}
+
+func (pg *pg) add(ctx context.Context, tx execer, entity *Entity) error {
+	absent := insertingAbsent(ctx)
+	if pg.insertReturning && !absent {
+		return pg.addReturning(ctx, tx, entity)
+	}
+	now := pg.timestamp()
+	st, err := pg.insertStatement(ctx, entity, now)
+	if err != nil {
 		return err
+	} else if absent {
+		st.SQL = pg.q(ctx).insertAbsent
+	}
+	if r, err := tx.ExecContext(ctx, st.SQL, st.Args...); err != nil {
+		return wrapErr("insert", entity.Ref, err)
+	} else if absent {
+		if num, err := r.RowsAffected(); err != nil {
+			return err
+		} else if num == 0 {
+			return wrapErr("insert", entity.Ref, ErrDuplicate)
+		}
 	}
+	stampInsert(ctx, entity, now)
 	return nil
 }
 
-type cell struct {
+func (pg *pg) update(ctx context.Context, tx execer, entity *Entity) error {
+	err := pg.updateVersion(ctx, tx, entity)
+	if pg.resolver != nil && errors.Is(err, ErrOptimisticLock) {
+		return pg.resolveConflict(ctx, tx, entity)
+	}
+	return err
 }
 
-func add(tx *sqlx.Tx, entity *Entity) error {
-	if item := entity.Marshall(); item.E != nil {
-		return item.E
-	} else if _, err := tx.Exec(sqlInsert,
-		entity.Ref.RowId,
-		entity.Ref.ColumnName,
-		entity.Ref.Version,
-		item.V,
-		entity.Ref.CreatedAt,
-		entity.Ref.UpdatedAt); err != nil {
+func (pg *pg) updateVersion(ctx context.Context, tx execer, entity *Entity) error {
+	if pg.strictVersions {
+		if err := pg.checkVersion(ctx, tx, entity); err != nil {
+			return err
+		}
+	}
+	if pg.versioning {
+		if err := pg.archive(ctx, tx, entity); err != nil {
+			return err
+		}
+	}
+	now := pg.timestamp()
+	if st, err := pg.updateStatement(ctx, entity, now); err != nil {
+		return err
+	} else if err := pg.execUpdate(ctx, tx, st, entity.Ref); err != nil {
 		return err
 	}
+	stampUpdate(ctx, entity, now)
 	return nil
 }
 
-func update(tx *sqlx.Tx, entity *Entity) error {
-	if item := entity.Marshall(); item.E != nil {
-		return item.E
-	} else if r, err := tx.Exec(sqlUpdate,
-		item.V,
-		entity.Ref.Version+1,
-		entity.Ref.UpdatedAt,
+// Statements batch would execute, entities are left untouched and database is not accessed
+func (pg *pg) Plan(batch Batch) ([]PlannedStatement, error) {
+	if pg.err != nil {
+		return nil, pg.err
+	} else if err := pg.checkParams(batch.Items()); err != nil {
+		return nil, err
+	}
+	var (
+		arr []PlannedStatement
+		now = pg.timestamp()
+		ctx = context.Background()
+	)
+	for _, group := range pg.groupChanges(batch.Items()) {
+		var (
+			st  PlannedStatement
+			err error
+		)
+		if len(group) > 1 {
+			entities := make([]*Entity, len(group))
+			for i, change := range group {
+				entities[i] = &Entity{Model: change.V.Model, Ref: change.V.Ref}
+				if pg.versioning && change.T == UpdateChangeType {
+					arr = append(arr, pg.archiveStatement(ctx, entities[i]))
+				}
+			}
+			if group[0].T == UpdateChangeType {
+				st, err = pg.updateManyStatement(ctx, entities, now)
+			} else {
+				st, err = pg.insertManyStatement(ctx, entities, now)
+			}
+		} else {
+			if pg.versioning && group[0].T == UpdateChangeType {
+				arr = append(arr, pg.archiveStatement(ctx, group[0].V))
+			}
+			st, err = pg.statement(ctx, group[0].T, &Entity{Model: group[0].V.Model, Ref: group[0].V.Ref}, now)
+		}
+		if err != nil {
+			return nil, err
+		}
+		arr = append(arr, st)
+	}
+	return arr, nil
+}
+
+func (pg *pg) statement(ctx context.Context, t ChangeType, entity *Entity, now time.Time) (PlannedStatement, error) {
+	switch t {
+	case AddChangeType:
+		if pg.insertReturning {
+			return pg.returningStatement(ctx, entity, now)
+		}
+		return pg.insertStatement(ctx, entity, now)
+	case UpdateChangeType:
+		return pg.updateStatement(ctx, entity, now)
+	case DeleteChangeType:
+		return pg.deleteStatement(ctx, entity), nil
+	default:
+		return PlannedStatement{}, fmt.Errorf("unknown change type %s", t)
+	}
+}
+
+// Insert of entity stamped at now, entity itself is stamped by stampInsert once stored
+func (pg *pg) insertStatement(ctx context.Context, entity *Entity, now time.Time) (PlannedStatement, error) {
+	defaultColumnName(entity)
+	ref := insertRef(entity.Ref, now)
+	if p, err := pg.encode(entity.Model); err != nil {
+		return PlannedStatement{}, err
+	} else {
+		return PlannedStatement{SQL: pg.q(ctx).insert, Args: []interface{}{
+			ref.RowId,
+			ref.ColumnName,
+			ref.Version,
+			p.data,
+			pg.codec.Format(),
+			p.compressed,
+			p.keyId,
+			ref.CreatedAt,
+			ref.UpdatedAt,
+		}}, nil
+	}
+}
+
+// Update of entity stamped at now, loaded UpdatedAt stays in entity as timestamp lock token
+// until stampUpdate
+func (pg *pg) updateStatement(ctx context.Context, entity *Entity, now time.Time) (PlannedStatement, error) {
+	if p, err := pg.encode(entity.Model); err != nil {
+		return PlannedStatement{}, err
+	} else if pg.lock == TimestampLock {
+		return PlannedStatement{SQL: pg.q(ctx).updateByTime, Args: []interface{}{
+			p.data,
+			pg.codec.Format(),
+			p.compressed,
+			p.keyId,
+			now,
+			entity.Ref.RowId,
+			entity.Ref.ColumnName,
+			entity.Ref.UpdatedAt,
+		}}, nil
+	} else {
+		return PlannedStatement{SQL: pg.q(ctx).update, Args: []interface{}{
+			p.data,
+			pg.codec.Format(),
+			p.compressed,
+			p.keyId,
+			entity.Ref.Version + 1,
+			now,
+			entity.Ref.RowId,
+			entity.Ref.ColumnName,
+			entity.Ref.Version,
+		}}, nil
+	}
+}
+
+func (pg *pg) deleteStatement(ctx context.Context, entity *Entity) PlannedStatement {
+	return PlannedStatement{SQL: pg.q(ctx).delete, Args: []interface{}{
 		entity.Ref.RowId,
 		entity.Ref.ColumnName,
-		entity.Ref.Version); err != nil {
+		entity.Ref.Version,
+	}}
+}
+
+// Number of cells stored under column
+func (pg *pg) Count(ctx context.Context, columnName string) (int64, error) {
+	ctx, err := pg.enter(ctx)
+	if err != nil {
+		return 0, err
+	}
+	return pg.count(ctx, pg.q(ctx).countByColumn, columnName)
+}
+
+// Number of cells stored under row
+func (pg *pg) CountByRow(ctx context.Context, rowId string) (int64, error) {
+	ctx, err := pg.enter(ctx)
+	if err != nil {
+		return 0, err
+	}
+	return pg.count(ctx, pg.q(ctx).countByRow, rowId)
+}
+
+func (pg *pg) count(ctx context.Context, query string, arg string) (int64, error) {
+	ctx, cancel := pg.withDefaultTimeout(ctx)
+	defer cancel()
+	var num int64
+	if err := pg.queryer(ctx).QueryRowxContext(ctx, query, arg).Scan(&num); err != nil {
+		return 0, err
+	}
+	return num, nil
+}
+
+// Check cell presence without loading its data
+func (pg *pg) Exists(ctx context.Context, rowId, columnName string) (bool, error) {
+	ctx, err := pg.enter(ctx)
+	if err != nil {
+		return false, err
+	}
+	ctx, cancel := pg.withDefaultTimeout(ctx)
+	defer cancel()
+	var ok bool
+	if err := pg.queryer(ctx).QueryRowxContext(ctx, pg.q(ctx).exists, rowId, columnName).Scan(&ok); err != nil {
+		return false, err
+	}
+	return ok, nil
+}
+
+// Insert entity or overwrite data of existing one bumping its version, without optimistic locking.
+// Requires unique constraint on (row_id, column_name). Resulting version is written back into e.Ref
+func (pg *pg) Upsert(ctx context.Context, e *Entity) error {
+	if err := validate([]Change{{V: e, T: AddChangeType}}); err != nil {
 		return err
-	} else if num, err := r.RowsAffected(); err != nil {
+	}
+	ctx, err := pg.enter(ctx)
+	if err != nil {
+		return err
+	}
+	ctx, cancel := pg.withDefaultTimeout(ctx)
+	defer cancel()
+	ref := e.Ref
+	ref.UpdatedAt = pg.timestamp()
+	if ref.CreatedAt.IsZero() {
+		ref.CreatedAt = ref.UpdatedAt
+	}
+	if p, err := pg.encode(e.Model); err != nil {
 		return err
+	} else if err := pg.writer(ctx).QueryRowxContext(ctx, pg.q(ctx).upsert,
+		ref.RowId,
+		ref.ColumnName,
+		ref.Version,
+		p.data,
+		pg.codec.Format(),
+		p.compressed,
+		p.keyId,
+		ref.CreatedAt,
+		ref.UpdatedAt).Scan(&ref.Version); err != nil {
+		return wrapErr("upsert", e.Ref, err)
+	}
+	onCommit(ctx, func() {
+		e.Ref = ref
+	})
+	return nil
+}
+
+// Insert entity never stored before (zero Version and CreatedAt) or update it otherwise.
+// On success e.Ref tracks stored version
+func (pg *pg) Save(ctx context.Context, e *Entity) error {
+	return saveEntity(ctx, pg.ApplyChangesContext, e)
+}
+
+// Save entity through apply, shared by stores
+func saveEntity(ctx context.Context, apply func(ctx context.Context, batch Batch, opts ...TxOption) error, e *Entity) error {
+	defaultColumnName(e)
+	isNew := e.Ref.Version == 0 && e.Ref.CreatedAt.IsZero()
+	batch := NewBatch()
+	if isNew {
+		batch.Add(e)
 	} else {
-		switch num {
-		case 1:
-			return nil
-		case 0:
-			return ErrOptimisticLock
-		default:
-			return errors.New("panic: more then one record updated")
+		batch.Update(e)
+	}
+	if err := apply(ctx, *batch); errors.Is(err, ErrOptimisticLock) {
+		return wrapErr("save", e.Ref, err)
+	} else if err != nil {
+		return err
+	}
+	onCommit(ctx, func() {
+		if isNew {
+			e.Ref.Version = 0
+		} else {
+			e.Ref.Version++
 		}
+	})
+	return nil
+}
 
+// Save entity unless it equals baseline, the state it was loaded in. Unchanged entity
+// is not written and keeps its version
+func (pg *pg) SaveChanged(ctx context.Context, e, baseline *Entity) error {
+	if e.Equals(baseline) {
+		return nil
 	}
+	return pg.Save(ctx, e)
 }
 
-func writeLog(db *sqlx.DB, name string, params Params) error {
-	b, err := json.Marshal(params.Data)
-	if err != nil {
+// Copy of entity with model rebuilt from its marshalled data. Model which cannot be
+// marshalled and unmarshalled back is shared with the original
+func (e *Entity) Clone() *Entity {
+	if e == nil {
+		return nil
+	}
+	c := &Entity{Model: e.Model, Ref: e.Ref}
+	if e.Model == nil {
+		return c
+	}
+	if item := e.Marshall(); item.E == nil {
+		m := newModelLike(e.Model)
+		if err := m.Unmarshall(e.Ref, append(types.JSONText(nil), item.V...)); err == nil {
+			c.Model = m
+		}
+	}
+	return c
+}
+
+// Same cell in same version holding same marshalled data
+func (e *Entity) Equals(other *Entity) bool {
+	if e == nil || other == nil {
+		return e == other
+	}
+	if e.Ref.RowId != other.Ref.RowId || e.Ref.ColumnName != other.Ref.ColumnName || e.Ref.Version != other.Ref.Version {
+		return false
+	}
+	a, b := e.Marshall(), other.Marshall()
+	return a.E == nil && b.E == nil && bytes.Equal(a.V, b.V)
+}
+
+// Decode marshalled data of entity into T, empty data gives zero T
+func DataTo[T any](e *Entity) (T, error) {
+	var v T
+	item := e.Marshall()
+	if item.E != nil {
+		return v, item.E
+	} else if len(bytes.TrimSpace(item.V)) == 0 {
+		return v, nil
+	}
+	err := json.Unmarshal(item.V, &v)
+	return v, err
+}
+
+// Fill empty column name from ColumnNamer or, failing that, model type name
+func defaultColumnName(entity *Entity) {
+	if entity.Ref.ColumnName != "" {
+		return
+	}
+	if namer, ok := entity.Model.(ColumnNamer); ok {
+		entity.Ref.ColumnName = namer.ColumnName()
+	} else if t := reflect.TypeOf(entity.Model); t != nil {
+		for t.Kind() == reflect.Ptr {
+			t = t.Elem()
+		}
+		entity.Ref.ColumnName = t.Name()
+	}
+}
+
+// Ref fresh entity is stored with, caller provided CreatedAt is kept as is
+func insertRef(ref Ref, now time.Time) Ref {
+	if ref.CreatedAt.IsZero() {
+		ref.CreatedAt = now
+		ref.UpdatedAt = now
+		ref.Version = 0
+	} else if ref.UpdatedAt.IsZero() {
+		ref.UpdatedAt = ref.CreatedAt
+	}
+	return ref
+}
+
+// Stamp inserted entity once transaction commits, failed insert leaves it new so it can be saved again
+func stampInsert(ctx context.Context, entity *Entity, now time.Time) {
+	ref := insertRef(entity.Ref, now)
+	onCommit(ctx, func() {
+		entity.Ref.CreatedAt, entity.Ref.UpdatedAt, entity.Ref.Version = ref.CreatedAt, ref.UpdatedAt, ref.Version
+	})
+}
+
+// Stamp updated entity once transaction commits, failed update keeps UpdatedAt it was loaded with
+func stampUpdate(ctx context.Context, entity *Entity, now time.Time) {
+	onCommit(ctx, func() {
+		entity.Ref.UpdatedAt = now
+	})
+}
+
+func (pg *pg) remove(ctx context.Context, tx execer, entity *Entity) error {
+	st := pg.deleteStatement(ctx, entity)
+	if r, err := tx.ExecContext(ctx, st.SQL, st.Args...); err != nil {
+		return wrapErr("delete", entity.Ref, err)
+	} else {
+		return pg.expectOne(ctx, r, entity.Ref)
+	}
+}
+
+// Mark entity deleted bumping its version, row stays in table with deleted_at set.
+// Requires nullable deleted_at timestamp column on models table
+func (pg *pg) SoftDelete(ctx context.Context, e *Entity) error {
+	if err := validate([]Change{{V: e, T: DeleteChangeType}}); err != nil {
 		return err
 	}
-	_, err = db.Exec(sqlActionsInsert, uuid.NewString(), name, b, time.Now())
-	return err
+	now := pg.timestamp()
+	return pg.inTx(ctx, func(ctx context.Context, tx *sqlx.Tx) error {
+		if r, err := tx.ExecContext(ctx, pg.q(ctx).softDelete,
+			now,
+			e.Ref.Version+1,
+			now,
+			e.Ref.RowId,
+			e.Ref.ColumnName,
+			e.Ref.Version); err != nil {
+			return wrapErr("soft delete", e.Ref, err)
+		} else if err := pg.expectOne(ctx, r, e.Ref); err != nil {
+			return err
+		}
+		onCommit(ctx, func() {
+			e.Ref.Version++
+			e.Ref.UpdatedAt = now
+		})
+		return nil
+	})
+}
+
+// Annotate driver error with cell, unique violation also matches ErrDuplicate
+func wrapErr(op string, ref Ref, err error) error {
+	return wrapOpErr(fmt.Sprintf("%s %s", op, ref.Key()), err)
+}
+
+func wrapOpErr(op string, err error) error {
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) && pqErr.Code == pqUniqueViolation {
+		return fmt.Errorf("%s: %w: %w", op, ErrDuplicate, err)
+	}
+	return fmt.Errorf("%s: %w", op, err)
+}
+
+// Versioned statement must touch exactly one row
+func (pg *pg) expectOne(ctx context.Context, r sql.Result, ref Ref) error {
+	if num, err := r.RowsAffected(); err != nil {
+		return err
+	} else {
+		return pg.expectRows(ctx, ref, num)
+	}
 }
 
-// func runAction(pg *pg, action Action, params Params) {
-// 	batch := Batch{}
+func (pg *pg) expectRows(ctx context.Context, ref Ref, num int64) error {
+	switch num {
+	case 1:
+		return nil
+	case 0:
+		return ErrOptimisticLock
+	default:
+		return pg.multiRow(ctx, ref, num)
+	}
+}
 
-// 	pg.inTx(context.Background(), func(tx *sqlx.Tx) error {
+// Record action in log, standalone or joining transaction bound to ctx
+func (pg *pg) LogAction(ctx context.Context, name string, params Params) error {
+	return pg.inTx(ctx, func(ctx context.Context, tx *sqlx.Tx) error {
+		return pg.writeLog(ctx, tx, name, params, types.NullJSONText{})
+	})
+}
 
-// 	})
+// Record action together with cells it changes and correlation id of ctx in single log row,
+// within transaction applying the changes. Actions logged without running them keep NULL changes
+func (pg *pg) writeLog(ctx context.Context, tx *sqlx.Tx, name string, params Params, changes types.NullJSONText) error {
+	b, err := pg.json.marshal(params.Data)
+	if err != nil {
+		return err
+	}
+	var key, corrId sql.NullString
+	if params.IdempotencyKey != "" {
+		key = sql.NullString{String: params.IdempotencyKey, Valid: true}
+	}
+	if id := pg.correlationID(ctx); id != "" {
+		corrId = sql.NullString{String: id, Valid: true}
+	}
+	id, err := pg.newID()
+	if err != nil {
+		return err
+	}
+	if _, err := tx.ExecContext(ctx, pg.q(ctx).actionInsert, id.String(), name, b, key, changes, corrId, pg.timestamp()); err != nil {
+		err = wrapOpErr("log action "+name, err)
+		if key.Valid && errors.Is(err, ErrDuplicate) {
+			return fmt.Errorf("action %s with key %s: %w", name, key.String, ErrAlreadyProcessed)
+		}
+		return err
+	}
+	return nil
+}
 
-// }
+// Action name stored in log, actions may override it with Name() method
+func actionName(action Action) string {
+	if named, ok := action.(interface{ Name() string }); ok {
+		return named.Name()
+	}
+	return fmt.Sprintf("%T", action)
+}
@@ -0,0 +1,44 @@
+package active
+
+import (
+	"context"
+	"sync"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/jmoiron/sqlx"
+)
+
+type (
+	// Logger recording errors changes were logged with by key
+	recordingLogger struct {
+		mu   sync.Mutex
+		errs map[Key][]error
+	}
+)
+
+// Store over mocked database, unmet expectations fail the test once it ends
+func newMockStore(t testing.TB, opts ...Option) (*pg, sqlmock.Sqlmock) {
+	t.Helper()
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		if err := mock.ExpectationsWereMet(); err != nil {
+			t.Error(err)
+		}
+		db.Close()
+	})
+	return New(sqlx.NewDb(db, "postgres"), opts...).(*pg), mock
+}
+
+func newRecordingLogger() *recordingLogger {
+	return &recordingLogger{errs: map[Key][]error{}}
+}
+
+func (l *recordingLogger) LogChange(_ context.Context, c Change, err error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.errs[c.V.Ref.Key()] = append(l.errs[c.V.Ref.Key()], err)
+}
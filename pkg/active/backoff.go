@@ -0,0 +1,88 @@
+package active
+
+import (
+	"math"
+	"math/rand"
+	"time"
+)
+
+type (
+	// Delay before next attempt of every retry loop of store
+	Backoff interface {
+		// Delay after attempt failed, attempt counts from 1
+		Next(attempt int) time.Duration
+	}
+
+	// Same delay after every attempt
+	ConstantBackoff struct {
+		Delay time.Duration
+	}
+
+	// Delay doubling from Base after every attempt, never more than Cap when Cap is positive
+	ExponentialBackoff struct {
+		Base time.Duration
+		Cap  time.Duration
+	}
+
+	// Delay of Backoff plus random part up to Jitter, so retrying clients spread out
+	JitteredBackoff struct {
+		Backoff Backoff
+		Jitter  time.Duration
+	}
+)
+
+// Take delays of all retry loops from b: optimistic lock, transaction and connection retries and
+// serializable actions. Attempt counts stay configured per loop
+func WithBackoff(b Backoff) Option {
+	return func(p *pg) {
+		p.backoff = b
+	}
+}
+
+func (b ConstantBackoff) Next(int) time.Duration {
+	return b.Delay
+}
+
+func (b ExponentialBackoff) Next(attempt int) time.Duration {
+	delay := b.Base
+	for i := 1; i < attempt; i++ {
+		// stop doubling at cap or before overflow
+		if b.Cap > 0 && delay >= b.Cap || delay <= 0 || delay > math.MaxInt64/2 {
+			break
+		}
+		delay *= 2
+	}
+	if b.Cap > 0 && delay > b.Cap {
+		return b.Cap
+	}
+	return delay
+}
+
+func (b JitteredBackoff) Next(attempt int) time.Duration {
+	var delay time.Duration
+	if b.Backoff != nil {
+		delay = b.Backoff.Next(attempt)
+	}
+	if b.Jitter > 0 {
+		delay += time.Duration(rand.Int63n(int64(b.Jitter)))
+	}
+	return delay
+}
+
+// Exponential backoff of bounds
+func (o RetryOptions) Next(attempt int) time.Duration {
+	return ExponentialBackoff{Base: o.Base, Cap: o.Cap}.Next(attempt)
+}
+
+// Backoff of WithBackoff, fallback otherwise
+func (pg *pg) backoffOr(fallback Backoff) Backoff {
+	if pg.backoff != nil {
+		return pg.backoff
+	}
+	return fallback
+}
+
+// Exponential backoff of bounds with jitter up to their base
+func jittered(o RetryOptions) Backoff {
+	return JitteredBackoff{Backoff: o, Jitter: o.Base}
+}
@@ -0,0 +1,134 @@
+package active
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/jmoiron/sqlx/types"
+)
+
+func TestBatchMerge(t *testing.T) {
+	// entity of row tagged by its data, so test can tell which duplicate won
+	e := func(rowId, tag string) *Entity {
+		return &Entity{Model: &RawModel{Data: types.JSONText(`"` + tag + `"`)}, Ref: Ref{RowId: rowId, ColumnName: "doc"}}
+	}
+	tests := []struct {
+		name  string
+		batch *Batch
+		other func() *Batch
+		want  []string
+	}{
+		{
+			name:  "changes of other batch follow",
+			batch: NewBatch().Add(e("r1", "a")),
+			other: func() *Batch { return NewBatch().Add(e("r2", "b")) },
+			want:  []string{`add r1 "a"`, `add r2 "b"`},
+		},
+		{
+			name:  "update supersedes add",
+			batch: NewBatch().Add(e("r1", "a")).Add(e("r2", "b")),
+			other: func() *Batch { return NewBatch().Update(e("r1", "c")) },
+			want:  []string{`add r2 "b"`, `update r1 "c"`},
+		},
+		{
+			name:  "delete supersedes update",
+			batch: NewBatch().Update(e("r1", "a")),
+			other: func() *Batch { return NewBatch().Delete(e("r1", "b")) },
+			want:  []string{`delete r1 "b"`},
+		},
+		{
+			name:  "later update wins at position of first",
+			batch: NewBatch().Update(e("r1", "a")).Update(e("r2", "b")),
+			other: func() *Batch { return NewBatch().Update(e("r1", "c")) },
+			want:  []string{`update r1 "c"`, `update r2 "b"`},
+		},
+		{
+			name:  "duplicates within batch collapse too",
+			batch: NewBatch().Add(e("r1", "a")).Add(e("r1", "b")),
+			other: func() *Batch { return NewBatch() },
+			want:  []string{`add r1 "b"`},
+		},
+		{
+			name:  "ordered batch keeps position of first change of winning type",
+			batch: NewOrderedBatch().Update(e("r2", "a")).Add(e("r1", "b")).Update(e("r3", "c")),
+			other: func() *Batch { return NewOrderedBatch().Update(e("r1", "d")).Update(e("r2", "e")) },
+			want:  []string{`update r2 "e"`, `update r3 "c"`, `update r1 "d"`},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			items := tt.batch.Merge(*tt.other()).Items()
+			got := make([]string, len(items))
+			for i, c := range items {
+				got[i] = fmt.Sprintf("%s %s %s", c.T, c.V.Ref.RowId, c.V.Model.(*RawModel).Data)
+			}
+			if fmt.Sprint(got) != fmt.Sprint(tt.want) {
+				t.Fatalf("expected %v, got %v", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestOrderedBatchApply(t *testing.T) {
+	e := func(rowId string, version uint) *Entity {
+		ref := Ref{RowId: rowId, ColumnName: "doc", Version: version}
+		if version > 0 {
+			ref.CreatedAt, ref.UpdatedAt = time.Now(), time.Now()
+		}
+		return &Entity{Model: &RawModel{Data: types.JSONText(`{}`)}, Ref: ref}
+	}
+	tests := []struct {
+		name   string
+		batch  *Batch
+		expect func(mock sqlmock.Sqlmock)
+	}{
+		{
+			name:  "ordered batch runs changes as added",
+			batch: NewOrderedBatch(),
+			expect: func(mock sqlmock.Sqlmock) {
+				// update of r1 follows its add, so its version is not prechecked
+				mock.ExpectQuery(`SELECT row_id, column_name, version FROM models`).WithArgs("r2", "doc", "r3", "doc").
+					WillReturnRows(sqlmock.NewRows([]string{"row_id", "column_name", "version"}).AddRow("r2", "doc", 1).AddRow("r3", "doc", 1))
+				mock.ExpectPrepare(`UPDATE models`).ExpectExec().WithArgs(
+					sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg(), "r2", "doc", 1).
+					WillReturnResult(sqlmock.NewResult(0, 1))
+				mock.ExpectPrepare(`INSERT INTO models`).ExpectExec().WithArgs(
+					"r1", "doc", sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg()).
+					WillReturnResult(sqlmock.NewResult(0, 1))
+				mock.ExpectExec(`UPDATE models`).WithArgs(
+					sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg(), "r1", "doc", 0).
+					WillReturnResult(sqlmock.NewResult(0, 1))
+				mock.ExpectPrepare(`DELETE FROM models`).ExpectExec().WithArgs("r3", "doc", 1).WillReturnResult(sqlmock.NewResult(0, 1))
+			},
+		},
+		{
+			name:  "default batch runs adds first",
+			batch: NewBatch(),
+			expect: func(mock sqlmock.Sqlmock) {
+				mock.ExpectQuery(`SELECT row_id, column_name, version FROM models`).WithArgs("r2", "doc", "r3", "doc").
+					WillReturnRows(sqlmock.NewRows([]string{"row_id", "column_name", "version"}).AddRow("r2", "doc", 1).AddRow("r3", "doc", 1))
+				mock.ExpectPrepare(`INSERT INTO models`).ExpectExec().WithArgs(
+					"r1", "doc", sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg()).
+					WillReturnResult(sqlmock.NewResult(0, 1))
+				mock.ExpectQuery(`UPDATE models AS m`).
+					WillReturnRows(sqlmock.NewRows([]string{"row_id", "column_name", "version"}).AddRow("r2", "doc", 2).AddRow("r1", "doc", 1))
+				mock.ExpectPrepare(`DELETE FROM models`).ExpectExec().WithArgs("r3", "doc", 1).WillReturnResult(sqlmock.NewResult(0, 1))
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store, mock := newMockStore(t, WithVersionPrecheck())
+			mock.ExpectBegin()
+			tt.expect(mock)
+			mock.ExpectCommit()
+			tt.batch.Update(e("r2", 1)).Add(e("r1", 0)).Update(e("r1", 0)).Delete(e("r3", 1))
+			if err := store.ApplyChangesContext(context.Background(), *tt.batch); err != nil {
+				t.Fatal(err)
+			}
+		})
+	}
+}
@@ -0,0 +1,164 @@
+package active
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+)
+
+type (
+	// Accumulates adds and updates in ordered batch applied to underlying store once it holds maxBatch
+	// changes or its oldest change waits maxAge. Safe for concurrent use, batches are applied one at a
+	// time without blocking changes buffered meanwhile. Failure of flush started by timer is returned
+	// by next call
+	BufferedStore struct {
+		store     Store
+		maxBatch  int
+		maxAge    time.Duration
+		afterFunc func(d time.Duration, f func()) FlushTimer
+
+		flushing sync.Mutex
+		mu       sync.Mutex
+		batch    *Batch
+		timer    FlushTimer
+		err      error
+		closed   bool
+	}
+
+	BufferedOption func(*BufferedStore)
+
+	// Pending timed flush
+	FlushTimer interface {
+		Stop() bool
+	}
+
+	// Flush failed, its changes were not applied and are no longer buffered, so caller decides
+	// whether to submit them again
+	FlushError struct {
+		Changes []Change
+		Err     error
+	}
+)
+
+// Buffer changes for s, non positive maxBatch flushes on every change and non positive maxAge
+// disables timed flushes
+func NewBuffered(s Store, maxBatch int, maxAge time.Duration, opts ...BufferedOption) *BufferedStore {
+	b := &BufferedStore{store: s, maxBatch: maxBatch, maxAge: maxAge, batch: NewOrderedBatch(), afterFunc: afterFunc}
+	for _, opt := range opts {
+		opt(b)
+	}
+	return b
+}
+
+// Schedule timed flushes with fn instead of time.AfterFunc, so tests can fire them by hand
+func WithFlushTimer(fn func(d time.Duration, f func()) FlushTimer) BufferedOption {
+	return func(b *BufferedStore) {
+		b.afterFunc = fn
+	}
+}
+
+func (e *FlushError) Error() string {
+	return fmt.Sprintf("model: flush of %d changes: %v", len(e.Changes), e.Err)
+}
+
+func (e *FlushError) Unwrap() error {
+	return e.Err
+}
+
+func (b *BufferedStore) Add(ctx context.Context, e *Entity) error {
+	return b.push(ctx, func(batch *Batch) { batch.Add(e) })
+}
+
+func (b *BufferedStore) Update(ctx context.Context, e *Entity) error {
+	return b.push(ctx, func(batch *Batch) { batch.Update(e) })
+}
+
+func (b *BufferedStore) push(ctx context.Context, add func(*Batch)) error {
+	b.mu.Lock()
+	if b.closed {
+		b.mu.Unlock()
+		return ErrClosed
+	} else if err := b.takeErr(); err != nil {
+		b.mu.Unlock()
+		return err
+	}
+	add(b.batch)
+	full := b.batch.Len() >= b.maxBatch
+	if !full && b.timer == nil && b.maxAge > 0 {
+		b.timer = b.afterFunc(b.maxAge, b.flushAged)
+	}
+	b.mu.Unlock()
+	if full {
+		return b.flush(ctx)
+	}
+	return nil
+}
+
+// Apply buffered changes now
+func (b *BufferedStore) Flush(ctx context.Context) error {
+	b.mu.Lock()
+	err := b.takeErr()
+	b.mu.Unlock()
+	if err != nil {
+		return err
+	}
+	return b.flush(ctx)
+}
+
+// Flush buffered changes and refuse further ones, underlying store stays open
+func (b *BufferedStore) Close(ctx context.Context) error {
+	b.mu.Lock()
+	if b.closed {
+		b.mu.Unlock()
+		return ErrClosed
+	}
+	b.closed = true
+	err := b.takeErr()
+	b.mu.Unlock()
+	if err != nil {
+		return err
+	}
+	return b.flush(ctx)
+}
+
+func (b *BufferedStore) flushAged() {
+	if err := b.flush(context.Background()); err != nil {
+		b.mu.Lock()
+		if b.err == nil {
+			b.err = err
+		}
+		b.mu.Unlock()
+	}
+}
+
+// Apply and reset batch outside of buffer lock, flushes run one at a time so batches are applied
+// in order. Changes of failed batch are reported by FlushError
+func (b *BufferedStore) flush(ctx context.Context) error {
+	b.flushing.Lock()
+	defer b.flushing.Unlock()
+	b.mu.Lock()
+	if b.timer != nil {
+		b.timer.Stop()
+		b.timer = nil
+	}
+	batch := b.batch
+	b.batch = NewOrderedBatch()
+	b.mu.Unlock()
+	if batch.Len() == 0 {
+		return nil
+	} else if err := b.store.ApplyChangesContext(ctx, *batch); err != nil {
+		return &FlushError{Changes: batch.Items(), Err: err}
+	}
+	return nil
+}
+
+func (b *BufferedStore) takeErr() error {
+	err := b.err
+	b.err = nil
+	return err
+}
+
+func afterFunc(d time.Duration, f func()) FlushTimer {
+	return time.AfterFunc(d, f)
+}
@@ -0,0 +1,161 @@
+package active
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+)
+
+type (
+	// Timers fired by test instead of clock
+	fakeTimers struct {
+		mu      sync.Mutex
+		pending []*fakeTimer
+	}
+
+	fakeTimer struct {
+		f       func()
+		stopped bool
+	}
+
+	// Store whose applies wait until released
+	blockingStore struct {
+		Store
+		started chan struct{}
+		release chan struct{}
+	}
+)
+
+func (ft *fakeTimers) afterFunc(_ time.Duration, f func()) FlushTimer {
+	ft.mu.Lock()
+	defer ft.mu.Unlock()
+	t := &fakeTimer{f: f}
+	ft.pending = append(ft.pending, t)
+	return t
+}
+
+// Run every pending timer not stopped yet
+func (ft *fakeTimers) fire() {
+	ft.mu.Lock()
+	pending := ft.pending
+	ft.pending = nil
+	ft.mu.Unlock()
+	for _, t := range pending {
+		if !t.stopped {
+			t.f()
+		}
+	}
+}
+
+func (t *fakeTimer) Stop() bool {
+	stopped := t.stopped
+	t.stopped = true
+	return !stopped
+}
+
+func (s *blockingStore) ApplyChangesContext(ctx context.Context, batch Batch, opts ...TxOption) error {
+	s.started <- struct{}{}
+	<-s.release
+	return s.Store.ApplyChangesContext(ctx, batch, opts...)
+}
+
+func TestBufferedFlush(t *testing.T) {
+	tests := []struct {
+		name string
+		run  func(t *testing.T, store Store, buffered *BufferedStore, timers *fakeTimers)
+	}{
+		{
+			name: "aged batch is applied by timer",
+			run: func(t *testing.T, store Store, buffered *BufferedStore, timers *fakeTimers) {
+				ctx := context.Background()
+				if err := buffered.Add(ctx, rawEntity("r1", `{}`)); err != nil {
+					t.Fatal(err)
+				} else if _, err := store.Load(ctx, &RawModel{}, "r1", "doc"); !errors.Is(err, ErrNotFound) {
+					t.Fatalf("expected buffered change, got %v", err)
+				}
+				timers.fire()
+				if _, err := store.Load(ctx, &RawModel{}, "r1", "doc"); err != nil {
+					t.Fatal(err)
+				}
+			},
+		},
+		{
+			name: "full batch stops timer",
+			run: func(t *testing.T, store Store, buffered *BufferedStore, timers *fakeTimers) {
+				ctx := context.Background()
+				for _, rowId := range []string{"r1", "r2"} {
+					if err := buffered.Add(ctx, rawEntity(rowId, `{}`)); err != nil {
+						t.Fatal(err)
+					}
+				}
+				if len(timers.pending) != 1 || !timers.pending[0].stopped {
+					t.Fatalf("expected single stopped timer, got %+v", timers.pending)
+				}
+			},
+		},
+		{
+			name: "failed aged batch is reported with its changes",
+			run: func(t *testing.T, store Store, buffered *BufferedStore, timers *fakeTimers) {
+				ctx := context.Background()
+				mustSave(t, store, rawEntity("r1", `{}`))
+				if err := buffered.Add(ctx, rawEntity("r1", `{}`)); err != nil {
+					t.Fatal(err)
+				}
+				timers.fire()
+				var flushErr *FlushError
+				if err := buffered.Add(ctx, rawEntity("r2", `{}`)); !errors.As(err, &flushErr) || !errors.Is(err, ErrDuplicate) {
+					t.Fatalf("expected FlushError of duplicate, got %v", err)
+				} else if len(flushErr.Changes) != 1 || flushErr.Changes[0].V.Ref.RowId != "r1" {
+					t.Fatalf("expected failed change of r1, got %+v", flushErr.Changes)
+				}
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store, timers := NewMemStore(), &fakeTimers{}
+			tt.run(t, store, NewBuffered(store, 2, time.Minute, WithFlushTimer(timers.afterFunc)), timers)
+		})
+	}
+}
+
+func TestBufferedFlushOutsideLock(t *testing.T) {
+	ctx := context.Background()
+	store := &blockingStore{Store: NewMemStore(), started: make(chan struct{}), release: make(chan struct{})}
+	buffered := NewBuffered(store, 10, 0)
+	if err := buffered.Add(ctx, rawEntity("r1", `{}`)); err != nil {
+		t.Fatal(err)
+	}
+	flushed := make(chan error, 1)
+	go func() {
+		flushed <- buffered.Flush(ctx)
+	}()
+	<-store.started
+	added := make(chan error, 1)
+	go func() {
+		added <- buffered.Add(ctx, rawEntity("r2", `{}`))
+	}()
+	select {
+	case err := <-added:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("change was not buffered while batch was applied")
+	}
+	close(store.release)
+	if err := <-flushed; err != nil {
+		t.Fatal(err)
+	}
+	go func() {
+		<-store.started
+	}()
+	if err := buffered.Flush(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.Load(ctx, &RawModel{}, "r2", "doc"); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,464 @@
+package active
+
+import (
+	"context"
+	"io"
+	"sync"
+
+	"github.com/jmoiron/sqlx/types"
+)
+
+type (
+	// Entities by key, implementation has to be safe for concurrent use and may evict at will
+	Cache interface {
+		Get(key Key) (*Entity, bool)
+		Set(key Key, e *Entity)
+		Delete(key Key)
+	}
+
+	// Store serving Load from cache, keys written through it are invalidated once their transaction
+	// commits, so rolled back writes keep cache as it was. Writes made to underlying store directly
+	// are not seen
+	CachingStore struct {
+		Store
+		cache Cache
+
+		mu sync.Mutex
+		// bumped by every invalidation, load started before it does not populate cache
+		gen uint64
+		// cached keys by column, for writes not knowing keys they touch
+		keys map[string]map[Key]struct{}
+	}
+
+	// Keys written within transaction of CachingStore.InTx, invalidated when it commits
+	cachePending struct {
+		mu   sync.Mutex
+		keys []Key
+		all  bool
+		cols []string
+	}
+
+	cachePendingKey struct {
+		c *CachingStore
+	}
+
+	// Tx of WithTx recording keys it writes
+	cachingTx struct {
+		Tx
+		pending *cachePending
+	}
+
+	// Action recording keys of changes it produces
+	cachingAction struct {
+		Action
+		pending *cachePending
+	}
+
+	// Unbounded Cache backed by map
+	mapCache struct {
+		mu      sync.RWMutex
+		entries map[Key]*Entity
+	}
+)
+
+// Cache loads of s, cache should not be shared with other stores
+func NewCaching(s Store, cache Cache) Store {
+	return &CachingStore{Store: s, cache: cache, keys: map[string]map[Key]struct{}{}}
+}
+
+// Cache keeping every entity until it is invalidated
+func NewMapCache() Cache {
+	return &mapCache{entries: map[Key]*Entity{}}
+}
+
+// Load model from cache, or from underlying store populating cache. Loads within transaction
+// of InTx bypass cache as they may see uncommitted writes
+func (c *CachingStore) Load(ctx context.Context, m Model, rowId, columnName string) (*Entity, error) {
+	key := Key{RowId: rowId, ColumnName: columnName}
+	if c.pending(ctx) != nil {
+		return c.Store.Load(ctx, m, rowId, columnName)
+	}
+	if cached, ok := c.cache.Get(key); ok {
+		item := cached.Marshall()
+		if item.E != nil {
+			return nil, item.E
+		} else if err := m.Unmarshall(cached.Ref, append(types.JSONText(nil), item.V...)); err != nil {
+			return nil, err
+		}
+		return &Entity{Model: m, Ref: cached.Ref}, nil
+	}
+	c.mu.Lock()
+	gen := c.gen
+	c.mu.Unlock()
+	e, err := c.Store.Load(ctx, m, rowId, columnName)
+	if err != nil {
+		return nil, err
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	// invalidated meanwhile, loaded entity may predate the write
+	if c.gen == gen {
+		c.cache.Set(key, e.Clone())
+		if c.keys[columnName] == nil {
+			c.keys[columnName] = map[Key]struct{}{}
+		}
+		c.keys[columnName][key] = struct{}{}
+	}
+	return e, nil
+}
+
+func (c *CachingStore) ApplyChanges(batch Batch) error {
+	return c.ApplyChangesContext(context.Background(), batch)
+}
+
+func (c *CachingStore) ApplyChangesContext(ctx context.Context, batch Batch, opts ...TxOption) error {
+	if err := c.Store.ApplyChangesContext(ctx, batch, opts...); err != nil {
+		return err
+	}
+	c.invalidate(ctx, batchKeys(batch)...)
+	return nil
+}
+
+func (c *CachingStore) ApplyChangesResult(ctx context.Context, batch Batch, opts ...TxOption) (*ApplyResult, error) {
+	res, err := c.Store.ApplyChangesResult(ctx, batch, opts...)
+	if err != nil {
+		return nil, err
+	}
+	c.invalidate(ctx, batchKeys(batch)...)
+	return res, nil
+}
+
+func (c *CachingStore) ApplyIdempotent(ctx context.Context, batch Batch, fingerprint string) error {
+	if err := c.Store.ApplyIdempotent(ctx, batch, fingerprint); err != nil {
+		return err
+	}
+	c.invalidate(ctx, batchKeys(batch)...)
+	return nil
+}
+
+// Chunks committed before failure stay applied, so keys of batch are invalidated either way
+func (c *CachingStore) ApplyChangesChunked(ctx context.Context, batch Batch, chunkSize int, opts ...ChunkOption) error {
+	err := c.Store.ApplyChangesChunked(ctx, batch, chunkSize, opts...)
+	c.invalidate(ctx, batchKeys(batch)...)
+	return err
+}
+
+func (c *CachingStore) ApplyWithRetry(ctx context.Context, maxAttempts int, reload func() (Batch, error)) error {
+	var last Batch
+	if err := c.Store.ApplyWithRetry(ctx, maxAttempts, recordReload(&last, reload)); err != nil {
+		return err
+	}
+	c.invalidate(ctx, batchKeys(last)...)
+	return nil
+}
+
+func (c *CachingStore) ApplyWithRetryOptions(ctx context.Context, maxAttempts int, opts RetryOptions, reload func() (Batch, error)) error {
+	var last Batch
+	if err := c.Store.ApplyWithRetryOptions(ctx, maxAttempts, opts, recordReload(&last, reload)); err != nil {
+		return err
+	}
+	c.invalidate(ctx, batchKeys(last)...)
+	return nil
+}
+
+func (c *CachingStore) SoftDelete(ctx context.Context, e *Entity) error {
+	if err := c.Store.SoftDelete(ctx, e); err != nil {
+		return err
+	}
+	c.invalidate(ctx, e.Ref.Key())
+	return nil
+}
+
+func (c *CachingStore) RunAction(ctx context.Context, action Action, params Params) error {
+	pending := &cachePending{}
+	if err := c.Store.RunAction(ctx, &cachingAction{Action: action, pending: pending}, params); err != nil {
+		return err
+	}
+	c.invalidate(ctx, pending.keys...)
+	return nil
+}
+
+func (c *CachingStore) RunActionSerializable(ctx context.Context, action Action, params Params, maxAttempts int) error {
+	pending := &cachePending{}
+	if err := c.Store.RunActionSerializable(ctx, &cachingAction{Action: action, pending: pending}, params, maxAttempts); err != nil {
+		return err
+	}
+	c.invalidate(ctx, pending.keys...)
+	return nil
+}
+
+func (c *CachingStore) Upsert(ctx context.Context, e *Entity) error {
+	if err := c.Store.Upsert(ctx, e); err != nil {
+		return err
+	}
+	c.invalidate(ctx, e.Ref.Key())
+	return nil
+}
+
+func (c *CachingStore) Save(ctx context.Context, e *Entity) error {
+	if err := c.Store.Save(ctx, e); err != nil {
+		return err
+	}
+	c.invalidate(ctx, e.Ref.Key())
+	return nil
+}
+
+func (c *CachingStore) SaveRow(ctx context.Context, rowId string, entities map[string]*Entity) error {
+	if err := c.Store.SaveRow(ctx, rowId, entities); err != nil {
+		return err
+	}
+	keys := make([]Key, 0, len(entities))
+	for col := range entities {
+		keys = append(keys, Key{RowId: rowId, ColumnName: col})
+	}
+	c.invalidate(ctx, keys...)
+	return nil
+}
+
+func (c *CachingStore) SaveChanged(ctx context.Context, e, baseline *Entity) error {
+	if err := c.Store.SaveChanged(ctx, e, baseline); err != nil {
+		return err
+	}
+	c.invalidate(ctx, e.Ref.Key())
+	return nil
+}
+
+func (c *CachingStore) PatchJSON(ctx context.Context, ref Ref, patch map[string]any) error {
+	if err := c.Store.PatchJSON(ctx, ref, patch); err != nil {
+		return err
+	}
+	c.invalidate(ctx, ref.Key())
+	return nil
+}
+
+func (c *CachingStore) Touch(ctx context.Context, ref Ref) error {
+	if err := c.Store.Touch(ctx, ref); err != nil {
+		return err
+	}
+	c.invalidate(ctx, ref.Key())
+	return nil
+}
+
+func (c *CachingStore) Restore(ctx context.Context, blob []byte, force bool) error {
+	if err := c.Store.Restore(ctx, blob, force); err != nil {
+		return err
+	}
+	if s, err := parseSnapshot(blob); err == nil {
+		c.invalidate(ctx, s.ref().Key())
+	}
+	return nil
+}
+
+// Deleted keys are unknown, so every cached key of column is invalidated
+func (c *CachingStore) DeleteWhere(ctx context.Context, columnName, jsonPath string, value any) (int64, error) {
+	num, err := c.Store.DeleteWhere(ctx, columnName, jsonPath, value)
+	if err != nil {
+		return num, err
+	}
+	c.invalidateColumn(ctx, columnName)
+	return num, nil
+}
+
+// Batches committed before failure stay applied, so column is invalidated either way
+func (c *CachingStore) MigrateData(ctx context.Context, columnName string, transform func(types.JSONText) (types.JSONText, error)) (int64, error) {
+	num, err := c.Store.MigrateData(ctx, columnName, transform)
+	c.invalidateColumn(ctx, columnName)
+	return num, err
+}
+
+// Lines imported before failure stay applied, so every cached key is invalidated either way
+func (c *CachingStore) ImportNDJSON(ctx context.Context, r io.Reader) (int64, error) {
+	num, err := c.Store.ImportNDJSON(ctx, r)
+	c.invalidateAll(ctx)
+	return num, err
+}
+
+func (c *CachingStore) Truncate(ctx context.Context, tables ...string) error {
+	if err := c.Store.Truncate(ctx, tables...); err != nil {
+		return err
+	}
+	c.invalidateAll(ctx)
+	return nil
+}
+
+func (c *CachingStore) WithTx(ctx context.Context, fn func(tx Tx) error) error {
+	pending := &cachePending{}
+	if err := c.Store.WithTx(ctx, func(tx Tx) error {
+		return fn(&cachingTx{Tx: tx, pending: pending})
+	}); err != nil {
+		return err
+	}
+	c.flush(ctx, pending)
+	return nil
+}
+
+func (c *CachingStore) LoadForUpdate(ctx context.Context, tx Tx, m Model, rowId, columnName string) (*Entity, error) {
+	if t, ok := tx.(*cachingTx); ok {
+		tx = t.Tx
+	}
+	return c.Store.LoadForUpdate(ctx, tx, m, rowId, columnName)
+}
+
+// Run fn in transaction, keys written with ctx passed to fn are invalidated once outermost
+// transaction commits
+func (c *CachingStore) InTx(ctx context.Context, fn func(ctx context.Context) error, opts ...TxOption) error {
+	if c.pending(ctx) != nil {
+		return c.Store.InTx(ctx, fn, opts...)
+	}
+	pending := &cachePending{}
+	if err := c.Store.InTx(context.WithValue(ctx, cachePendingKey{c}, pending), fn, opts...); err != nil {
+		return err
+	}
+	c.flush(ctx, pending)
+	return nil
+}
+
+func (c *CachingStore) pending(ctx context.Context) *cachePending {
+	p, _ := ctx.Value(cachePendingKey{c}).(*cachePending)
+	return p
+}
+
+// Drop keys from cache, or defer it until transaction bound to ctx commits
+func (c *CachingStore) invalidate(ctx context.Context, keys ...Key) {
+	if p := c.pending(ctx); p != nil {
+		p.add(keys...)
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.gen++
+	for _, key := range keys {
+		c.cache.Delete(key)
+		delete(c.keys[key.ColumnName], key)
+	}
+}
+
+func (c *CachingStore) invalidateColumn(ctx context.Context, columnName string) {
+	if p := c.pending(ctx); p != nil {
+		p.mu.Lock()
+		p.cols = append(p.cols, columnName)
+		p.mu.Unlock()
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.dropColumn(columnName)
+}
+
+func (c *CachingStore) invalidateAll(ctx context.Context) {
+	if p := c.pending(ctx); p != nil {
+		p.mu.Lock()
+		p.all = true
+		p.mu.Unlock()
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for col := range c.keys {
+		c.dropColumn(col)
+	}
+}
+
+func (c *CachingStore) dropColumn(columnName string) {
+	c.gen++
+	for key := range c.keys[columnName] {
+		c.cache.Delete(key)
+	}
+	delete(c.keys, columnName)
+}
+
+// Apply invalidations recorded within committed transaction
+func (c *CachingStore) flush(ctx context.Context, p *cachePending) {
+	if p.all {
+		c.invalidateAll(ctx)
+		return
+	}
+	for _, col := range p.cols {
+		c.invalidateColumn(ctx, col)
+	}
+	c.invalidate(ctx, p.keys...)
+}
+
+func (p *cachePending) add(keys ...Key) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.keys = append(p.keys, keys...)
+}
+
+func (t *cachingTx) Add(ctx context.Context, e *Entity) error {
+	if err := t.Tx.Add(ctx, e); err != nil {
+		return err
+	}
+	t.pending.add(e.Ref.Key())
+	return nil
+}
+
+func (t *cachingTx) Update(ctx context.Context, e *Entity) error {
+	if err := t.Tx.Update(ctx, e); err != nil {
+		return err
+	}
+	t.pending.add(e.Ref.Key())
+	return nil
+}
+
+func (t *cachingTx) Delete(ctx context.Context, e *Entity) error {
+	if err := t.Tx.Delete(ctx, e); err != nil {
+		return err
+	}
+	t.pending.add(e.Ref.Key())
+	return nil
+}
+
+func (a *cachingAction) Exec(params Params, batch *Batch) error {
+	if err := a.Action.Exec(params, batch); err != nil {
+		return err
+	}
+	a.pending.add(batchKeys(*batch)...)
+	return nil
+}
+
+// Keep name of wrapped action in action log
+func (a *cachingAction) Name() string {
+	return actionName(a.Action)
+}
+
+func (m *mapCache) Get(key Key) (*Entity, bool) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	e, ok := m.entries[key]
+	return e, ok
+}
+
+func (m *mapCache) Set(key Key, e *Entity) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.entries[key] = e
+}
+
+func (m *mapCache) Delete(key Key) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.entries, key)
+}
+
+func batchKeys(batch Batch) []Key {
+	items := batch.Items()
+	keys := make([]Key, 0, len(items))
+	for _, change := range items {
+		if change.V != nil {
+			keys = append(keys, change.V.Ref.Key())
+		}
+	}
+	return keys
+}
+
+// Remember batch of last reload, the one applied when retry succeeds
+func recordReload(last *Batch, reload func() (Batch, error)) func() (Batch, error) {
+	return func() (Batch, error) {
+		batch, err := reload()
+		*last = batch
+		return batch, err
+	}
+}
+
+var _ Store = (*CachingStore)(nil)
@@ -0,0 +1,105 @@
+package active
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/jmoiron/sqlx/types"
+)
+
+func TestCachingKeepsEntryOfRolledBackWrite(t *testing.T) {
+	failure := errors.New("abort")
+	tests := []struct {
+		name    string
+		write   func(ctx context.Context, store Store, e *Entity) error
+		wantErr error
+	}{
+		{
+			name: "stale save",
+			write: func(ctx context.Context, store Store, e *Entity) error {
+				e.Ref.Version++
+				return store.Save(ctx, e)
+			},
+			wantErr: ErrOptimisticLock,
+		},
+		{
+			name: "stale batch",
+			write: func(ctx context.Context, store Store, e *Entity) error {
+				e.Ref.Version++
+				batch := NewBatch()
+				batch.Update(e)
+				return store.ApplyChangesContext(ctx, *batch)
+			},
+			wantErr: ErrOptimisticLock,
+		},
+		{
+			name: "transaction rolled back",
+			write: func(ctx context.Context, store Store, e *Entity) error {
+				return store.WithTx(ctx, func(tx Tx) error {
+					if err := tx.Update(ctx, e); err != nil {
+						return err
+					}
+					return failure
+				})
+			},
+			wantErr: failure,
+		},
+		{
+			name: "InTx rolled back",
+			write: func(ctx context.Context, store Store, e *Entity) error {
+				return store.InTx(ctx, func(ctx context.Context) error {
+					if err := store.Save(ctx, e); err != nil {
+						return err
+					}
+					return failure
+				})
+			},
+			wantErr: failure,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			mem := NewMemStore()
+			mustSave(t, mem, rawEntity("r1", `{"a":1}`))
+			store := NewCaching(mem, NewMapCache())
+			e, err := store.Load(ctx, &RawModel{}, "r1", "doc")
+			if err != nil {
+				t.Fatal(err)
+			}
+			e.Model = &RawModel{Data: types.JSONText(`{"a":2}`)}
+			if err := tt.write(ctx, store, e); !errors.Is(err, tt.wantErr) {
+				t.Fatalf("expected %v, got %v", tt.wantErr, err)
+			}
+			if cached, ok := store.(*CachingStore).cache.Get(Key{RowId: "r1", ColumnName: "doc"}); !ok {
+				t.Fatal("expected cached entry to survive rolled back write")
+			} else if data := string(cached.Model.(*RawModel).Data); data != `{"a":1}` {
+				t.Fatalf("expected cached entry as committed, got %s", data)
+			}
+		})
+	}
+}
+
+func TestCachingInvalidatesCommittedWrite(t *testing.T) {
+	ctx := context.Background()
+	mem := NewMemStore()
+	mustSave(t, mem, rawEntity("r1", `{"a":1}`))
+	store := NewCaching(mem, NewMapCache())
+	e, err := store.Load(ctx, &RawModel{}, "r1", "doc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	e.Model = &RawModel{Data: types.JSONText(`{"a":2}`)}
+	if err := store.Save(ctx, e); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := store.(*CachingStore).cache.Get(e.Ref.Key()); ok {
+		t.Fatal("expected committed write to invalidate cached entry")
+	}
+	if e, err := store.Load(ctx, &RawModel{}, "r1", "doc"); err != nil {
+		t.Fatal(err)
+	} else if data := string(e.Model.(*RawModel).Data); data != `{"a":2}` {
+		t.Fatalf("expected committed write to be loaded, got %s", data)
+	}
+}
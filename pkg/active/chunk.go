@@ -0,0 +1,72 @@
+package active
+
+import (
+	"context"
+	"fmt"
+)
+
+type (
+	// Tunes chunked apply
+	ChunkOption func(*chunkOptions)
+
+	chunkOptions struct {
+		progress func(applied, total int)
+	}
+
+	// Chunked apply interrupted by expired context, first Applied changes are committed
+	PartialError struct {
+		Applied int
+		Total   int
+		Err     error
+	}
+)
+
+// Report number of committed changes after every chunk, fn runs outside of transaction
+func OnProgress(fn func(applied, total int)) ChunkOption {
+	return func(o *chunkOptions) {
+		o.progress = fn
+	}
+}
+
+func (e *PartialError) Error() string {
+	return fmt.Sprintf("model: applied %d of %d changes: %v", e.Applied, e.Total, e.Err)
+}
+
+func (e *PartialError) Unwrap() error {
+	return e.Err
+}
+
+// Validate batch and hand its changes to applyChunk in chunks, progress is reported between them
+func applyChunked(ctx context.Context, batch Batch, chunkSize int, opts []ChunkOption, applyChunk func([]Change) error) error {
+	cfg := chunkOptions{}
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+	items := batch.Items()
+	if err := validate(items); err != nil {
+		return err
+	}
+	if chunkSize <= 0 {
+		chunkSize = len(items)
+	}
+	for from := 0; from < len(items); from += chunkSize {
+		to := from + chunkSize
+		if to > len(items) {
+			to = len(items)
+		}
+		err := ctx.Err()
+		if err == nil {
+			err = applyChunk(items[from:to])
+		}
+		if err != nil && ctx.Err() != nil {
+			return &PartialError{Applied: from, Total: len(items), Err: err}
+		} else if err != nil {
+			return fmt.Errorf("chunk %d-%d: %w", from, to, err)
+		}
+		// outside of transaction, so slow callback holds no locks
+		if cfg.progress != nil {
+			cfg.progress(to, len(items))
+		}
+	}
+	return nil
+}
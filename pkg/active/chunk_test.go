@@ -0,0 +1,92 @@
+package active
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+func TestApplyChangesChunked(t *testing.T) {
+	failure := errors.New("connection reset")
+	tests := []struct {
+		name string
+		// chunks reaching database, failed one last
+		chunks      [][]string
+		failChunk   bool
+		cancelAt    int
+		wantErr     error
+		wantPartial int
+		progress    []int
+	}{
+		{
+			name:     "progress is reported after every chunk",
+			chunks:   [][]string{{"r0", "r1"}, {"r2", "r3"}, {"r4"}},
+			progress: []int{2, 4, 5},
+		},
+		{
+			name:        "cancellation between chunks reports committed changes",
+			chunks:      [][]string{{"r0", "r1"}},
+			cancelAt:    2,
+			wantErr:     context.Canceled,
+			wantPartial: 2,
+			progress:    []int{2},
+		},
+		{
+			name:      "failed chunk rolls back alone",
+			chunks:    [][]string{{"r0", "r1"}, {"r2", "r3"}},
+			failChunk: true,
+			wantErr:   failure,
+			progress:  []int{2},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store, mock := newMockStore(t)
+			for i, chunk := range tt.chunks {
+				mock.ExpectBegin()
+				if tt.failChunk && i == len(tt.chunks)-1 {
+					mock.ExpectQuery(`INSERT INTO models`).WillReturnError(failure)
+					mock.ExpectRollback()
+					continue
+				}
+				if len(chunk) == 1 {
+					mock.ExpectPrepare(`INSERT INTO models`).ExpectExec().WillReturnResult(sqlmock.NewResult(0, 1))
+				} else {
+					rows := sqlmock.NewRows([]string{"row_id", "column_name"})
+					for _, rowId := range chunk {
+						rows.AddRow(rowId, "doc")
+					}
+					mock.ExpectQuery(`INSERT INTO models`).WillReturnRows(rows)
+				}
+				mock.ExpectCommit()
+			}
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			var progress []int
+			err := store.ApplyChangesChunked(ctx, addBatch(5), 2, OnProgress(func(applied, total int) {
+				if total != 5 {
+					t.Errorf("expected total 5, got %d", total)
+				}
+				progress = append(progress, applied)
+				if applied == tt.cancelAt {
+					cancel()
+				}
+			}))
+			if !errors.Is(err, tt.wantErr) || tt.wantErr == nil && err != nil {
+				t.Fatalf("expected %v, got %v", tt.wantErr, err)
+			}
+			var partial *PartialError
+			if isPartial := errors.As(err, &partial); isPartial != (tt.wantPartial > 0) {
+				t.Fatalf("expected partial %v, got %v", tt.wantPartial > 0, err)
+			} else if isPartial && (partial.Applied != tt.wantPartial || partial.Total != 5) {
+				t.Fatalf("expected %d of 5 applied, got %d of %d", tt.wantPartial, partial.Applied, partial.Total)
+			}
+			if fmt.Sprint(progress) != fmt.Sprint(tt.progress) {
+				t.Fatalf("expected progress %v, got %v", tt.progress, progress)
+			}
+		})
+	}
+}
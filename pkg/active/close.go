@@ -0,0 +1,50 @@
+package active
+
+import (
+	"context"
+	"errors"
+)
+
+var (
+	ErrClosed = errors.New("model: store closed")
+)
+
+// Refuse new transactions, wait for in-flight ones to finish or ctx to expire, then close
+// primary and replica databases
+func (pg *pg) Close(ctx context.Context) error {
+	pg.lifecycle.Lock()
+	if pg.closed {
+		pg.lifecycle.Unlock()
+		return ErrClosed
+	}
+	pg.closed = true
+	pg.lifecycle.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		pg.inflight.Wait()
+		close(done)
+	}()
+	var errs []error
+	select {
+	case <-done:
+	case <-ctx.Done():
+		errs = append(errs, ctx.Err())
+	}
+	errs = append(errs, pg.db.Close())
+	for _, db := range pg.replicas {
+		errs = append(errs, db.Close())
+	}
+	return errors.Join(errs...)
+}
+
+// Register transaction about to start, release has to be called once it ends
+func (pg *pg) acquire() (release func(), err error) {
+	pg.lifecycle.Lock()
+	defer pg.lifecycle.Unlock()
+	if pg.closed {
+		return nil, ErrClosed
+	}
+	pg.inflight.Add(1)
+	return pg.inflight.Done, nil
+}
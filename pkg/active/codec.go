@@ -0,0 +1,220 @@
+package active
+
+import (
+	"bytes"
+	"database/sql"
+	"encoding/gob"
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"github.com/vmihailenco/msgpack/v5"
+)
+
+type (
+	// Binary format of data column
+	Codec interface {
+		// Name persisted in format column, reads pick codec by it
+		Format() string
+
+		Encode(m Model) ([]byte, error)
+
+		Decode(data []byte, m Model) error
+	}
+
+	// Codec passing reference of stored cell into model
+	refDecoder interface {
+		decodeRef(ref Ref, data []byte, m Model) error
+	}
+
+	// Default codec delegating to Model Marshall/Unmarshall
+	jsonCodec struct{}
+
+	// Codec based on encoding/gob
+	GobCodec struct{}
+
+	// Codec based on MessagePack, exported fields of model are encoded, msgpack tags apply
+	MsgpackCodec struct{}
+)
+
+const (
+	JSONFormat    = "json"
+	GobFormat     = "gob"
+	MsgpackFormat = "msgpack"
+)
+
+var (
+	JSON Codec = jsonCodec{}
+
+	ErrInvalidJSON = errors.New("model: invalid JSON data")
+
+	// JSON query refused, since store writes data of other codec, compressed or encrypted
+	ErrOpaqueData = errors.New("model: data not stored as plain JSON")
+)
+
+func (jsonCodec) Format() string { return JSONFormat }
+
+func (jsonCodec) Encode(m Model) ([]byte, error) {
+	if item := m.Marshall(); item.E != nil {
+		return nil, item.E
+	} else {
+		return item.V, nil
+	}
+}
+
+func (c jsonCodec) Decode(data []byte, m Model) error {
+	return c.decodeRef(Ref{}, data, m)
+}
+
+func (jsonCodec) decodeRef(ref Ref, data []byte, m Model) error {
+	return m.Unmarshall(ref, data)
+}
+
+func (GobCodec) Format() string { return GobFormat }
+
+func (GobCodec) Encode(m Model) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(m); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func (GobCodec) Decode(data []byte, m Model) error {
+	return gob.NewDecoder(bytes.NewReader(data)).Decode(m)
+}
+
+func (MsgpackCodec) Format() string { return MsgpackFormat }
+
+func (MsgpackCodec) Encode(m Model) ([]byte, error) {
+	return msgpack.Marshal(m)
+}
+
+func (MsgpackCodec) Decode(data []byte, m Model) error {
+	return msgpack.Unmarshal(data, m)
+}
+
+// Encode new writes with codec, codec is also registered for reads
+func WithCodec(c Codec) Option {
+	return func(p *pg) {
+		if c != nil {
+			p.codec = c
+			p.codecs[c.Format()] = c
+		}
+	}
+}
+
+// Register codec used only to read cells stored in its format
+func WithReadCodec(c Codec) Option {
+	return func(p *pg) {
+		if c != nil {
+			p.codecs[c.Format()] = c
+		}
+	}
+}
+
+// Data column value with flags describing how it was produced
+type payload struct {
+	data       []byte
+	compressed bool
+	keyId      sql.NullString
+}
+
+// Encode model with codec of store, then compress and encrypt payload if configured
+func (pg *pg) encode(m Model) (payload, error) {
+	data, err := pg.codec.Encode(m)
+	if err != nil {
+		return payload{}, err
+	} else if data, err = pg.checkJSON(data, m); err != nil {
+		return payload{}, err
+	}
+	return pg.seal(data, pg.codec.Format())
+}
+
+// Compress and encrypt data encoded in format if configured. Data column is jsonb, so anything
+// but plain JSON is stored as base64 JSON string
+func (pg *pg) seal(data []byte, format string) (payload, error) {
+	p := payload{}
+	var err error
+	if p.data, p.compressed, err = pg.compress(data); err != nil {
+		return p, err
+	} else if p.data, p.keyId, err = pg.encrypt(p.data); err != nil {
+		return p, err
+	} else if format != JSONFormat || p.compressed || p.keyId.Valid {
+		if p.data, err = json.Marshal(p.data); err != nil {
+			return p, err
+		}
+	}
+	return p, nil
+}
+
+// Refuse queries looking into stored JSON unless every write stores plain JSON
+func (pg *pg) checkPlainJSON() error {
+	if pg.codec.Format() != JSONFormat || pg.compressor != nil || pg.cipher != nil {
+		return ErrOpaqueData
+	}
+	return nil
+}
+
+// Replace empty JSON data with configured document and refuse malformed one before it reaches database.
+// Data of other codecs is passed as is
+func (pg *pg) checkJSON(data []byte, m Model) ([]byte, error) {
+	if pg.codec.Format() != JSONFormat {
+		return data, nil
+	}
+	if len(bytes.TrimSpace(data)) == 0 {
+		return []byte(pg.emptyJSON), nil
+	} else if !json.Valid(data) {
+		return nil, fmt.Errorf("%w: %T", ErrInvalidJSON, m)
+	}
+	return data, nil
+}
+
+// Store empty JSON data of models as doc, "null" by default. Doc has to be valid JSON, e.g. "{}"
+func WithEmptyJSON(doc string) Option {
+	return func(p *pg) {
+		if !json.Valid([]byte(doc)) {
+			p.fail(fmt.Errorf("%w: empty JSON: %w: %q", ErrInvalidOption, ErrInvalidJSON, doc))
+		} else {
+			p.emptyJSON = doc
+		}
+	}
+}
+
+// Decode cell with codec of its format, missing format means JSON
+func (pg *pg) decode(c cell, m Model) error {
+	format := c.Format.String
+	if format == "" {
+		format = JSONFormat
+	}
+	codec, ok := pg.codecs[format]
+	if !ok {
+		return fmt.Errorf("model: no codec for format %q", format)
+	}
+	data, err := pg.unseal(c)
+	if err != nil {
+		return err
+	}
+	if rd, ok := codec.(refDecoder); ok {
+		return rd.decodeRef(c.toRef(), data, m)
+	}
+	return codec.Decode(data, m)
+}
+
+// Unwrap, decrypt and inflate data of cell, giving what codec encoded
+func (pg *pg) unseal(c cell) ([]byte, error) {
+	data := []byte(c.Data)
+	if c.Format.Valid && c.Format.String != JSONFormat || c.Compressed || c.KeyId.Valid {
+		if err := json.Unmarshal(c.Data, &data); err != nil {
+			return nil, fmt.Errorf("model: unwrap %s: %w", c.toRef().Key(), err)
+		}
+	}
+	data, err := pg.decrypt(data, c.KeyId)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", c.toRef().Key(), err)
+	}
+	if data, err = pg.inflate(data, c.Compressed); err != nil {
+		return nil, fmt.Errorf("model: inflate %s: %w", c.toRef().Key(), err)
+	}
+	return data, nil
+}
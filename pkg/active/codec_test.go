@@ -0,0 +1,107 @@
+package active
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"testing"
+
+	"github.com/jmoiron/sqlx/types"
+)
+
+type (
+	// Model with exported fields binary codecs encode
+	codecModel struct {
+		Name  string
+		Count int
+	}
+)
+
+func (m *codecModel) Marshall() Item {
+	b, err := json.Marshal(m)
+	return Item{V: b, E: err}
+}
+
+func (m *codecModel) Unmarshall(_ Ref, data types.JSONText) error {
+	return json.Unmarshal(data, m)
+}
+
+func TestCodecRoundTrip(t *testing.T) {
+	tests := []struct {
+		name   string
+		codec  Codec
+		format string
+	}{
+		{name: "json", codec: JSON, format: JSONFormat},
+		{name: "gob", codec: GobCodec{}, format: GobFormat},
+		{name: "msgpack", codec: MsgpackCodec{}, format: MsgpackFormat},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store := newPg(nil, Postgres)
+			WithCodec(tt.codec)(store)
+			p, err := store.encode(&codecModel{Name: "a", Count: 2})
+			if err != nil {
+				t.Fatal(err)
+			} else if !json.Valid(p.data) {
+				t.Fatalf("expected data valid for jsonb column, got %q", p.data)
+			}
+			c := cell{Data: p.data, Format: sql.NullString{String: store.codec.Format(), Valid: true}, Compressed: p.compressed, KeyId: p.keyId}
+			if c.Format.String != tt.format {
+				t.Fatalf("expected format %q, got %q", tt.format, c.Format.String)
+			}
+			m := &codecModel{}
+			if err := store.decode(c, m); err != nil {
+				t.Fatal(err)
+			} else if *m != (codecModel{Name: "a", Count: 2}) {
+				t.Fatalf("expected decoded model, got %+v", m)
+			}
+		})
+	}
+}
+
+func TestOpaquePayloads(t *testing.T) {
+	cipher, err := NewAESCipher("k1", make([]byte, 32))
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name string
+		opt  Option
+	}{
+		{name: "binary codec", opt: WithCodec(MsgpackCodec{})},
+		{name: "compressed", opt: WithCompression(Gzip, 1)},
+		{name: "encrypted", opt: WithEncryption(cipher)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store, _ := newMockStore(t, tt.opt)
+			p, err := store.encode(&codecModel{Name: "a", Count: 2})
+			if err != nil {
+				t.Fatal(err)
+			}
+			var wrapped string
+			if err := json.Unmarshal(p.data, &wrapped); err != nil {
+				t.Fatalf("expected base64 JSON string, got %q", p.data)
+			}
+			c := cell{Data: p.data, Format: sql.NullString{String: store.codec.Format(), Valid: true}, Compressed: p.compressed, KeyId: p.keyId}
+			m := &codecModel{}
+			if err := store.decode(c, m); err != nil {
+				t.Fatal(err)
+			} else if *m != (codecModel{Name: "a", Count: 2}) {
+				t.Fatalf("expected decoded model, got %+v", m)
+			}
+
+			// refused before any statement reaches mocked database
+			ctx := context.Background()
+			if _, err := store.FindByJSON(ctx, "doc", "name", "a", func() Model { return &codecModel{} }); !errors.Is(err, ErrOpaqueData) {
+				t.Fatalf("expected find refused, got %v", err)
+			} else if _, err := store.DeleteWhere(ctx, "doc", "name", "a"); !errors.Is(err, ErrOpaqueData) {
+				t.Fatalf("expected delete refused, got %v", err)
+			} else if err := store.PatchJSON(ctx, Ref{RowId: "r1", ColumnName: "doc"}, map[string]any{"name": "b"}); !errors.Is(err, ErrOpaqueData) {
+				t.Fatalf("expected patch refused, got %v", err)
+			}
+		})
+	}
+}
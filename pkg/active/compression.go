@@ -0,0 +1,83 @@
+package active
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io"
+)
+
+type (
+	// Compression of encoded data column
+	Compressor interface {
+		Compress(data []byte) ([]byte, error)
+
+		Decompress(data []byte) ([]byte, error)
+	}
+
+	gzipCompressor struct{}
+)
+
+const (
+	// Payloads up to this size are stored as is
+	DefaultCompressionThreshold = 1024
+)
+
+var (
+	Gzip Compressor = gzipCompressor{}
+)
+
+func (gzipCompressor) Compress(data []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	w := gzip.NewWriter(&buf)
+	if _, err := w.Write(data); err != nil {
+		return nil, err
+	} else if err := w.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func (gzipCompressor) Decompress(data []byte) ([]byte, error) {
+	r, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	return io.ReadAll(r)
+}
+
+// Compress payloads larger than threshold bytes, non positive threshold means DefaultCompressionThreshold.
+// Compressed cells are flagged by compressed column and stored as base64 JSON string, so JSON queries
+// fail with ErrOpaqueData
+func WithCompression(c Compressor, threshold int) Option {
+	if threshold <= 0 {
+		threshold = DefaultCompressionThreshold
+	}
+	return func(p *pg) {
+		p.compressor = c
+		p.compressThreshold = threshold
+	}
+}
+
+func (pg *pg) compress(data []byte) ([]byte, bool, error) {
+	if pg.compressor == nil || len(data) <= pg.compressThreshold {
+		return data, false, nil
+	}
+	if packed, err := pg.compressor.Compress(data); err != nil {
+		return nil, false, err
+	} else {
+		return packed, true, nil
+	}
+}
+
+// Restore compressed cell, gzip is assumed once compression is turned off
+func (pg *pg) inflate(data []byte, compressed bool) ([]byte, error) {
+	if !compressed {
+		return data, nil
+	}
+	c := pg.compressor
+	if c == nil {
+		c = Gzip
+	}
+	return c.Decompress(data)
+}
@@ -0,0 +1,59 @@
+package active
+
+import (
+	"context"
+	"errors"
+	"reflect"
+)
+
+type (
+	// Merge entity failed on optimistic lock with current state of its cell. Returned entity is
+	// stored over current version, error aborts the update
+	ConflictResolver func(ctx context.Context, current *Entity, attempted *Entity) (*Entity, error)
+)
+
+// Resolve optimistic lock failures of updates instead of failing them, resolved entity
+// is written once and its failure is final
+func WithConflictResolver(fn ConflictResolver) Option {
+	return func(p *pg) {
+		p.resolver = fn
+	}
+}
+
+// Reload cell entity conflicts with, let resolver merge them and retry update on fresh version.
+// On success entity holds merged state
+func (pg *pg) resolveConflict(ctx context.Context, tx execer, entity *Entity) error {
+	aCell, err := get(ctx, tx, pg.q(ctx).get, entity.Ref.RowId, entity.Ref.ColumnName)
+	if errors.Is(err, ErrNotFound) {
+		return ErrOptimisticLock
+	} else if err != nil {
+		return err
+	}
+	current := &Entity{Model: newModelLike(entity.Model), Ref: aCell.toRef()}
+	if err := pg.decode(aCell, current.Model); err != nil {
+		return err
+	}
+	merged, err := pg.resolver(ctx, current, entity)
+	if err != nil {
+		return err
+	} else if merged == nil {
+		return ErrOptimisticLock
+	}
+	merged.Ref.Version, merged.Ref.UpdatedAt = current.Ref.Version, current.Ref.UpdatedAt
+	if err := pg.updateVersion(ctx, tx, merged); err != nil {
+		return err
+	}
+	onCommit(ctx, func() {
+		entity.Model, entity.Ref = merged.Model, merged.Ref
+	})
+	return nil
+}
+
+// Fresh zero model of same type as m
+func newModelLike(m Model) Model {
+	t := reflect.TypeOf(m)
+	if t.Kind() == reflect.Ptr {
+		return reflect.New(t.Elem()).Interface().(Model)
+	}
+	return reflect.Zero(t).Interface().(Model)
+}
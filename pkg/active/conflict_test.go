@@ -0,0 +1,95 @@
+package active
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/jmoiron/sqlx/types"
+)
+
+func TestConflictResolver(t *testing.T) {
+	created := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	cols := []string{"row_id", "column_name", "version", "data", "format", "compressed", "key_id", "created_at", "updated_at"}
+	tests := []struct {
+		name     string
+		current  bool
+		resolver ConflictResolver
+		wantErr  error
+		wantData string
+		wantCall bool
+	}{
+		{
+			name:    "merged entity is written over current version",
+			current: true,
+			resolver: func(_ context.Context, current, attempted *Entity) (*Entity, error) {
+				return &Entity{Model: &RawModel{Data: types.JSONText(`{"a":1,"b":2}`)}, Ref: attempted.Ref}, nil
+			},
+			wantData: `{"a":1,"b":2}`,
+			wantCall: true,
+		},
+		{
+			name:    "resolver giving up aborts update",
+			current: true,
+			resolver: func(context.Context, *Entity, *Entity) (*Entity, error) {
+				return nil, ErrOptimisticLock
+			},
+			wantErr:  ErrOptimisticLock,
+			wantData: `{"b":2}`,
+			wantCall: true,
+		},
+		{
+			name: "deleted cell is not resolved",
+			resolver: func(context.Context, *Entity, *Entity) (*Entity, error) {
+				return nil, errors.New("unexpected call")
+			},
+			wantErr:  ErrOptimisticLock,
+			wantData: `{"b":2}`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			called := false
+			store, mock := newMockStore(t, WithConflictResolver(func(ctx context.Context, current, attempted *Entity) (*Entity, error) {
+				called = true
+				if current.Ref.Version != 3 || string(current.Model.(*RawModel).Data) != `{"a":1}` {
+					t.Errorf("expected current version 3 with stored data, got %+v", current)
+				}
+				return tt.resolver(ctx, current, attempted)
+			}))
+			mock.ExpectBegin()
+			update := mock.ExpectPrepare(`UPDATE models`)
+			update.ExpectExec().WithArgs(sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg(), 2, sqlmock.AnyArg(), "r1", "doc", 1).
+				WillReturnResult(sqlmock.NewResult(0, 0))
+			rows := sqlmock.NewRows(cols)
+			if tt.current {
+				rows.AddRow("r1", "doc", 3, []byte(`{"a":1}`), JSONFormat, false, nil, created, created)
+			}
+			mock.ExpectQuery(`SELECT .* FROM models WHERE row_id = \$1 AND column_name = \$2`).WithArgs("r1", "doc").WillReturnRows(rows)
+			if tt.wantErr == nil {
+				update.ExpectExec().WithArgs(sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg(), 4, sqlmock.AnyArg(), "r1", "doc", 3).
+					WillReturnResult(sqlmock.NewResult(0, 1))
+				mock.ExpectCommit()
+			} else {
+				mock.ExpectRollback()
+			}
+
+			e := &Entity{Model: &RawModel{Data: types.JSONText(`{"b":2}`)}, Ref: Ref{RowId: "r1", ColumnName: "doc", Version: 1, CreatedAt: created, UpdatedAt: created}}
+			err := store.Save(context.Background(), e)
+			if !errors.Is(err, tt.wantErr) || tt.wantErr == nil && err != nil {
+				t.Fatalf("expected %v, got %v", tt.wantErr, err)
+			} else if called != tt.wantCall {
+				t.Fatalf("expected resolver called %v, got %v", tt.wantCall, called)
+			} else if data := string(e.Model.(*RawModel).Data); data != tt.wantData {
+				t.Fatalf("expected entity data %s, got %s", tt.wantData, data)
+			}
+			if tt.wantErr == nil && e.Ref.Version != 4 {
+				t.Fatalf("expected merged entity at version 4, got %d", e.Ref.Version)
+			} else if tt.wantErr != nil && e.Ref.Version != 1 {
+				t.Fatalf("expected entity kept at version 1, got %d", e.Ref.Version)
+			}
+		})
+	}
+}
@@ -0,0 +1,30 @@
+package active
+
+import (
+	"context"
+	"fmt"
+)
+
+// Store value of ctx under key with every logged action in correlation_id column of action log,
+// so actions can be traced back to requests. Values other than string are formatted with fmt.
+// Actions logged without the value keep NULL
+func WithCorrelationIDKey(key any) Option {
+	return func(p *pg) {
+		p.correlationKey = key
+	}
+}
+
+// Correlation id carried by ctx, empty when missing
+func (pg *pg) correlationID(ctx context.Context) string {
+	if pg.correlationKey == nil {
+		return ""
+	}
+	switch v := ctx.Value(pg.correlationKey).(type) {
+	case nil:
+		return ""
+	case string:
+		return v
+	default:
+		return fmt.Sprint(v)
+	}
+}
@@ -0,0 +1,62 @@
+package active
+
+import (
+	"context"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/jmoiron/sqlx/types"
+)
+
+type (
+	correlationKey struct{}
+
+	// Action adding single raw cell
+	addAction struct{}
+)
+
+func (addAction) Exec(_ Params, batch *Batch) error {
+	batch.Add(&Entity{Model: &RawModel{Data: types.JSONText(`{}`)}, Ref: Ref{RowId: "r1", ColumnName: "doc"}})
+	return nil
+}
+
+func TestActionLogRow(t *testing.T) {
+	tests := []struct {
+		name    string
+		ctx     context.Context
+		run     func(ctx context.Context, store Store) error
+		changes interface{}
+		corrId  interface{}
+	}{
+		{
+			name:    "run action with correlation id",
+			ctx:     context.WithValue(context.Background(), correlationKey{}, "req-1"),
+			run:     func(ctx context.Context, store Store) error { return store.RunAction(ctx, addAction{}, Params{}) },
+			changes: types.NullJSONText{JSONText: types.JSONText(`[{"row_id":"r1","column_name":"doc","type":"add"}]`), Valid: true},
+			corrId:  "req-1",
+		},
+		{
+			name:    "logged action without correlation id",
+			ctx:     context.Background(),
+			run:     func(ctx context.Context, store Store) error { return store.LogAction(ctx, "noop", Params{}) },
+			changes: nil,
+			corrId:  nil,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store, mock := newMockStore(t, WithCorrelationIDKey(correlationKey{}))
+			mock.ExpectBegin()
+			mock.ExpectExec(`INSERT INTO action_models \(row_id, name, data, idempotency_key, changes, correlation_id, created_at\)`).
+				WithArgs(sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg(), nil, tt.changes, tt.corrId, sqlmock.AnyArg()).
+				WillReturnResult(sqlmock.NewResult(0, 1))
+			if tt.changes != nil {
+				mock.ExpectPrepare(`INSERT INTO models`).ExpectExec().WillReturnResult(sqlmock.NewResult(0, 1))
+			}
+			mock.ExpectCommit()
+			if err := tt.run(tt.ctx, store); err != nil {
+				t.Fatal(err)
+			}
+		})
+	}
+}
@@ -0,0 +1,78 @@
+package active
+
+import (
+	"fmt"
+	"sort"
+)
+
+type (
+	// Rows of bulk load which models failed to unmarshall, returned by lenient store next to loaded entities
+	DecodeError struct {
+		Errs map[Key]error
+	}
+
+	// Collects unmarshall failures of bulk load, strict one fails at once
+	decodeCollector struct {
+		lenient bool
+		errs    map[Key]error
+	}
+)
+
+// Let LoadMany and List skip rows failing to unmarshall and report them with DecodeError
+// alongside the rest, instead of failing the whole load
+func WithLenientDecode(enabled bool) Option {
+	return func(p *pg) {
+		p.lenientDecode = enabled
+	}
+}
+
+func (e *DecodeError) Error() string {
+	keys := e.keys()
+	if len(keys) == 0 {
+		return "model: cannot decode rows"
+	}
+	return fmt.Sprintf("model: cannot decode %d rows, first %s: %v", len(keys), keys[0], e.Errs[keys[0]])
+}
+
+func (e *DecodeError) Unwrap() []error {
+	keys := e.keys()
+	arr := make([]error, len(keys))
+	for i, key := range keys {
+		arr[i] = e.Errs[key]
+	}
+	return arr
+}
+
+func (e *DecodeError) keys() []Key {
+	keys := make([]Key, 0, len(e.Errs))
+	for key := range e.Errs {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		return keys[i].Compare(keys[j]) < 0
+	})
+	return keys
+}
+
+func (pg *pg) decodeCollector() *decodeCollector {
+	return &decodeCollector{lenient: pg.lenientDecode}
+}
+
+// Record failure of key, error is returned when load has to stop
+func (c *decodeCollector) fail(key Key, err error) error {
+	if !c.lenient {
+		return err
+	}
+	if c.errs == nil {
+		c.errs = map[Key]error{}
+	}
+	c.errs[key] = err
+	return nil
+}
+
+func (c *decodeCollector) err() error {
+	if len(c.errs) == 0 {
+		return nil
+	}
+	return &DecodeError{Errs: c.errs}
+}
@@ -0,0 +1,51 @@
+package active
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+func TestApplyDelete(t *testing.T) {
+	tests := []struct {
+		name     string
+		affected []int64
+		wantErr  error
+	}{
+		{
+			name:     "delete of current version",
+			affected: []int64{1},
+		},
+		{
+			name:     "stale version deletes nothing",
+			affected: []int64{0},
+			wantErr:  ErrOptimisticLock,
+		},
+		{
+			name:     "stale version rolls back deletes before it",
+			affected: []int64{1, 0},
+			wantErr:  ErrOptimisticLock,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store, mock := newMockStore(t)
+			mock.ExpectBegin()
+			prepare := mock.ExpectPrepare(`DELETE FROM models WHERE row_id = \$1 AND column_name = \$2 AND version = \$3`)
+			for _, n := range tt.affected {
+				prepare.ExpectExec().WillReturnResult(sqlmock.NewResult(0, n))
+			}
+			if tt.wantErr != nil {
+				mock.ExpectRollback()
+			} else {
+				mock.ExpectCommit()
+			}
+			err := store.ApplyChangesContext(context.Background(), deleteBatch(len(tt.affected)))
+			if !errors.Is(err, tt.wantErr) || tt.wantErr == nil && err != nil {
+				t.Fatalf("expected %v, got %v", tt.wantErr, err)
+			}
+		})
+	}
+}
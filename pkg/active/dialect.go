@@ -0,0 +1,384 @@
+package active
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/jmoiron/sqlx"
+)
+
+type (
+	// SQL flavour of underlying database, statements are produced for given table with '?' placeholders
+	// rebound to BindType. Statements not covered here are shared Postgres SQL rebound the same way
+	Dialect interface {
+		// Placeholder style, one of sqlx bind types (sqlx.DOLLAR, sqlx.QUESTION, ...)
+		BindType() int
+
+		// Select single not deleted cell by row_id and column_name
+		Get(table string) string
+
+		// Insert cell with row_id, column_name, version, data, format, compressed, key_id, created_at, updated_at
+		Insert(table string) string
+
+		// Update data, format, compressed, key_id, version, updated_at of not deleted cell matched by row_id, column_name and version
+		Update(table string) string
+
+		// Delete cell matched by row_id, column_name and version
+		Delete(table string) string
+
+		// Insert cell like Insert unless (row_id, column_name) is already taken
+		InsertIfAbsent(table string) string
+
+		// Insert cell like Insert, on (row_id, column_name) conflict overwrite data and updated_at
+		// incrementing stored version. Returns resulting version
+		Upsert(table string) string
+
+		// Insert action log row with row_id, name, data, idempotency_key, changes, correlation_id, created_at
+		ActionInsert(table string) string
+
+		// Select not deleted cells of column_name whose top level data field named by second argument
+		// equals third argument as text
+		FindByField(table string) string
+
+		// Delete cells of column_name whose top level data field named by second argument equals third argument as text
+		DeleteByField(table string) string
+
+		// Select not deleted cells of column_name whose data contains JSON document of second argument
+		FindContaining(table string) string
+
+		// Idempotent DDL creating models, action log and, unless name is empty, version history tables
+		Schema(table, actionTable, versionsTable string) []string
+	}
+
+	postgresDialect struct{}
+
+	// Statements of store bound to its tables and dialect
+	statements struct {
+		get           string
+		getAny        string
+		getForUpdate  string
+		getRow        string
+		insertDefault string
+		insertStamped string
+		updateMany    string
+		restore       string
+		versions      string
+		touch         string
+		versionLock   string
+		notify        string
+		insert        string
+		update        string
+		updateByTime  string
+		delete        string
+		upsert        string
+		insertAbsent  string
+		softDelete    string
+		countByColumn string
+		countByRow    string
+		exists        string
+		insertMany    string
+		actionInsert  string
+		actions       string
+		actionsBefore string
+		affectedBy    string
+		fingerprint   string
+		purgeFps      string
+		findByField   string
+		findContains  string
+		deleteByField string
+		deleteByDoc   string
+		loadMany      string
+		archive       string
+		history       string
+		stream        string
+		patch         string
+		listAsc       string
+		listAfter     string
+		listDesc      string
+		listBefore    string
+	}
+)
+
+const (
+	defaultTable       = "models"
+	defaultActionTable = "action_models"
+)
+
+const (
+	sqlActionsInsert = `INSERT INTO %s (row_id, name, data, idempotency_key, changes, correlation_id, created_at) VALUES (?, ?, ?, ?, ?, ?, ?)`
+
+	sqlGet = `SELECT row_id, column_name, version, data, format, compressed, key_id, created_at, updated_at FROM %s WHERE row_id = ? AND column_name = ? AND deleted_at IS NULL`
+	// A fresh row never has updated_at NULL: it is expected to equal created_at
+	sqlInsert = `INSERT INTO %s (row_id, column_name, version, data, format, compressed, key_id, created_at, updated_at) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)`
+	sqlUpdate = `UPDATE %s 
+		SET data = ?, format = ?, compressed = ?, key_id = ?, version = ?, updated_at = ? 
+		WHERE row_id = ? AND column_name = ? AND version = ? AND deleted_at IS NULL`
+	sqlDelete = `DELETE FROM %s WHERE row_id = ? AND column_name = ? AND version = ?`
+	sqlUpsert = `INSERT INTO %[1]s (row_id, column_name, version, data, format, compressed, key_id, created_at, updated_at) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)
+		ON CONFLICT (row_id, column_name) DO UPDATE 
+		SET data = EXCLUDED.data, format = EXCLUDED.format, compressed = EXCLUDED.compressed, key_id = EXCLUDED.key_id, version = %[1]s.version + 1, updated_at = EXCLUDED.updated_at, deleted_at = NULL
+		RETURNING version`
+	sqlInsertIfAbsent = `INSERT INTO %s (row_id, column_name, version, data, format, compressed, key_id, created_at, updated_at) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)
+		ON CONFLICT (row_id, column_name) DO NOTHING`
+	sqlFindByField = `SELECT row_id, column_name, version, data, format, compressed, key_id, created_at, updated_at FROM %s 
+		WHERE column_name = ? AND data ->> ? = ? AND deleted_at IS NULL`
+	sqlDeleteByField  = `DELETE FROM %s WHERE column_name = ? AND data ->> ? = ?`
+	sqlFindContaining = `SELECT row_id, column_name, version, data, format, compressed, key_id, created_at, updated_at FROM %s 
+		WHERE column_name = ? AND data @> ? AND deleted_at IS NULL`
+	sqlDeleteContaining = `DELETE FROM %s WHERE column_name = ? AND data @> ?`
+)
+
+const (
+	ddlModels = `CREATE TABLE IF NOT EXISTS %s (
+		row_id      TEXT        NOT NULL,
+		column_name TEXT        NOT NULL,
+		version     BIGINT      NOT NULL DEFAULT 0,
+		data        JSONB,
+		format      TEXT        NOT NULL DEFAULT 'json',
+		compressed  BOOLEAN     NOT NULL DEFAULT false,
+		key_id      TEXT,
+		created_at  TIMESTAMPTZ NOT NULL,
+		updated_at  TIMESTAMPTZ NOT NULL,
+		deleted_at  TIMESTAMPTZ,
+		PRIMARY KEY (row_id, column_name)
+	)`
+	ddlActions = `CREATE TABLE IF NOT EXISTS %s (
+		row_id          TEXT        PRIMARY KEY,
+		name            TEXT        NOT NULL,
+		data            JSONB,
+		idempotency_key TEXT        UNIQUE,
+		changes         JSONB,
+		correlation_id  TEXT,
+		created_at      TIMESTAMPTZ NOT NULL
+	)`
+	ddlActionsCreatedAt = `CREATE INDEX IF NOT EXISTS %[2]s ON %[1]s (created_at, row_id)`
+	ddlVersions         = `CREATE TABLE IF NOT EXISTS %s (
+		row_id      TEXT        NOT NULL,
+		column_name TEXT        NOT NULL,
+		version     BIGINT      NOT NULL,
+		data        JSONB,
+		format      TEXT        NOT NULL DEFAULT 'json',
+		compressed  BOOLEAN     NOT NULL DEFAULT false,
+		key_id      TEXT,
+		created_at  TIMESTAMPTZ NOT NULL,
+		updated_at  TIMESTAMPTZ NOT NULL,
+		PRIMARY KEY (row_id, column_name, version)
+	)`
+)
+
+const (
+	ddlFingerprints = `CREATE TABLE IF NOT EXISTS %s (
+		fingerprint TEXT        PRIMARY KEY,
+		created_at  TIMESTAMPTZ NOT NULL
+	)`
+)
+
+// Portable statements with '?' placeholders, rebound for dialect on use
+const (
+	sqlCountByColumn = `SELECT count(*) FROM %s WHERE column_name = ? AND deleted_at IS NULL`
+	sqlCountByRow    = `SELECT count(*) FROM %s WHERE row_id = ? AND deleted_at IS NULL`
+	sqlExists        = `SELECT EXISTS(SELECT 1 FROM %s WHERE row_id = ? AND column_name = ? AND deleted_at IS NULL)`
+	sqlGetAny        = `SELECT row_id, column_name, version, data, format, compressed, key_id, created_at, updated_at FROM %s WHERE row_id = ? AND column_name = ?`
+	sqlUpdateByTime  = `UPDATE %s 
+		SET data = ?, format = ?, compressed = ?, key_id = ?, version = version + 1, updated_at = ? 
+		WHERE row_id = ? AND column_name = ? AND updated_at = ? AND deleted_at IS NULL`
+	sqlSoftDelete = `UPDATE %s 
+		SET deleted_at = ?, version = ?, updated_at = ? 
+		WHERE row_id = ? AND column_name = ? AND version = ? AND deleted_at IS NULL`
+	sqlInsertMany = `INSERT INTO %s (row_id, column_name, version, data, format, compressed, key_id, created_at, updated_at) VALUES `
+	sqlLoadMany   = `SELECT row_id, column_name, version, data, format, compressed, key_id, created_at, updated_at FROM %s WHERE deleted_at IS NULL AND (row_id, column_name) IN `
+	sqlArchive    = `INSERT INTO %[2]s (row_id, column_name, version, data, format, compressed, key_id, created_at, updated_at) 
+		SELECT row_id, column_name, version, data, format, compressed, key_id, created_at, updated_at FROM %[1]s 
+		WHERE row_id = ? AND column_name = ? AND version = ? AND deleted_at IS NULL`
+	sqlHistory = `SELECT row_id, column_name, version, data, format, compressed, key_id, created_at, updated_at FROM %s 
+		WHERE row_id = ? AND column_name = ? ORDER BY version`
+	sqlStream = `SELECT row_id, column_name, version, data, format, compressed, key_id, created_at, updated_at FROM %s 
+		WHERE column_name = ? AND deleted_at IS NULL ORDER BY row_id`
+	sqlPatch = `UPDATE %s 
+		SET data = data || CAST(? AS jsonb), version = ?, updated_at = ? 
+		WHERE row_id = ? AND column_name = ? AND version = ? AND deleted_at IS NULL 
+		AND format = 'json' AND NOT compressed AND key_id IS NULL`
+	sqlListAsc = `SELECT row_id, column_name, version, data, format, compressed, key_id, created_at, updated_at FROM %s 
+		WHERE column_name = ? AND deleted_at IS NULL ORDER BY created_at, row_id LIMIT ?`
+	sqlListAfter = `SELECT row_id, column_name, version, data, format, compressed, key_id, created_at, updated_at FROM %s 
+		WHERE column_name = ? AND deleted_at IS NULL AND (created_at, row_id) > (?, ?) ORDER BY created_at, row_id LIMIT ?`
+	sqlListDesc = `SELECT row_id, column_name, version, data, format, compressed, key_id, created_at, updated_at FROM %s 
+		WHERE column_name = ? AND deleted_at IS NULL ORDER BY created_at DESC, row_id DESC LIMIT ?`
+	sqlListBefore = `SELECT row_id, column_name, version, data, format, compressed, key_id, created_at, updated_at FROM %s 
+		WHERE column_name = ? AND deleted_at IS NULL AND (created_at, row_id) < (?, ?) ORDER BY created_at DESC, row_id DESC LIMIT ?`
+	sqlActions       = `SELECT row_id, name, data, created_at FROM %s ORDER BY created_at DESC, row_id DESC LIMIT ?`
+	sqlActionsBefore = `SELECT row_id, name, data, created_at FROM %s WHERE (created_at, row_id) < (?, ?) ORDER BY created_at DESC, row_id DESC LIMIT ?`
+	sqlAffectedBy    = `SELECT changes FROM %s WHERE row_id = ?`
+	sqlFingerprint   = `INSERT INTO %s (fingerprint, created_at) VALUES (?, ?) ON CONFLICT (fingerprint) DO NOTHING`
+	sqlPurgeFps      = `DELETE FROM %s WHERE created_at < ?`
+)
+
+// Inserts of WithInsertReturning
+const (
+	sqlInsertDefault = `INSERT INTO %s (row_id, column_name, version, data, format, compressed, key_id) VALUES (?, ?, ?, ?, ?, ?, ?)
+		RETURNING version, created_at, updated_at`
+	sqlInsertStamped = `INSERT INTO %s (row_id, column_name, version, data, format, compressed, key_id, created_at, updated_at) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)
+		RETURNING version, created_at, updated_at`
+)
+
+const sqlRestore = `UPDATE %s
+	SET data = ?, format = ?, compressed = ?, key_id = ?, version = ?, updated_at = ?, deleted_at = NULL
+	WHERE row_id = ? AND column_name = ? AND version = ?`
+
+const sqlVersionLock = `SELECT version FROM %s WHERE row_id = ? AND column_name = ? AND deleted_at IS NULL FOR UPDATE`
+
+const sqlNotify = `SELECT pg_notify(?, ?)`
+
+const sqlGetRow = `SELECT row_id, column_name, version, data, format, compressed, key_id, created_at, updated_at FROM %s
+	WHERE row_id = ? AND deleted_at IS NULL ORDER BY column_name`
+
+const sqlTouch = `UPDATE %s SET version = version + 1, updated_at = ?
+	WHERE row_id = ? AND column_name = ? AND version = ? AND deleted_at IS NULL`
+
+const sqlVersions = `SELECT row_id, column_name, version FROM %s WHERE deleted_at IS NULL AND (row_id, column_name) IN `
+
+// Multi-row update, typed empty select ahead of VALUES gives their bind parameters types of models columns
+const (
+	sqlUpdateMany = `UPDATE %[1]s AS m
+	SET data = c.data, format = c.format, compressed = c.compressed, key_id = c.key_id, version = m.version + 1, updated_at = c.updated_at
+	FROM (SELECT row_id, column_name, data, format, compressed, key_id, version, updated_at FROM %[1]s WHERE false UNION ALL VALUES `
+	sqlUpdateManyMatch = `) AS c
+	WHERE m.row_id = c.row_id AND m.column_name = c.column_name AND m.version = c.version AND m.deleted_at IS NULL`
+)
+
+var (
+	// Default dialect of New
+	Postgres Dialect = postgresDialect{}
+
+	ErrInvalidIdentifier = errors.New("model: invalid identifier")
+
+	// Plain or schema qualified SQL identifier
+	_identifierRe = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*(\.[A-Za-z_][A-Za-z0-9_]*)?$`)
+)
+
+func (postgresDialect) BindType() int              { return sqlx.DOLLAR }
+func (postgresDialect) Get(table string) string    { return fmt.Sprintf(sqlGet, table) }
+func (postgresDialect) Insert(table string) string { return fmt.Sprintf(sqlInsert, table) }
+func (postgresDialect) Update(table string) string { return fmt.Sprintf(sqlUpdate, table) }
+func (postgresDialect) Delete(table string) string { return fmt.Sprintf(sqlDelete, table) }
+func (postgresDialect) Upsert(table string) string { return fmt.Sprintf(sqlUpsert, table) }
+func (postgresDialect) InsertIfAbsent(table string) string {
+	return fmt.Sprintf(sqlInsertIfAbsent, table)
+}
+func (postgresDialect) ActionInsert(table string) string { return fmt.Sprintf(sqlActionsInsert, table) }
+func (postgresDialect) FindByField(table string) string {
+	return fmt.Sprintf(sqlFindByField, table)
+}
+func (postgresDialect) DeleteByField(table string) string {
+	return fmt.Sprintf(sqlDeleteByField, table)
+}
+func (postgresDialect) FindContaining(table string) string {
+	return fmt.Sprintf(sqlFindContaining, table)
+}
+func (postgresDialect) Schema(table, actionTable, versionsTable string) []string {
+	ddl := []string{
+		fmt.Sprintf(ddlModels, table),
+		fmt.Sprintf(ddlActions, actionTable),
+		fmt.Sprintf(ddlActionsCreatedAt, actionTable, indexName(actionTable, "created_at_row_id")),
+	}
+	if versionsTable != "" {
+		ddl = append(ddl, fmt.Sprintf(ddlVersions, versionsTable))
+	}
+	return ddl
+}
+
+// Use table instead of "models"
+func WithTable(name string) Option {
+	return func(p *pg) {
+		if err := checkIdentifier(name); err != nil {
+			p.fail(err)
+		} else {
+			p.table = name
+		}
+	}
+}
+
+// Use table instead of "action_models" for action log
+func WithActionTable(name string) Option {
+	return func(p *pg) {
+		if err := checkIdentifier(name); err != nil {
+			p.fail(err)
+		} else {
+			p.actionTable = name
+		}
+	}
+}
+
+// Identifiers are interpolated into SQL, so anything but plain names is refused
+func checkIdentifier(name string) error {
+	if !_identifierRe.MatchString(name) {
+		return fmt.Errorf("%w: %w: %q", ErrInvalidOption, ErrInvalidIdentifier, name)
+	}
+	return nil
+}
+
+// Index name derived from table, schema qualifier is dropped since index lives in table schema
+func indexName(table, column string) string {
+	if i := strings.LastIndex(table, "."); i >= 0 {
+		table = table[i+1:]
+	}
+	return table + "_" + column + "_idx"
+}
+
+func buildStatements(d Dialect, table, actionTable, versionsTable, fingerprintTable string) statements {
+	bound := func(query string) string {
+		return sqlx.Rebind(d.BindType(), query)
+	}
+	portable := func(query string) string {
+		return sqlx.Rebind(d.BindType(), fmt.Sprintf(query, table))
+	}
+	portableAction := func(query string) string {
+		return sqlx.Rebind(d.BindType(), fmt.Sprintf(query, actionTable))
+	}
+	return statements{
+		get:           bound(d.Get(table)),
+		getAny:        portable(sqlGetAny),
+		getForUpdate:  bound(d.Get(table) + " FOR UPDATE"),
+		getRow:        portable(sqlGetRow),
+		insertDefault: portable(sqlInsertDefault),
+		insertStamped: portable(sqlInsertStamped),
+		updateMany:    fmt.Sprintf(sqlUpdateMany, table),
+		restore:       portable(sqlRestore),
+		versions:      fmt.Sprintf(sqlVersions, table),
+		touch:         portable(sqlTouch),
+		versionLock:   portable(sqlVersionLock),
+		notify:        bound(sqlNotify),
+		insert:        bound(d.Insert(table)),
+		update:        bound(d.Update(table)),
+		updateByTime:  portable(sqlUpdateByTime),
+		delete:        bound(d.Delete(table)),
+		upsert:        bound(d.Upsert(table)),
+		insertAbsent:  bound(d.InsertIfAbsent(table)),
+		softDelete:    portable(sqlSoftDelete),
+		countByColumn: portable(sqlCountByColumn),
+		countByRow:    portable(sqlCountByRow),
+		exists:        portable(sqlExists),
+		insertMany:    fmt.Sprintf(sqlInsertMany, table),
+		actionInsert:  bound(d.ActionInsert(actionTable)),
+		actions:       portableAction(sqlActions),
+		actionsBefore: portableAction(sqlActionsBefore),
+		affectedBy:    portableAction(sqlAffectedBy),
+		fingerprint:   bound(fmt.Sprintf(sqlFingerprint, fingerprintTable)),
+		purgeFps:      bound(fmt.Sprintf(sqlPurgeFps, fingerprintTable)),
+		findByField:   bound(d.FindByField(table)),
+		findContains:  bound(d.FindContaining(table)),
+		deleteByField: bound(d.DeleteByField(table)),
+		deleteByDoc:   portable(sqlDeleteContaining),
+		loadMany:      fmt.Sprintf(sqlLoadMany, table),
+		archive:       bound(fmt.Sprintf(sqlArchive, table, versionsTable)),
+		history:       bound(fmt.Sprintf(sqlHistory, versionsTable)),
+		stream:        portable(sqlStream),
+		patch:         portable(sqlPatch),
+		listAsc:       portable(sqlListAsc),
+		listAfter:     portable(sqlListAfter),
+		listDesc:      portable(sqlListDesc),
+		listBefore:    portable(sqlListBefore),
+	}
+}
@@ -0,0 +1,64 @@
+package active
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/jmoiron/sqlx"
+	"github.com/jmoiron/sqlx/types"
+)
+
+// Dialect speaking '?' placeholders with own get and insert statements
+type questionDialect struct {
+	postgresDialect
+}
+
+func (questionDialect) BindType() int { return sqlx.QUESTION }
+func (questionDialect) Get(table string) string {
+	return "SELECT row_id, column_name, version, data, format, compressed, key_id, created_at, updated_at FROM " + table +
+		" WHERE row_id = ? AND column_name = ? AND deleted_at IS NULL LIMIT 1"
+}
+func (questionDialect) Insert(table string) string {
+	return "INSERT IGNORE INTO " + table +
+		" (row_id, column_name, version, data, format, compressed, key_id, created_at, updated_at) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)"
+}
+
+func TestNewWithDialect(t *testing.T) {
+	at := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	store := NewWithDialect(sqlx.NewDb(db, "mysql"), questionDialect{}, WithClock(func() time.Time { return at }))
+
+	mock.ExpectQuery(`SELECT .* FROM models WHERE row_id = \? AND column_name = \? AND deleted_at IS NULL LIMIT 1`).
+		WithArgs("r1", "doc").
+		WillReturnRows(sqlmock.NewRows([]string{"row_id", "column_name", "version", "data", "format", "compressed", "key_id", "created_at", "updated_at"}).
+			AddRow("r1", "doc", 2, []byte(`{"a":1}`), JSONFormat, false, nil, at, at))
+	mock.ExpectBegin()
+	mock.ExpectPrepare(`INSERT IGNORE INTO models \(.*\) VALUES \(\?, \?, \?, \?, \?, \?, \?, \?, \?\)`).
+		ExpectExec().WithArgs("r2", "doc", 0, []byte(`{}`), JSONFormat, false, nil, at, at).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+
+	if e, err := store.Load(context.Background(), &RawModel{}, "r1", "doc"); err != nil {
+		t.Fatal(err)
+	} else if e.Ref.Version != 2 {
+		t.Fatalf("expected version 2, got %d", e.Ref.Version)
+	}
+	if err := store.Save(context.Background(), &Entity{Model: &RawModel{Data: types.JSONText(`{}`)}, Ref: Ref{RowId: "r2", ColumnName: "doc"}}); err != nil {
+		t.Fatal(err)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestNewWithNilDialectIsPostgres(t *testing.T) {
+	if store := NewWithDialect(nil, nil).(*pg); store.dialect != Postgres {
+		t.Fatalf("expected Postgres dialect, got %T", store.dialect)
+	}
+}
@@ -0,0 +1,113 @@
+package active
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"database/sql"
+	"errors"
+	"fmt"
+	"io"
+)
+
+type (
+	// Encryption of data column, KeyId is stored along with cell so keys can be rotated
+	Cipher interface {
+		KeyId() string
+
+		Encrypt(plain []byte) ([]byte, error)
+
+		Decrypt(sealed []byte) ([]byte, error)
+	}
+
+	// AES-GCM cipher, random nonce is prepended to every sealed value
+	aesCipher struct {
+		keyId string
+		aead  cipher.AEAD
+	}
+)
+
+var (
+	ErrDecrypt = errors.New("model: cannot decrypt data")
+)
+
+// AES-GCM cipher identified by keyId, key has to be 16, 24 or 32 bytes long
+func NewAESCipher(keyId string, key []byte) (Cipher, error) {
+	if keyId == "" {
+		return nil, errors.New("model: empty key id")
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	return &aesCipher{keyId: keyId, aead: aead}, nil
+}
+
+func (c *aesCipher) KeyId() string { return c.keyId }
+
+func (c *aesCipher) Encrypt(plain []byte) ([]byte, error) {
+	nonce := make([]byte, c.aead.NonceSize(), c.aead.NonceSize()+len(plain)+c.aead.Overhead())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return nil, err
+	}
+	return c.aead.Seal(nonce, nonce, plain, nil), nil
+}
+
+func (c *aesCipher) Decrypt(sealed []byte) ([]byte, error) {
+	size := c.aead.NonceSize()
+	if len(sealed) < size {
+		return nil, errors.New("sealed value too short")
+	}
+	return c.aead.Open(nil, sealed[:size], sealed[size:], nil)
+}
+
+// Encrypt new writes with cipher, cipher is also registered for reads. Sealed data is stored
+// as base64 JSON string, so JSON queries fail with ErrOpaqueData
+func WithEncryption(c Cipher) Option {
+	return func(p *pg) {
+		if c != nil {
+			p.cipher = c
+			p.ciphers[c.KeyId()] = c
+		}
+	}
+}
+
+// Register retired cipher used only to read cells encrypted with its key
+func WithDecryption(c Cipher) Option {
+	return func(p *pg) {
+		if c != nil {
+			p.ciphers[c.KeyId()] = c
+		}
+	}
+}
+
+func (pg *pg) encrypt(data []byte) ([]byte, sql.NullString, error) {
+	if pg.cipher == nil {
+		return data, sql.NullString{}, nil
+	}
+	if sealed, err := pg.cipher.Encrypt(data); err != nil {
+		return nil, sql.NullString{}, err
+	} else {
+		return sealed, sql.NullString{String: pg.cipher.KeyId(), Valid: true}, nil
+	}
+}
+
+// Open cell sealed by key, missing key id means plain data
+func (pg *pg) decrypt(data []byte, keyId sql.NullString) ([]byte, error) {
+	if !keyId.Valid {
+		return data, nil
+	}
+	c, ok := pg.ciphers[keyId.String]
+	if !ok {
+		return nil, fmt.Errorf("%w: unknown key %q", ErrDecrypt, keyId.String)
+	}
+	if plain, err := c.Decrypt(data); err != nil {
+		return nil, fmt.Errorf("%w with key %q: %w", ErrDecrypt, keyId.String, err)
+	} else {
+		return plain, nil
+	}
+}
@@ -0,0 +1,103 @@
+package active
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"reflect"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// Find entities of column whose top level JSON data field equals value, factory provides model for each row.
+// Scalar values are compared as text with data ->> field, maps, slices and structs by containment (@>),
+// nil by containment too, so it matches field holding JSON null but not missing one.
+// Requires JSON codec without compression and encryption, ErrOpaqueData otherwise. Recommended indexes are expression one for frequently
+// queried fields, e.g. CREATE INDEX ON models ((data ->> 'email')), and for containment
+// CREATE INDEX ON models USING GIN (data jsonb_path_ops)
+func (pg *pg) FindByJSON(ctx context.Context, columnName, jsonPath string, value any, factory func() Model) ([]*Entity, error) {
+	ctx, err := pg.enter(ctx)
+	if err != nil {
+		return nil, err
+	} else if err := pg.checkPlainJSON(); err != nil {
+		return nil, err
+	}
+	ctx, cancel := pg.withDefaultTimeout(ctx)
+	defer cancel()
+	var cells []cell
+	if isScalar(value) {
+		if err := sqlx.SelectContext(ctx, pg.queryer(ctx), &cells, pg.q(ctx).findByField,
+			columnName, jsonPath, scalarText(value)); err != nil {
+			return nil, err
+		}
+	} else if doc, err := pg.json.marshal(map[string]any{jsonPath: value}); err != nil {
+		return nil, err
+	} else if err := sqlx.SelectContext(ctx, pg.queryer(ctx), &cells, pg.q(ctx).findContains,
+		columnName, string(doc)); err != nil {
+		return nil, err
+	}
+
+	arr := make([]*Entity, 0, len(cells))
+	for _, aCell := range cells {
+		m := factory()
+		if err := pg.decode(aCell, m); err != nil {
+			return nil, err
+		}
+		arr = append(arr, &Entity{Model: m, Ref: aCell.toRef()})
+	}
+	return arr, nil
+}
+
+// Value compared as text, nil and nil pointers are not as they stand for JSON null
+func isScalar(value any) bool {
+	v := reflect.ValueOf(value)
+	for v.Kind() == reflect.Pointer {
+		if v.IsNil() {
+			return false
+		}
+		v = v.Elem()
+	}
+	switch v.Kind() {
+	case reflect.Invalid, reflect.Map, reflect.Slice, reflect.Array, reflect.Struct:
+		return false
+	default:
+		return true
+	}
+}
+
+// Text of scalar value as data ->> field gives it, pointers are followed
+func scalarText(value any) string {
+	v := reflect.ValueOf(value)
+	for v.Kind() == reflect.Pointer {
+		v = v.Elem()
+	}
+	return fmt.Sprint(v.Interface())
+}
+
+// Delete entities of column whose top level JSON data field equals value like in FindByJSON, without loading them.
+// Matching rows are removed whatever their version, bypassing optimistic locking, and soft deleted
+// ones go as well. Requires JSON codec without compression and encryption, ErrOpaqueData otherwise.
+// Returns number of removed rows
+func (pg *pg) DeleteWhere(ctx context.Context, columnName, jsonPath string, value any) (int64, error) {
+	ctx, err := pg.enter(ctx)
+	if err != nil {
+		return 0, err
+	} else if err := pg.checkPlainJSON(); err != nil {
+		return 0, err
+	}
+	ctx, cancel := pg.withDefaultTimeout(ctx)
+	defer cancel()
+	var r sql.Result
+	if isScalar(value) {
+		r, err = pg.exec(ctx, pg.q(ctx).deleteByField, columnName, jsonPath, scalarText(value))
+	} else if doc, marshalErr := pg.json.marshal(map[string]any{jsonPath: value}); marshalErr != nil {
+		return 0, marshalErr
+	} else {
+		r, err = pg.exec(ctx, pg.q(ctx).deleteByDoc, columnName, string(doc))
+	}
+	if err != nil {
+		return 0, err
+	} else {
+		return r.RowsAffected()
+	}
+}
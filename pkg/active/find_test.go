@@ -0,0 +1,102 @@
+package active
+
+import (
+	"context"
+	"database/sql/driver"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+func TestFindByJSONValues(t *testing.T) {
+	var (
+		email   = "a@b.c"
+		missing *string
+	)
+	tests := []struct {
+		name  string
+		value any
+		query string
+		args  []driver.Value
+	}{
+		{
+			name:  "text",
+			value: email,
+			query: `data ->> \$2 = \$3`,
+			args:  []driver.Value{"doc", "email", email},
+		},
+		{
+			name:  "pointer is followed",
+			value: &email,
+			query: `data ->> \$2 = \$3`,
+			args:  []driver.Value{"doc", "email", email},
+		},
+		{
+			name:  "number",
+			value: 42,
+			query: `data ->> \$2 = \$3`,
+			args:  []driver.Value{"doc", "email", "42"},
+		},
+		{
+			name:  "nil matches JSON null",
+			value: nil,
+			query: `data @> \$2`,
+			args:  []driver.Value{"doc", `{"email":null}`},
+		},
+		{
+			name:  "nil pointer matches JSON null",
+			value: missing,
+			query: `data @> \$2`,
+			args:  []driver.Value{"doc", `{"email":null}`},
+		},
+		{
+			name:  "map by containment",
+			value: map[string]any{"verified": true},
+			query: `data @> \$2`,
+			args:  []driver.Value{"doc", `{"email":{"verified":true}}`},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store, mock := newMockStore(t)
+			mock.ExpectQuery(tt.query).WithArgs(tt.args...).WillReturnRows(sqlmock.NewRows(
+				[]string{"row_id", "column_name", "version", "data", "format", "compressed", "key_id", "created_at", "updated_at"}))
+			if _, err := store.FindByJSON(context.Background(), "doc", "email", tt.value, func() Model { return &RawModel{} }); err != nil {
+				t.Fatal(err)
+			}
+		})
+	}
+}
+
+func TestDeleteWhereValues(t *testing.T) {
+	tests := []struct {
+		name  string
+		value any
+		query string
+		args  []driver.Value
+	}{
+		{
+			name:  "text",
+			value: "a@b.c",
+			query: `DELETE FROM models WHERE column_name = \$1 AND data ->> \$2 = \$3`,
+			args:  []driver.Value{"doc", "email", "a@b.c"},
+		},
+		{
+			name:  "nil matches JSON null",
+			value: nil,
+			query: `DELETE FROM models WHERE column_name = \$1 AND data @> \$2`,
+			args:  []driver.Value{"doc", `{"email":null}`},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store, mock := newMockStore(t)
+			mock.ExpectExec(tt.query).WithArgs(tt.args...).WillReturnResult(sqlmock.NewResult(0, 1))
+			if num, err := store.DeleteWhere(context.Background(), "doc", "email", tt.value); err != nil {
+				t.Fatal(err)
+			} else if num != 1 {
+				t.Fatalf("expected 1 deleted row, got %d", num)
+			}
+		})
+	}
+}
@@ -0,0 +1,90 @@
+package active
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/jmoiron/sqlx/types"
+)
+
+type (
+	// Archived version of cell
+	VersionRecord struct {
+		RowId      string         `db:"row_id"`
+		ColumnName string         `db:"column_name"`
+		Version    uint           `db:"version"`
+		Data       types.JSONText `db:"data"`
+		Format     string         `db:"format"`
+		Compressed bool           `db:"compressed"`
+		KeyId      sql.NullString `db:"key_id"`
+		CreatedAt  time.Time      `db:"created_at"`
+		UpdatedAt  time.Time      `db:"updated_at"`
+	}
+)
+
+const (
+	defaultVersionsTable = "model_versions"
+)
+
+// Keep every overwritten version of cell in history table, within transaction of the update
+func WithVersioning(enabled bool) Option {
+	return func(p *pg) {
+		p.versioning = enabled
+	}
+}
+
+// Use table instead of "model_versions" for version history
+func WithVersionsTable(name string) Option {
+	return func(p *pg) {
+		if err := checkIdentifier(name); err != nil {
+			p.fail(err)
+		} else {
+			p.historyTable = name
+		}
+	}
+}
+
+// Archived versions of cell, oldest first. Current version stays in models table
+func (pg *pg) History(ctx context.Context, rowId, columnName string) ([]VersionRecord, error) {
+	ctx, err := pg.enter(ctx)
+	if err != nil {
+		return nil, err
+	}
+	ctx, cancel := pg.withDefaultTimeout(ctx)
+	defer cancel()
+	var arr []VersionRecord
+	if err := sqlx.SelectContext(ctx, pg.queryer(ctx), &arr, pg.q(ctx).history, rowId, columnName); err != nil {
+		return nil, err
+	}
+	return arr, nil
+}
+
+// History table name, empty when versioning is off
+func (pg *pg) versionsTable() string {
+	if !pg.versioning {
+		return ""
+	}
+	return pg.historyTable
+}
+
+// Copy version being overwritten into history, missing version means stale entity
+func (pg *pg) archive(ctx context.Context, tx execer, entity *Entity) error {
+	st := pg.archiveStatement(ctx, entity)
+	if r, err := tx.ExecContext(ctx, st.SQL, st.Args...); err != nil {
+		return wrapErr("archive", entity.Ref, err)
+	} else if err := pg.expectOne(ctx, r, entity.Ref); err != nil {
+		return fmt.Errorf("archive %s: %w", entity.Ref.Key(), err)
+	}
+	return nil
+}
+
+func (pg *pg) archiveStatement(ctx context.Context, entity *Entity) PlannedStatement {
+	return PlannedStatement{SQL: pg.q(ctx).archive, Args: []interface{}{
+		entity.Ref.RowId,
+		entity.Ref.ColumnName,
+		entity.Ref.Version,
+	}}
+}
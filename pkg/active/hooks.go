@@ -0,0 +1,45 @@
+package active
+
+import (
+	"context"
+)
+
+type (
+	// Model checked or adjusted before insert or update, error aborts transaction
+	BeforeSave interface {
+		BeforeSave(ctx context.Context) error
+	}
+
+	// Model notified after insert or update statement, before commit
+	AfterSave interface {
+		AfterSave(ctx context.Context)
+	}
+
+	// Model checked before delete, error aborts transaction
+	BeforeDelete interface {
+		BeforeDelete(ctx context.Context) error
+	}
+)
+
+func beforeChange(ctx context.Context, change Change) error {
+	switch change.T {
+	case AddChangeType, UpdateChangeType:
+		if h, ok := change.V.Model.(BeforeSave); ok {
+			return h.BeforeSave(ctx)
+		}
+	case DeleteChangeType:
+		if h, ok := change.V.Model.(BeforeDelete); ok {
+			return h.BeforeDelete(ctx)
+		}
+	}
+	return nil
+}
+
+func afterChange(ctx context.Context, change Change) {
+	switch change.T {
+	case AddChangeType, UpdateChangeType:
+		if h, ok := change.V.Model.(AfterSave); ok {
+			h.AfterSave(ctx)
+		}
+	}
+}
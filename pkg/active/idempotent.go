@@ -0,0 +1,59 @@
+package active
+
+import (
+	"context"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+)
+
+const (
+	defaultFingerprintTable = "applied_batches"
+)
+
+// Use table instead of "applied_batches" for fingerprints of idempotent applies
+func WithFingerprintTable(name string) Option {
+	return func(p *pg) {
+		if err := checkIdentifier(name); err != nil {
+			p.fail(err)
+		} else {
+			p.fingerprintTable = name
+		}
+	}
+}
+
+// Apply batch unless batch with same fingerprint was applied before, so client retrying after
+// ambiguous failure does not apply it twice. Fingerprint is recorded in the same transaction;
+// concurrent duplicate waits for the first one and returns nil once it commits
+func (pg *pg) ApplyIdempotent(ctx context.Context, batch Batch, fingerprint string) error {
+	items := batch.Items()
+	if err := validate(items); err != nil {
+		return err
+	}
+	return pg.inTx(ctx, func(ctx context.Context, tx *sqlx.Tx) error {
+		if r, err := tx.ExecContext(ctx, pg.q(ctx).fingerprint, fingerprint, pg.timestamp()); err != nil {
+			return wrapOpErr("record fingerprint "+fingerprint, err)
+		} else if num, err := r.RowsAffected(); err != nil {
+			return err
+		} else if num == 0 {
+			return nil
+		}
+		return pg.apply(ctx, tx, items)
+	})
+}
+
+// Remove fingerprints recorded before given time, batches they guarded may be applied again.
+// Returns number of removed fingerprints
+func (pg *pg) PurgeFingerprints(ctx context.Context, before time.Time) (int64, error) {
+	ctx, err := pg.enter(ctx)
+	if err != nil {
+		return 0, err
+	}
+	ctx, cancel := pg.withDefaultTimeout(ctx)
+	defer cancel()
+	if r, err := pg.exec(ctx, pg.q(ctx).purgeFps, before); err != nil {
+		return 0, err
+	} else {
+		return r.RowsAffected()
+	}
+}
@@ -0,0 +1,113 @@
+package active
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/jmoiron/sqlx/types"
+)
+
+type (
+	// Raw model whose BeforeSave waits until test lets it proceed
+	gatedModel struct {
+		RawModel
+		entered chan<- struct{}
+		proceed <-chan struct{}
+	}
+)
+
+func (m *gatedModel) BeforeSave(context.Context) error {
+	m.entered <- struct{}{}
+	<-m.proceed
+	return nil
+}
+
+func TestApplyIdempotent(t *testing.T) {
+	at := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name     string
+		recorded int64
+		applied  bool
+	}{
+		{name: "first apply records fingerprint and applies batch", recorded: 1, applied: true},
+		{name: "duplicate apply is no-op", recorded: 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store, mock := newMockStore(t, WithClock(func() time.Time { return at }))
+			mock.ExpectBegin()
+			mock.ExpectExec(`INSERT INTO applied_batches \(fingerprint, created_at\) VALUES \(\$1, \$2\) ON CONFLICT \(fingerprint\) DO NOTHING`).
+				WithArgs("fp1", at).WillReturnResult(sqlmock.NewResult(0, tt.recorded))
+			if tt.applied {
+				mock.ExpectPrepare(`INSERT INTO models`).ExpectExec().WillReturnResult(sqlmock.NewResult(0, 1))
+			}
+			mock.ExpectCommit()
+			e := &Entity{Model: &RawModel{Data: types.JSONText(`{}`)}, Ref: Ref{RowId: "r1", ColumnName: "doc"}}
+			if err := store.ApplyIdempotent(context.Background(), *NewBatch().Add(e), "fp1"); err != nil {
+				t.Fatal(err)
+			} else if stamped := !e.Ref.CreatedAt.IsZero(); stamped != tt.applied {
+				t.Fatalf("expected entity stamped %v, got %+v", tt.applied, e.Ref)
+			}
+		})
+	}
+}
+
+func TestApplyIdempotentConcurrentDuplicate(t *testing.T) {
+	store := NewMemStore()
+	entered := make(chan struct{}, 2)
+	proceed := make(chan struct{})
+	errs := make([]error, 2)
+	var wg sync.WaitGroup
+	for i := range errs {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			e := &Entity{Model: &gatedModel{RawModel: RawModel{Data: types.JSONText(`{}`)}, entered: entered, proceed: proceed}, Ref: Ref{RowId: "r1", ColumnName: "doc"}}
+			errs[i] = store.ApplyIdempotent(context.Background(), *NewBatch().Add(e), "fp1")
+		}(i)
+	}
+	// both applies are within their transactions before either commits
+	<-entered
+	<-entered
+	close(proceed)
+	wg.Wait()
+	for _, err := range errs {
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	if e, err := store.Load(context.Background(), &RawModel{}, "r1", "doc"); err != nil {
+		t.Fatal(err)
+	} else if e.Ref.Version != 0 {
+		t.Fatalf("expected batch applied once, got version %d", e.Ref.Version)
+	}
+}
+
+func TestPurgeFingerprintsJoinsTransaction(t *testing.T) {
+	before := time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC)
+	failure := errors.New("abort")
+	store, mock := newMockStore(t, WithSchemaResolver(func(ctx context.Context) string {
+		tenant, _ := ctx.Value(tenantKey{}).(string)
+		return tenant
+	}))
+	mock.ExpectBegin()
+	mock.ExpectExec(`SET LOCAL search_path TO "t1"`).WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec(`DELETE FROM t1\.applied_batches WHERE created_at < \$1`).WithArgs(before).WillReturnResult(sqlmock.NewResult(0, 3))
+	mock.ExpectRollback()
+	ctx := context.WithValue(context.Background(), tenantKey{}, "t1")
+	err := store.InTx(ctx, func(ctx context.Context) error {
+		if num, err := store.PurgeFingerprints(ctx, before); err != nil {
+			return err
+		} else if num != 3 {
+			t.Errorf("expected 3 purged, got %d", num)
+		}
+		return failure
+	})
+	if !errors.Is(err, failure) {
+		t.Fatalf("expected %v, got %v", failure, err)
+	}
+}
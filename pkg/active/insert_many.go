@@ -0,0 +1,196 @@
+package active
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const (
+	// Bound arguments of single inserted row
+	insertParams = 9
+
+	// Bound arguments of single update or delete
+	updateParams = 9
+	deleteParams = 3
+
+	// Bound arguments of single row of multi-row update
+	updateManyParams = 8
+
+	// Postgres refuses statements with more than 65535 bound arguments
+	DefaultMaxParams = 65535
+)
+
+// Appended to multi-row insert, so rows existing already are told apart instead of failing the statement
+const sqlInsertManySkip = ` ON CONFLICT DO NOTHING RETURNING row_id, column_name`
+
+var (
+	// Single change binds more arguments than database accepts
+	ErrBatchTooLarge = errors.New("model: batch too large")
+)
+
+// Limit bound arguments of single statement, for databases accepting fewer than Postgres.
+// Consecutive adds are split into multi-row inserts fitting the limit, keys of LoadMany and
+// version precheck into queries fitting it
+func WithMaxParams(n int) Option {
+	return func(pg *pg) {
+		if n <= 0 {
+			pg.fail(fmt.Errorf("%w: max params must be positive, got %d", ErrInvalidOption, n))
+		} else {
+			pg.maxParams = n
+		}
+	}
+}
+
+// Check every statement of changes fits parameter limit before anything is sent
+func (pg *pg) checkParams(changes []Change) error {
+	for _, change := range changes {
+		var n int
+		switch change.T {
+		case AddChangeType:
+			n = insertParams
+		case UpdateChangeType:
+			n = updateParams
+		case DeleteChangeType:
+			n = deleteParams
+		}
+		if n > pg.maxParams {
+			return fmt.Errorf("%w: %s of %s binds %d parameters, limit is %d",
+				ErrBatchTooLarge, change.T, change.V.Ref.Key(), n, pg.maxParams)
+		}
+	}
+	return nil
+}
+
+// Split changes into runs applied by single statement: consecutive adds and updates are grouped
+// up to parameter limit, every other change goes alone. Cell repeated within run starts next one,
+// since single statement cannot apply two changes of the same row in order
+func (pg *pg) groupChanges(changes []Change) [][]Change {
+	var groups [][]Change
+	for from := 0; from < len(changes); {
+		to := from + 1
+		maxRows := pg.maxGroupRows(changes[from].T)
+		keys := map[Key]struct{}{changes[from].V.Ref.Key(): {}}
+		for to < len(changes) && to-from < maxRows && changes[to].T == changes[from].T {
+			if _, ok := keys[changes[to].V.Ref.Key()]; ok {
+				break
+			}
+			keys[changes[to].V.Ref.Key()] = struct{}{}
+			to++
+		}
+		groups = append(groups, changes[from:to])
+		from = to
+	}
+	return groups
+}
+
+// Rows of change type single statement may carry
+func (pg *pg) maxGroupRows(t ChangeType) int {
+	switch {
+	case t == AddChangeType && !pg.insertReturning:
+		return pg.maxParams / insertParams
+	// timestamp lock and conflict resolution need to see each row on its own
+	case t == UpdateChangeType && pg.lock == VersionLock && pg.resolver == nil:
+		return pg.maxParams / updateManyParams
+	default:
+		return 1
+	}
+}
+
+// Insert several entities with single multi-row statement. Entities whose rows exist already are
+// duplicates, they fail the whole group with ErrDuplicate and are the ones logged as failed
+func (pg *pg) applyAdds(ctx context.Context, tx execer, changes []Change) (err error) {
+	ctx, span := pg.tracer.Start(ctx, "active.add", trace.WithAttributes(
+		attribute.Int("rows", len(changes))))
+	defer func() {
+		endSpan(span, err)
+	}()
+
+	errs, err := pg.addMany(ctx, tx, changes)
+	pg.logChanges(ctx, changes, errs)
+	return err
+}
+
+// Errors of changes aligned with them, changes past the failed hook have none
+func (pg *pg) addMany(ctx context.Context, tx execer, changes []Change) ([]error, error) {
+	errs := make([]error, 0, len(changes))
+	entities := make([]*Entity, len(changes))
+	for i, change := range changes {
+		if errs = append(errs, beforeChange(ctx, change)); errs[i] != nil {
+			return errs, errs[i]
+		}
+		entities[i] = change.V
+	}
+	now := pg.timestamp()
+	st, err := pg.insertManyStatement(ctx, entities, now)
+	if err != nil {
+		return sameErr(errs, err), err
+	}
+	inserted, err := pg.execInsertMany(ctx, tx, st)
+	if err != nil {
+		err = wrapOpErr(fmt.Sprintf("insert %d rows", len(entities)), err)
+		return sameErr(errs, err), err
+	}
+	var dups []Key
+	for i, entity := range entities {
+		if num, ok := inserted[entity.Ref.Key()]; !ok {
+			errs[i] = wrapErr("insert", entity.Ref, ErrDuplicate)
+			dups = append(dups, entity.Ref.Key())
+		} else if err := pg.expectRows(ctx, entity.Ref, num); err != nil {
+			errs[i] = wrapErr("insert", entity.Ref, err)
+			return errs, fmt.Errorf("insert %d rows: %w", len(entities), errs[i])
+		}
+	}
+	if len(dups) > 0 {
+		return errs, fmt.Errorf("insert %d rows, %d duplicate %v: %w", len(entities), len(dups), dups, ErrDuplicate)
+	}
+	for _, change := range changes {
+		stampInsert(ctx, change.V, now)
+		afterChange(ctx, change)
+	}
+	return errs, nil
+}
+
+// Execute multi-row insert skipping existing rows, reports number of rows inserted per key
+func (pg *pg) execInsertMany(ctx context.Context, tx execer, st PlannedStatement) (map[Key]int64, error) {
+	rows, err := tx.QueryxContext(ctx, st.SQL+sqlInsertManySkip, st.Args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	inserted := map[Key]int64{}
+	for rows.Next() {
+		var key Key
+		if err := rows.Scan(&key.RowId, &key.ColumnName); err != nil {
+			return nil, err
+		}
+		inserted[key]++
+	}
+	return inserted, rows.Err()
+}
+
+func (pg *pg) insertManyStatement(ctx context.Context, entities []*Entity, now time.Time) (PlannedStatement, error) {
+	var (
+		query strings.Builder
+		args  = make([]interface{}, 0, len(entities)*insertParams)
+	)
+	query.WriteString(pg.q(ctx).insertMany)
+	for i, entity := range entities {
+		st, err := pg.insertStatement(ctx, entity, now)
+		if err != nil {
+			return PlannedStatement{}, err
+		}
+		if i > 0 {
+			query.WriteString(", ")
+		}
+		query.WriteString("(?, ?, ?, ?, ?, ?, ?, ?, ?)")
+		args = append(args, st.Args...)
+	}
+	return PlannedStatement{SQL: sqlx.Rebind(pg.dialect.BindType(), query.String()), Args: args}, nil
+}
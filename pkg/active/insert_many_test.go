@@ -0,0 +1,225 @@
+package active
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/jmoiron/sqlx/types"
+)
+
+func TestApplyAdds(t *testing.T) {
+	failure := errors.New("connection reset")
+	tests := []struct {
+		name     string
+		rows     []string
+		inserted []string
+		execErr  error
+		wantErr  error
+		logged   map[string][]error
+	}{
+		{
+			name:     "all rows inserted",
+			rows:     []string{"r1", "r2"},
+			inserted: []string{"r1", "r2"},
+			logged:   map[string][]error{"r1": {nil}, "r2": {nil}},
+		},
+		{
+			name:     "existing row fails alone",
+			rows:     []string{"r1", "r2"},
+			inserted: []string{"r2"},
+			wantErr:  ErrDuplicate,
+			logged:   map[string][]error{"r1": {ErrDuplicate}, "r2": {nil}},
+		},
+		{
+			name:    "statement failure fails every row",
+			rows:    []string{"r1", "r2"},
+			execErr: failure,
+			wantErr: failure,
+			logged:  map[string][]error{"r1": {failure}, "r2": {failure}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			logger := newRecordingLogger()
+			store, mock := newMockStore(t, WithLogger(logger))
+			mock.ExpectBegin()
+			query := mock.ExpectQuery(`INSERT INTO models .* ON CONFLICT DO NOTHING RETURNING row_id, column_name`)
+			if tt.execErr != nil {
+				query.WillReturnError(tt.execErr)
+			} else {
+				rows := sqlmock.NewRows([]string{"row_id", "column_name"})
+				for _, rowId := range tt.inserted {
+					rows.AddRow(rowId, "doc")
+				}
+				query.WillReturnRows(rows)
+			}
+			if tt.wantErr != nil {
+				mock.ExpectRollback()
+			} else {
+				mock.ExpectCommit()
+			}
+
+			batch := NewBatch()
+			for _, rowId := range tt.rows {
+				batch.Add(&Entity{Model: &RawModel{Data: types.JSONText(`{}`)}, Ref: Ref{RowId: rowId, ColumnName: "doc"}})
+			}
+			err := store.ApplyChangesContext(context.Background(), *batch)
+			if !errors.Is(err, tt.wantErr) || tt.wantErr == nil && err != nil {
+				t.Fatalf("expected %v, got %v", tt.wantErr, err)
+			}
+			for rowId, want := range tt.logged {
+				errs := logger.errs[Key{RowId: rowId, ColumnName: "doc"}]
+				if len(errs) != len(want) {
+					t.Fatalf("%s: expected logged %v, got %v", rowId, want, errs)
+				}
+				for i := range want {
+					if !errors.Is(errs[i], want[i]) || want[i] == nil && errs[i] != nil {
+						t.Errorf("%s: expected logged %v, got %v", rowId, want, errs)
+					}
+				}
+			}
+		})
+	}
+}
+
+func TestApplyAddsCap(t *testing.T) {
+	tests := []struct {
+		name      string
+		maxParams int
+		adds      int
+		rows      []int
+	}{
+		{name: "batch within cap", maxParams: DefaultMaxParams, adds: 3, rows: []int{3}},
+		{name: "batch at cap", maxParams: 3 * insertParams, adds: 3, rows: []int{3}},
+		{name: "batch crossing cap", maxParams: 2 * insertParams, adds: 5, rows: []int{2, 2, 1}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store, mock := newMockStore(t, WithMaxParams(tt.maxParams))
+			mock.ExpectBegin()
+			added := 0
+			for _, n := range tt.rows {
+				if n == 1 {
+					mock.ExpectPrepare(`INSERT INTO models`).ExpectExec().WillReturnResult(sqlmock.NewResult(0, 1))
+					added++
+					continue
+				}
+				rows := sqlmock.NewRows([]string{"row_id", "column_name"})
+				for i := 0; i < n; i++ {
+					rows.AddRow(fmt.Sprintf("r%d", added), "doc")
+					added++
+				}
+				mock.ExpectQuery(fmt.Sprintf(`INSERT INTO models .* VALUES (\(.*\), ){%d}\(.*\) ON CONFLICT DO NOTHING`, n-1)).WillReturnRows(rows)
+			}
+			mock.ExpectCommit()
+			if err := store.ApplyChangesContext(context.Background(), addBatch(tt.adds)); err != nil {
+				t.Fatal(err)
+			}
+		})
+	}
+}
+
+func TestGroupChanges(t *testing.T) {
+	change := func(ct ChangeType, rowId string) Change {
+		return Change{T: ct, V: &Entity{Model: &RawModel{}, Ref: Ref{RowId: rowId, ColumnName: "doc", Version: 1}}}
+	}
+	tests := []struct {
+		name    string
+		changes []Change
+		groups  []int
+	}{
+		{
+			name:    "consecutive changes of type are grouped",
+			changes: []Change{change(AddChangeType, "r1"), change(AddChangeType, "r2"), change(UpdateChangeType, "r3"), change(UpdateChangeType, "r4")},
+			groups:  []int{2, 2},
+		},
+		{
+			name:    "repeated add starts next group",
+			changes: []Change{change(AddChangeType, "r1"), change(AddChangeType, "r2"), change(AddChangeType, "r1"), change(AddChangeType, "r3")},
+			groups:  []int{2, 2},
+		},
+		{
+			name:    "repeated update starts next group",
+			changes: []Change{change(UpdateChangeType, "r1"), change(UpdateChangeType, "r1"), change(UpdateChangeType, "r2")},
+			groups:  []int{1, 2},
+		},
+		{
+			name:    "deletes go alone",
+			changes: []Change{change(DeleteChangeType, "r1"), change(DeleteChangeType, "r2")},
+			groups:  []int{1, 1},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store := New(nil).(*pg)
+			groups := store.groupChanges(tt.changes)
+			sizes := make([]int, len(groups))
+			for i, group := range groups {
+				sizes[i] = len(group)
+			}
+			if fmt.Sprint(sizes) != fmt.Sprint(tt.groups) {
+				t.Fatalf("expected groups %v, got %v", tt.groups, sizes)
+			}
+		})
+	}
+}
+
+func BenchmarkApplyAdds(b *testing.B) {
+	const adds = 100
+	benchmarks := []struct {
+		name      string
+		maxParams int
+		expect    func(mock sqlmock.Sqlmock)
+	}{
+		{
+			name:      "multi-row",
+			maxParams: DefaultMaxParams,
+			expect: func(mock sqlmock.Sqlmock) {
+				rows := sqlmock.NewRows([]string{"row_id", "column_name"})
+				for i := 0; i < adds; i++ {
+					rows.AddRow(fmt.Sprintf("r%d", i), "doc")
+				}
+				mock.ExpectQuery(`INSERT INTO models`).WillReturnRows(rows)
+			},
+		},
+		{
+			name:      "row by row",
+			maxParams: insertParams,
+			expect: func(mock sqlmock.Sqlmock) {
+				stmt := mock.ExpectPrepare(`INSERT INTO models`)
+				for i := 0; i < adds; i++ {
+					stmt.ExpectExec().WillReturnResult(sqlmock.NewResult(0, 1))
+				}
+			},
+		},
+	}
+	for _, bm := range benchmarks {
+		b.Run(bm.name, func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				b.StopTimer()
+				// fresh mock per run, so matching does not scan expectations of earlier runs
+				store, mock := newMockStore(b, WithMaxParams(bm.maxParams))
+				mock.ExpectBegin()
+				bm.expect(mock)
+				mock.ExpectCommit()
+				batch := addBatch(adds)
+				b.StartTimer()
+				if err := store.ApplyChangesContext(context.Background(), batch); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+// Batch adding n raw cells r0..rn-1
+func addBatch(n int) Batch {
+	batch := NewBatch()
+	for i := 0; i < n; i++ {
+		batch.Add(&Entity{Model: &RawModel{Data: types.JSONText(`{}`)}, Ref: Ref{RowId: fmt.Sprintf("r%d", i), ColumnName: "doc"}})
+	}
+	return *batch
+}
@@ -0,0 +1,46 @@
+package active
+
+import (
+	"context"
+	"database/sql/driver"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/jmoiron/sqlx/types"
+)
+
+func TestInsertArgs(t *testing.T) {
+	at := time.Date(2024, 3, 1, 12, 30, 0, 123456789, time.FixedZone("CET", 3600))
+	stamped := at.UTC().Truncate(time.Microsecond)
+	tests := []struct {
+		name string
+		e    *Entity
+		args []driver.Value
+	}{
+		{
+			name: "columns in statement order",
+			e:    &Entity{Model: &RawModel{Data: types.JSONText(`{"a":1}`)}, Ref: Ref{RowId: "r1", ColumnName: "doc"}},
+			args: []driver.Value{"r1", "doc", 0, []byte(`{"a":1}`), JSONFormat, false, nil, stamped, stamped},
+		},
+		{
+			name: "column name defaults to model",
+			e:    &Entity{Model: &RawModel{Data: types.JSONText(`{}`)}, Ref: Ref{RowId: "r2"}},
+			args: []driver.Value{"r2", "RawModel", 0, []byte(`{}`), JSONFormat, false, nil, stamped, stamped},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store, mock := newMockStore(t, WithClock(func() time.Time { return at }))
+			mock.ExpectBegin()
+			mock.ExpectPrepare(`INSERT INTO models \(row_id, column_name, version, data, format, compressed, key_id, created_at, updated_at\) VALUES \(\$1, \$2, \$3, \$4, \$5, \$6, \$7, \$8, \$9\)`).
+				ExpectExec().WithArgs(tt.args...).WillReturnResult(sqlmock.NewResult(0, 1))
+			mock.ExpectCommit()
+			if err := store.Save(context.Background(), tt.e); err != nil {
+				t.Fatal(err)
+			} else if !tt.e.Ref.CreatedAt.Equal(stamped) || !tt.e.Ref.UpdatedAt.Equal(tt.e.Ref.CreatedAt) {
+				t.Fatalf("expected fresh entity stamped created and updated at %v, got %+v", stamped, tt.e.Ref)
+			}
+		})
+	}
+}
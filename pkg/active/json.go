@@ -0,0 +1,28 @@
+package active
+
+import "encoding/json"
+
+type (
+	// JSON implementation of documents store builds itself
+	jsonFuncs struct {
+		marshal   func(any) ([]byte, error)
+		unmarshal func([]byte, any) error
+	}
+)
+
+var (
+	stdJSON = jsonFuncs{marshal: json.Marshal, unmarshal: json.Unmarshal}
+)
+
+// Use given JSON implementation, e.g. json-iterator or segmentio/encoding, for action log data,
+// JSON lookups and patches. Model data is still produced by Marshall/Unmarshall of models
+func WithJSONCodec(marshal func(any) ([]byte, error), unmarshal func([]byte, any) error) Option {
+	return func(p *pg) {
+		if marshal != nil {
+			p.json.marshal = marshal
+		}
+		if unmarshal != nil {
+			p.json.unmarshal = unmarshal
+		}
+	}
+}
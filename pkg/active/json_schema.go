@@ -0,0 +1,172 @@
+package active
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/santhosh-tekuri/jsonschema/v5"
+)
+
+type (
+	// Compiles JSON Schema documents, lets full featured schema library replace built in one
+	SchemaCompiler interface {
+		Compile(schema []byte) (SchemaValidator, error)
+	}
+
+	// Compiled schema checking JSON documents
+	SchemaValidator interface {
+		// Violations of doc, none when it is valid
+		Validate(doc []byte) []Violation
+	}
+
+	// Single schema violation, Path is JSON pointer of offending value, empty for document itself
+	Violation struct {
+		Path    string
+		Message string
+	}
+
+	// Data of cell does not conform to schema registered for its column
+	SchemaError struct {
+		Key        Key
+		Violations []Violation
+	}
+
+	// Compiler of JSON Schema drafts 4 to 2020-12, schema without $schema is read as draft 2020-12.
+	// References to schemas outside of the document are refused
+	draftCompiler struct{}
+
+	draftValidator struct {
+		schema *jsonschema.Schema
+	}
+)
+
+var (
+	// Compiler used unless WithSchemaCompiler is given
+	DefaultSchemaCompiler SchemaCompiler = draftCompiler{}
+)
+
+// Validate data of cells stored under columnName against JSON Schema before they are added or updated,
+// failing the batch with SchemaError. Invalid schema is reported as ErrInvalidOption
+func WithSchema(columnName string, schema []byte) Option {
+	return func(p *pg) {
+		if p.rawSchemas == nil {
+			p.rawSchemas = map[string][]byte{}
+		}
+		p.rawSchemas[columnName] = schema
+	}
+}
+
+// Compile schemas of WithSchema with c instead of DefaultSchemaCompiler
+func WithSchemaCompiler(c SchemaCompiler) Option {
+	return func(p *pg) {
+		if c != nil {
+			p.schemaCompiler = c
+		}
+	}
+}
+
+func (e *SchemaError) Error() string {
+	msgs := make([]string, len(e.Violations))
+	for i, v := range e.Violations {
+		msgs[i] = v.String()
+	}
+	return fmt.Sprintf("model: %s violates schema: %s", e.Key, strings.Join(msgs, "; "))
+}
+
+func (v Violation) String() string {
+	if v.Path == "" {
+		return v.Message
+	}
+	return v.Path + ": " + v.Message
+}
+
+// Compile registered schemas once options are known
+func (pg *pg) compileSchemas() {
+	if len(pg.rawSchemas) == 0 {
+		return
+	}
+	pg.schemas = make(map[string]SchemaValidator, len(pg.rawSchemas))
+	for col, raw := range pg.rawSchemas {
+		if v, err := pg.schemaCompiler.Compile(raw); err != nil {
+			pg.fail(fmt.Errorf("%w: schema of column %q: %w", ErrInvalidOption, col, err))
+		} else {
+			pg.schemas[col] = v
+		}
+	}
+}
+
+// Check data of added and updated entities against schemas of their columns before anything is sent
+func (pg *pg) checkSchemas(changes []Change) error {
+	if len(pg.schemas) == 0 {
+		return nil
+	}
+	for _, change := range changes {
+		if change.T == DeleteChangeType {
+			continue
+		}
+		v, ok := pg.schemas[change.V.Ref.ColumnName]
+		if !ok {
+			continue
+		}
+		item := change.V.Model.Marshall()
+		if item.E != nil {
+			return item.E
+		}
+		doc := item.V
+		if len(bytes.TrimSpace(doc)) == 0 {
+			doc = []byte(pg.emptyJSON)
+		}
+		if violations := v.Validate(doc); len(violations) > 0 {
+			return &SchemaError{Key: change.V.Ref.Key(), Violations: violations}
+		}
+	}
+	return nil
+}
+
+func (draftCompiler) Compile(schema []byte) (SchemaValidator, error) {
+	const url = "schema.json"
+	c := jsonschema.NewCompiler()
+	c.Draft = jsonschema.Draft2020
+	c.LoadURL = func(s string) (io.ReadCloser, error) {
+		return nil, fmt.Errorf("external schema %s is not allowed", s)
+	}
+	if err := c.AddResource(url, bytes.NewReader(schema)); err != nil {
+		return nil, err
+	}
+	s, err := c.Compile(url)
+	if err != nil {
+		return nil, err
+	}
+	return draftValidator{schema: s}, nil
+}
+
+func (v draftValidator) Validate(doc []byte) []Violation {
+	var val any
+	dec := json.NewDecoder(bytes.NewReader(doc))
+	dec.UseNumber()
+	if err := dec.Decode(&val); err != nil {
+		return []Violation{{Message: "invalid JSON: " + err.Error()}}
+	}
+	var ve *jsonschema.ValidationError
+	if err := v.schema.Validate(val); err == nil {
+		return nil
+	} else if !errors.As(err, &ve) {
+		return []Violation{{Message: err.Error()}}
+	}
+	return violations(ve, nil)
+}
+
+// Leaf errors of validation, the ones naming failed keyword
+func violations(ve *jsonschema.ValidationError, out []Violation) []Violation {
+	if len(ve.Causes) == 0 {
+		return append(out, Violation{Path: ve.InstanceLocation, Message: ve.Message})
+	}
+	for _, cause := range ve.Causes {
+		out = violations(cause, out)
+	}
+	return out
+}
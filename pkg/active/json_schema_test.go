@@ -0,0 +1,77 @@
+package active
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/jmoiron/sqlx/types"
+)
+
+func TestDraftCompiler(t *testing.T) {
+	schema := []byte(`{
+		"type": "object",
+		"required": ["email"],
+		"properties": {
+			"email": {"type": "string", "minLength": 3},
+			"tags": {"type": "array", "uniqueItems": true}
+		},
+		"oneOf": [{"required": ["user"]}, {"required": ["team"]}]
+	}`)
+	tests := []struct {
+		name  string
+		doc   string
+		paths []string
+	}{
+		{name: "valid", doc: `{"email": "a@b", "user": 1}`},
+		{name: "wrong type", doc: `{"email": 1, "user": 1}`, paths: []string{"/email"}},
+		{name: "keyword beyond former subset", doc: `{"email": "a@b", "user": 1, "tags": [1, 1]}`, paths: []string{"/tags"}},
+		{name: "composition", doc: `{"email": "a@b"}`, paths: []string{"", ""}},
+		{name: "invalid JSON", doc: `{`, paths: []string{""}},
+	}
+	v, err := DefaultSchemaCompiler.Compile(schema)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := v.Validate([]byte(tt.doc))
+			if len(got) != len(tt.paths) {
+				t.Fatalf("expected %d violations, got %v", len(tt.paths), got)
+			}
+			for i, path := range tt.paths {
+				if got[i].Path != path {
+					t.Errorf("expected violation at %q, got %v", path, got[i])
+				}
+			}
+		})
+	}
+}
+
+func TestSchemaOption(t *testing.T) {
+	tests := []struct {
+		name    string
+		schema  string
+		wantErr error
+	}{
+		{name: "valid schema", schema: `{"type": "object"}`},
+		{name: "invalid schema", schema: `{"type": 12}`, wantErr: ErrInvalidOption},
+		{name: "malformed schema", schema: `{`, wantErr: ErrInvalidOption},
+		{name: "external reference", schema: `{"$ref": "https://example.com/schema.json"}`, wantErr: ErrInvalidOption},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store, _ := newMockStore(t, WithSchema("doc", []byte(tt.schema)))
+			if tt.wantErr == nil {
+				return
+			}
+			if err := store.Ping(context.Background()); !errors.Is(err, tt.wantErr) {
+				t.Fatalf("expected %v from Ping, got %v", tt.wantErr, err)
+			}
+			batch := NewBatch().Add(&Entity{Model: &RawModel{Data: types.JSONText(`{}`)}, Ref: Ref{RowId: "r1", ColumnName: "doc"}})
+			if err := store.ApplyChangesContext(context.Background(), *batch); !errors.Is(err, tt.wantErr) {
+				t.Fatalf("expected %v from apply, got %v", tt.wantErr, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,129 @@
+package active
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+)
+
+type (
+	// Direction of listing by (created_at, row_id)
+	ListOrder int
+
+	ListOptions struct {
+		// Page size, non positive means DefaultListLimit
+		Limit int
+
+		// Opaque position returned by previous page, empty starts from the beginning
+		Cursor string
+
+		Order ListOrder
+
+		// Provides model for each listed row, rows are listed as RawModel when nil
+		Factory func() Model
+	}
+)
+
+const (
+	Ascending = ListOrder(iota)
+	Descending
+)
+
+const (
+	DefaultListLimit = 100
+)
+
+var (
+	ErrInvalidCursor = errors.New("model: invalid cursor")
+)
+
+// Page of not deleted entities of column in keyset order over (created_at, row_id), so deep pages
+// cost the same as first one. Next cursor is empty once the last page is returned.
+// Store WithLenientDecode returns page with DecodeError of rows failing to unmarshall
+func (pg *pg) List(ctx context.Context, columnName string, opts ListOptions) ([]*Entity, string, error) {
+	ctx, err := pg.enter(ctx)
+	if err != nil {
+		return nil, "", err
+	}
+	ctx, cancel := pg.withDefaultTimeout(ctx)
+	defer cancel()
+	if opts.Limit <= 0 {
+		opts.Limit = DefaultListLimit
+	}
+	query, args, err := pg.listQuery(ctx, columnName, opts)
+	if err != nil {
+		return nil, "", err
+	}
+	var cells []cell
+	if err := sqlx.SelectContext(ctx, pg.queryer(ctx), &cells, query, args...); err != nil {
+		return nil, "", err
+	}
+
+	arr := make([]*Entity, 0, len(cells))
+	dec := pg.decodeCollector()
+	for _, aCell := range cells {
+		m := opts.newModel()
+		if err := pg.decode(aCell, m); err != nil {
+			if err := dec.fail(aCell.toRef().Key(), err); err != nil {
+				return nil, "", err
+			}
+			continue
+		}
+		arr = append(arr, &Entity{Model: m, Ref: aCell.toRef()})
+	}
+	if len(cells) < opts.Limit {
+		return arr, "", dec.err()
+	}
+	// cursor follows fetched rows, so page after one with broken rows goes on past them
+	last := cells[len(cells)-1]
+	return arr, encodeCursor(last.CreatedAt, last.RowId), dec.err()
+}
+
+func (pg *pg) listQuery(ctx context.Context, columnName string, opts ListOptions) (string, []interface{}, error) {
+	if opts.Cursor == "" {
+		if opts.Order == Descending {
+			return pg.q(ctx).listDesc, []interface{}{columnName, opts.Limit}, nil
+		}
+		return pg.q(ctx).listAsc, []interface{}{columnName, opts.Limit}, nil
+	}
+	createdAt, rowId, err := decodeCursor(opts.Cursor)
+	if err != nil {
+		return "", nil, err
+	}
+	if opts.Order == Descending {
+		return pg.q(ctx).listBefore, []interface{}{columnName, createdAt, rowId, opts.Limit}, nil
+	}
+	return pg.q(ctx).listAfter, []interface{}{columnName, createdAt, rowId, opts.Limit}, nil
+}
+
+func (o ListOptions) newModel() Model {
+	if o.Factory == nil {
+		return &RawModel{}
+	}
+	return o.Factory()
+}
+
+func encodeCursor(createdAt time.Time, rowId string) string {
+	return base64.RawURLEncoding.EncodeToString([]byte(createdAt.UTC().Format(time.RFC3339Nano) + "," + rowId))
+}
+
+func decodeCursor(cursor string) (time.Time, string, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return time.Time{}, "", ErrInvalidCursor
+	}
+	// timestamp holds no comma, while row id may
+	at, rowId, ok := strings.Cut(string(raw), ",")
+	if !ok {
+		return time.Time{}, "", ErrInvalidCursor
+	}
+	createdAt, err := time.Parse(time.RFC3339Nano, at)
+	if err != nil {
+		return time.Time{}, "", ErrInvalidCursor
+	}
+	return createdAt, rowId, nil
+}
@@ -0,0 +1,36 @@
+package active
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+func TestListFactory(t *testing.T) {
+	cols := []string{"row_id", "column_name", "version", "data", "format", "compressed", "key_id", "created_at", "updated_at"}
+	at := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name    string
+		factory func() Model
+	}{
+		{name: "nil factory lists raw models"},
+		{name: "factory provides models", factory: func() Model { return &RawModel{} }},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store, mock := newMockStore(t)
+			mock.ExpectQuery(`SELECT .* FROM models\s+WHERE column_name = \$1`).WithArgs("doc", DefaultListLimit).
+				WillReturnRows(sqlmock.NewRows(cols).AddRow("r1", "doc", 0, []byte(`{"a":1}`), nil, false, nil, at, at))
+			arr, next, err := store.List(context.Background(), "doc", ListOptions{Factory: tt.factory})
+			if err != nil {
+				t.Fatal(err)
+			} else if next != "" || len(arr) != 1 {
+				t.Fatalf("expected single last page, got %d entities and cursor %q", len(arr), next)
+			} else if m, ok := arr[0].Model.(*RawModel); !ok || string(m.Data) != `{"a":1}` {
+				t.Fatalf("expected raw model, got %#v", arr[0].Model)
+			}
+		})
+	}
+}
@@ -0,0 +1,87 @@
+package active
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/jmoiron/sqlx"
+)
+
+const (
+	// Bound arguments of single key of multi-key select
+	keyParams = 2
+)
+
+// Load stored models of many keys with as few queries as possible, factory provides model for each found key.
+// Missing and soft deleted keys are simply omitted from result. Store WithLenientDecode returns
+// entities loaded fine together with DecodeError of the rest
+func (pg *pg) LoadMany(ctx context.Context, keys []Key, factory func(Key) Model) (map[Key]*Entity, error) {
+	ctx, err := pg.enter(ctx)
+	if err != nil {
+		return nil, err
+	}
+	chunks, err := pg.keyChunks(keys)
+	if err != nil {
+		return nil, err
+	}
+	ctx, cancel := pg.withDefaultTimeout(ctx)
+	defer cancel()
+	res := make(map[Key]*Entity, len(keys))
+	dec := pg.decodeCollector()
+	for _, chunk := range chunks {
+		var cells []cell
+		query, args := pg.keysQuery(pg.q(ctx).loadMany, chunk)
+		if err := sqlx.SelectContext(ctx, pg.queryer(ctx), &cells, query, args...); err != nil {
+			return nil, err
+		}
+		for _, aCell := range cells {
+			key := aCell.toRef().Key()
+			m := factory(key)
+			if err := pg.decode(aCell, m); err != nil {
+				if err := dec.fail(key, err); err != nil {
+					return nil, err
+				}
+				continue
+			}
+			res[key] = &Entity{Model: m, Ref: aCell.toRef()}
+		}
+	}
+	return res, dec.err()
+}
+
+// Split keys into chunks selected by single query within parameter limit
+func (pg *pg) keyChunks(keys []Key) ([][]Key, error) {
+	size := pg.maxParams / keyParams
+	if size == 0 {
+		return nil, fmt.Errorf("%w: key lookup binds %d parameters, limit is %d", ErrBatchTooLarge, keyParams, pg.maxParams)
+	}
+	var chunks [][]Key
+	for from := 0; from < len(keys); from += size {
+		to := from + size
+		if to > len(keys) {
+			to = len(keys)
+		}
+		chunks = append(chunks, keys[from:to])
+	}
+	return chunks, nil
+}
+
+// Query selecting cells of keys, prefix ends with IN
+func (pg *pg) keysQuery(prefix string, keys []Key) (string, []interface{}) {
+	var (
+		query strings.Builder
+		args  = make([]interface{}, 0, len(keys)*2)
+	)
+	query.WriteString(prefix)
+	query.WriteString("(")
+	for i, key := range keys {
+		if i > 0 {
+			query.WriteString(", ")
+		}
+		query.WriteString("(?, ?)")
+		args = append(args, key.RowId, key.ColumnName)
+	}
+	query.WriteString(")")
+	return sqlx.Rebind(pg.dialect.BindType(), query.String()), args
+}
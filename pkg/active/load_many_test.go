@@ -0,0 +1,56 @@
+package active
+
+import (
+	"context"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+func TestLoadManyChunks(t *testing.T) {
+	cols := []string{"row_id", "column_name", "version", "data", "format", "compressed", "key_id", "created_at", "updated_at"}
+	at := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name      string
+		maxParams int
+		keys      int
+		chunks    []int
+		wantErr   error
+	}{
+		{name: "keys within limit", maxParams: DefaultMaxParams, keys: 5, chunks: []int{5}},
+		{name: "keys split by limit", maxParams: 4, keys: 5, chunks: []int{2, 2, 1}},
+		{name: "odd limit rounds down", maxParams: 5, keys: 4, chunks: []int{2, 2}},
+		{name: "limit below single key", maxParams: 1, keys: 1, wantErr: ErrBatchTooLarge},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store, mock := newMockStore(t, WithMaxParams(tt.maxParams))
+			keys := make([]Key, tt.keys)
+			for i := range keys {
+				keys[i] = Key{RowId: fmt.Sprintf("r%d", i), ColumnName: "doc"}
+			}
+			from := 0
+			for _, size := range tt.chunks {
+				rows := sqlmock.NewRows(cols)
+				args := make([]driver.Value, 0, size*keyParams)
+				for _, key := range keys[from : from+size] {
+					rows.AddRow(key.RowId, key.ColumnName, 0, []byte(`{}`), nil, false, nil, at, at)
+					args = append(args, key.RowId, key.ColumnName)
+				}
+				mock.ExpectQuery(`SELECT .* FROM models WHERE deleted_at IS NULL AND \(row_id, column_name\) IN`).
+					WithArgs(args...).WillReturnRows(rows)
+				from += size
+			}
+			res, err := store.LoadMany(context.Background(), keys, func(Key) Model { return &RawModel{} })
+			if !errors.Is(err, tt.wantErr) || tt.wantErr == nil && err != nil {
+				t.Fatalf("expected %v, got %v", tt.wantErr, err)
+			} else if tt.wantErr == nil && len(res) != len(keys) {
+				t.Fatalf("expected %d entities, got %d", len(keys), len(res))
+			}
+		})
+	}
+}
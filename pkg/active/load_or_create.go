@@ -0,0 +1,45 @@
+package active
+
+import (
+	"context"
+	"errors"
+)
+
+type (
+	// Inserts of context skip stored cells, reporting them as ErrDuplicate without failing transaction
+	absentKey struct{}
+)
+
+// Load model by row and column, when missing insert model made by init instead, in single transaction.
+// Insert is regular write, validated and passed to hooks and change logger. Concurrent creator winning
+// the race is detected by conflict and its entity is loaded into m.
+// Soft deleted cell is neither loaded nor replaced and reported as ErrNotFound
+func (pg *pg) LoadOrCreate(ctx context.Context, m Model, rowId, columnName string, init func() Model) (e *Entity, created bool, err error) {
+	columnName = columnOf(m, columnName)
+	if err := validateRef(Ref{RowId: rowId, ColumnName: columnName}, AddChangeType); err != nil {
+		return nil, false, err
+	}
+	err = pg.InTx(ctx, func(ctx context.Context) error {
+		if e, err = pg.Load(ctx, m, rowId, columnName); !errors.Is(err, ErrNotFound) {
+			return err
+		}
+		fresh := &Entity{Model: init(), Ref: Ref{RowId: rowId, ColumnName: columnName}}
+		if err := pg.Save(context.WithValue(ctx, absentKey{}, true), fresh); errors.Is(err, ErrDuplicate) {
+			e, err = pg.Load(ctx, m, rowId, columnName)
+			return err
+		} else if err != nil {
+			return err
+		}
+		e, created = fresh, true
+		return nil
+	})
+	if err != nil {
+		return nil, false, err
+	}
+	return e, created, nil
+}
+
+func insertingAbsent(ctx context.Context) bool {
+	absent, _ := ctx.Value(absentKey{}).(bool)
+	return absent
+}
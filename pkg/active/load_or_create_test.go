@@ -0,0 +1,103 @@
+package active
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/jmoiron/sqlx/types"
+)
+
+type (
+	// Raw model counting BeforeSave calls
+	hookedModel struct {
+		RawModel
+		saved int
+	}
+)
+
+func (m *hookedModel) BeforeSave(context.Context) error {
+	m.saved++
+	return nil
+}
+
+func TestLoadOrCreate(t *testing.T) {
+	cols := []string{"row_id", "column_name", "version", "data", "format", "compressed", "key_id", "created_at", "updated_at"}
+	stored := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name        string
+		inserted    int64
+		wantCreated bool
+		wantData    string
+		wantSaved   int
+		logged      []error
+	}{
+		{
+			name:        "missing cell is created through regular write",
+			inserted:    1,
+			wantCreated: true,
+			wantData:    `{"fresh":true}`,
+			wantSaved:   1,
+			logged:      []error{nil},
+		},
+		{
+			name:      "cell created concurrently is loaded",
+			inserted:  0,
+			wantData:  `{"winner":true}`,
+			wantSaved: 1,
+			logged:    []error{ErrDuplicate},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			logger := newRecordingLogger()
+			store, mock := newMockStore(t, WithLogger(logger))
+			mock.ExpectBegin()
+			mock.ExpectQuery(`SELECT .* FROM models WHERE row_id = \$1`).WillReturnRows(sqlmock.NewRows(cols))
+			mock.ExpectExec(`SAVEPOINT active_sp_1`).WillReturnResult(sqlmock.NewResult(0, 0))
+			mock.ExpectPrepare(`(?s)INSERT INTO models .* ON CONFLICT \(row_id, column_name\) DO NOTHING`).
+				ExpectExec().WillReturnResult(sqlmock.NewResult(0, tt.inserted))
+			if tt.inserted == 1 {
+				mock.ExpectExec(`RELEASE SAVEPOINT active_sp_1`).WillReturnResult(sqlmock.NewResult(0, 0))
+			} else {
+				mock.ExpectExec(`ROLLBACK TO SAVEPOINT active_sp_1`).WillReturnResult(sqlmock.NewResult(0, 0))
+				mock.ExpectQuery(`SELECT .* FROM models WHERE row_id = \$1`).WillReturnRows(sqlmock.NewRows(cols).
+					AddRow("r1", "doc", 0, []byte(`{"winner":true}`), nil, false, nil, stored, stored))
+			}
+			mock.ExpectCommit()
+
+			fresh := &hookedModel{RawModel: RawModel{Data: types.JSONText(`{"fresh":true}`)}}
+			e, created, err := store.LoadOrCreate(context.Background(), &RawModel{}, "r1", "doc", func() Model { return fresh })
+			if err != nil {
+				t.Fatal(err)
+			} else if created != tt.wantCreated {
+				t.Fatalf("expected created %v, got %v", tt.wantCreated, created)
+			} else if data := rawData(e.Model); data != tt.wantData {
+				t.Fatalf("expected %s, got %s", tt.wantData, data)
+			} else if fresh.saved != tt.wantSaved {
+				t.Fatalf("expected %d BeforeSave calls, got %d", tt.wantSaved, fresh.saved)
+			}
+			errs := logger.errs[Key{RowId: "r1", ColumnName: "doc"}]
+			if len(errs) != len(tt.logged) {
+				t.Fatalf("expected %d logged changes, got %v", len(tt.logged), errs)
+			}
+			for i, want := range tt.logged {
+				if !errors.Is(errs[i], want) || want == nil && errs[i] != nil {
+					t.Fatalf("expected change logged with %v, got %v", want, errs[i])
+				}
+			}
+		})
+	}
+}
+
+func rawData(m Model) string {
+	switch m := m.(type) {
+	case *hookedModel:
+		return string(m.Data)
+	case *RawModel:
+		return string(m.Data)
+	}
+	return ""
+}
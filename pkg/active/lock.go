@@ -0,0 +1,29 @@
+package active
+
+import "time"
+
+type (
+	// How update detects that cell changed since entity was loaded
+	LockStrategy int
+)
+
+const (
+	// Match stored version, default
+	VersionLock = LockStrategy(iota)
+
+	// Match stored updated_at, for schemas where timestamp is the lock. Version is still incremented
+	// by database, archived history keeps matching by version
+	TimestampLock
+)
+
+// Detect concurrent updates with given strategy
+func WithLockStrategy(s LockStrategy) Option {
+	return func(p *pg) {
+		p.lock = s
+	}
+}
+
+// Current time as stored by Postgres, which keeps microseconds, so Ref timestamps compare equal after load
+func (pg *pg) timestamp() time.Time {
+	return pg.now().UTC().Truncate(time.Microsecond)
+}
@@ -0,0 +1,50 @@
+package active
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/jmoiron/sqlx/types"
+)
+
+func TestTimestampLock(t *testing.T) {
+	loaded := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	now := loaded.Add(time.Minute)
+	failure := errors.New("connection reset")
+	tests := []struct {
+		name        string
+		affected    int64
+		commitErr   error
+		wantErr     error
+		wantUpdated time.Time
+	}{
+		{name: "committed update takes new token", affected: 1, wantUpdated: now},
+		{name: "stale token fails and is kept", affected: 0, wantErr: ErrOptimisticLock, wantUpdated: loaded},
+		{name: "failed commit keeps token", affected: 1, commitErr: failure, wantErr: failure, wantUpdated: loaded},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store, mock := newMockStore(t, WithLockStrategy(TimestampLock), WithClock(func() time.Time { return now }))
+			mock.ExpectBegin()
+			mock.ExpectPrepare(`UPDATE models .* WHERE row_id = \$6 AND column_name = \$7 AND updated_at = \$8`).ExpectExec().
+				WithArgs([]byte(`{}`), JSONFormat, false, nil, now, "r1", "doc", loaded).
+				WillReturnResult(sqlmock.NewResult(0, tt.affected))
+			if tt.affected == 0 {
+				mock.ExpectRollback()
+			} else {
+				mock.ExpectCommit().WillReturnError(tt.commitErr)
+			}
+			e := &Entity{Model: &RawModel{Data: types.JSONText(`{}`)}, Ref: Ref{RowId: "r1", ColumnName: "doc", CreatedAt: loaded, UpdatedAt: loaded}}
+			batch := NewBatch()
+			batch.Update(e)
+			if err := store.ApplyChangesContext(context.Background(), *batch); !errors.Is(err, tt.wantErr) || tt.wantErr == nil && err != nil {
+				t.Fatalf("expected %v, got %v", tt.wantErr, err)
+			} else if !e.Ref.UpdatedAt.Equal(tt.wantUpdated) {
+				t.Fatalf("expected updated at %v, got %v", tt.wantUpdated, e.Ref.UpdatedAt)
+			}
+		})
+	}
+}
@@ -0,0 +1,1425 @@
+package active
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jmoiron/sqlx/types"
+)
+
+type (
+	// Cell kept by memory store
+	memCell struct {
+		ref     Ref
+		data    types.JSONText
+		deleted bool
+	}
+
+	// Action log row kept by memory store
+	memAction struct {
+		record  ActionRecord
+		key     string
+		changes []Change
+	}
+
+	// Whole state of memory store. Committed state is never modified, every commit replaces it
+	// with modified copy, so transactions can keep state they started from
+	memState struct {
+		cells        map[Key]memCell
+		history      map[Key][]VersionRecord
+		actions      []memAction
+		fingerprints map[string]time.Time
+	}
+
+	// Transaction of memory store working on its own copy of state committed when it started.
+	// Cells it changed are checked against committed state on commit, so transactions writing
+	// the same cell conflict like row versions do in Postgres
+	memTx struct {
+		m         *memStore
+		base      memState
+		state     memState
+		locked    map[Key]memBase
+		truncated bool
+	}
+
+	// Committed cell transaction continues from, ok is false for missing cell
+	memBase struct {
+		cell memCell
+		ok   bool
+	}
+
+	// Store keeping JSON data of models in memory, meant for unit tests of code using Store.
+	// Transactions run concurrently on snapshots and are rolled back on failure, versions, optimistic
+	// locking, soft deletes and errors follow Postgres store. Codecs, compression and encryption do not apply
+	memStore struct {
+		mu         sync.Mutex
+		unlocked   *sync.Cond
+		state      memState
+		locks      map[Key]*memTx
+		running    sync.WaitGroup
+		now        func() time.Time
+		newID      func() (uuid.UUID, error)
+		versioning bool
+		err        error
+		closed     bool
+	}
+
+	// Context key of transaction of memory store
+	memTxKey struct{}
+
+	// Tx and ReadStore joining transaction of memory store
+	memScope struct {
+		m     *memStore
+		tx    *memTx
+		hooks *commitHooks
+	}
+
+	// Source iterating cells collected in advance
+	memSource struct {
+		cells   []memCell
+		factory func() Model
+	}
+)
+
+var (
+	_ Store = (*memStore)(nil)
+
+	// Another transaction committed cell first
+	errMemConflict = fmt.Errorf("%w: concurrent transaction committed first", ErrOptimisticLock)
+
+	// Another transaction recorded fingerprint first
+	errFingerprintTaken = errors.New("model: fingerprint recorded by concurrent transaction")
+)
+
+// Create empty in-memory store. Of Postgres store options WithClock, WithUUIDVersion and WithVersioning
+// apply, others are ignored
+func NewMemStore(opts ...Option) Store {
+	p := newPg(nil, Postgres)
+	for _, opt := range opts {
+		opt(p)
+	}
+	m := &memStore{
+		state:      newMemState(),
+		locks:      map[Key]*memTx{},
+		now:        p.now,
+		newID:      p.newID,
+		versioning: p.versioning,
+		err:        p.err,
+	}
+	m.unlocked = sync.NewCond(&m.mu)
+	return m
+}
+
+func newMemState() memState {
+	return memState{
+		cells:        map[Key]memCell{},
+		history:      map[Key][]VersionRecord{},
+		fingerprints: map[string]time.Time{},
+	}
+}
+
+// Copy of state, slices are capped so appending to copy never writes into the original
+func (s memState) clone() memState {
+	c := newMemState()
+	for k, v := range s.cells {
+		c.cells[k] = v
+	}
+	for k, v := range s.history {
+		c.history[k] = v[:len(v):len(v)]
+	}
+	for k, v := range s.fingerprints {
+		c.fingerprints[k] = v
+	}
+	c.actions = s.actions[:len(s.actions):len(s.actions)]
+	return c
+}
+
+func (c memCell) same(o memCell) bool {
+	return c.ref.Version == o.ref.Version && c.ref.UpdatedAt.Equal(o.ref.UpdatedAt) &&
+		c.deleted == o.deleted && bytes.Equal(c.data, o.data)
+}
+
+// Run fn in transaction, joining transaction bound to ctx like a savepoint. State is restored when fn fails,
+// commit hooks run once outermost transaction commits
+func (m *memStore) atomically(ctx context.Context, fn func(ctx context.Context) error) error {
+	if t := m.txOf(ctx); t != nil {
+		saved := t.save()
+		txCtx, hooks := withCommitHooks(ctx)
+		if err := fn(txCtx); err != nil {
+			t.restore(saved)
+			return err
+		}
+		onCommit(ctx, hooks.run)
+		return nil
+	}
+	t, err := m.begin()
+	if err != nil {
+		return err
+	}
+	defer m.end(t)
+	txCtx, hooks := withCommitHooks(context.WithValue(ctx, memTxKey{}, t))
+	if err := fn(txCtx); err != nil {
+		return err
+	} else if err := m.commit(t); err != nil {
+		return err
+	}
+	hooks.run()
+	return nil
+}
+
+// Transaction of store bound to ctx, nil outside of one
+func (m *memStore) txOf(ctx context.Context) *memTx {
+	if t, ok := ctx.Value(memTxKey{}).(*memTx); ok && t.m == m {
+		return t
+	}
+	return nil
+}
+
+// State transaction bound to ctx modifies
+func (m *memStore) writable(ctx context.Context) *memState {
+	return &m.txOf(ctx).state
+}
+
+func (m *memStore) begin() (*memTx, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.err != nil {
+		return nil, m.err
+	} else if m.closed {
+		return nil, ErrClosed
+	}
+	m.running.Add(1)
+	return &memTx{m: m, base: m.state, state: m.state.clone(), locked: map[Key]memBase{}}, nil
+}
+
+// Release row locks of finished transaction
+func (m *memStore) end(t *memTx) {
+	m.mu.Lock()
+	for key := range t.locked {
+		if m.locks[key] == t {
+			delete(m.locks, key)
+		}
+	}
+	m.unlocked.Broadcast()
+	m.mu.Unlock()
+	m.running.Done()
+}
+
+// Publish state of transaction, refused when cell it changed was committed by another transaction since
+func (m *memStore) commit(t *memTx) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if t.truncated {
+		m.state = t.state
+		return nil
+	}
+	next := m.state.clone()
+	// fingerprint taken first decides like unique index in Postgres, before cells of batch conflict
+	for fp, at := range t.state.fingerprints {
+		if _, ok := t.base.fingerprints[fp]; ok {
+			continue
+		} else if _, ok := m.state.fingerprints[fp]; ok {
+			return fmt.Errorf("%s: %w", fp, errFingerprintTaken)
+		}
+		next.fingerprints[fp] = at
+	}
+	for fp := range t.base.fingerprints {
+		if _, ok := t.state.fingerprints[fp]; !ok {
+			delete(next.fingerprints, fp)
+		}
+	}
+	for key, c := range t.state.cells {
+		if b := t.baseOf(key); b.ok && b.cell.same(c) {
+			continue
+		} else if err := m.check(key, b); err != nil {
+			return err
+		}
+		next.cells[key] = c
+	}
+	for _, key := range t.baseKeys() {
+		if _, ok := t.state.cells[key]; ok {
+			continue
+		} else if err := m.check(key, t.baseOf(key)); err != nil {
+			return err
+		}
+		delete(next.cells, key)
+	}
+	for key, h := range t.state.history {
+		if n := len(t.base.history[key]); len(h) > n {
+			next.history[key] = append(next.history[key], h[n:]...)
+		}
+	}
+	for _, a := range t.state.actions[len(t.base.actions):] {
+		for _, c := range next.actions {
+			if a.key != "" && c.key == a.key {
+				return fmt.Errorf("action %s with key %s: %w", a.record.Name, a.key, ErrAlreadyProcessed)
+			}
+		}
+		next.actions = append(next.actions, a)
+	}
+	m.state = next
+	return nil
+}
+
+// Committed cell still has to be the one transaction continued from
+func (m *memStore) check(key Key, b memBase) error {
+	c, ok := m.state.cells[key]
+	if ok == b.ok && (!ok || c.same(b.cell)) {
+		return nil
+	} else if !b.ok {
+		return fmt.Errorf("insert %s: %w", key, ErrDuplicate)
+	}
+	return fmt.Errorf("commit %s: %w", key, errMemConflict)
+}
+
+// Take row lock of key for transaction, waiting while another transaction holds it. Transaction
+// continues from latest committed cell like after SELECT FOR UPDATE, unless it changed the cell already
+func (m *memStore) lock(t *memTx, key Key) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for m.locks[key] != nil && m.locks[key] != t {
+		m.unlocked.Wait()
+	}
+	m.locks[key] = t
+	c, ok := m.state.cells[key]
+	own, owned := t.state.cells[key]
+	if b := t.baseOf(key); b.ok == owned && (!owned || b.cell.same(own)) {
+		if ok {
+			t.state.cells[key] = c
+		} else {
+			delete(t.state.cells, key)
+		}
+	}
+	t.locked[key] = memBase{cell: c, ok: ok}
+}
+
+func (t *memTx) baseOf(key Key) memBase {
+	if b, ok := t.locked[key]; ok {
+		return b
+	}
+	c, ok := t.base.cells[key]
+	return memBase{cell: c, ok: ok}
+}
+
+func (t *memTx) baseKeys() []Key {
+	keys := make([]Key, 0, len(t.base.cells)+len(t.locked))
+	for key := range t.base.cells {
+		keys = append(keys, key)
+	}
+	for key, b := range t.locked {
+		if _, ok := t.base.cells[key]; !ok && b.ok {
+			keys = append(keys, key)
+		}
+	}
+	return keys
+}
+
+// Copy to restore when savepoint fails, row locks are kept
+func (t *memTx) save() memTx {
+	saved := *t
+	saved.state = t.state.clone()
+	return saved
+}
+
+func (t *memTx) restore(saved memTx) {
+	t.base, t.state, t.truncated = saved.base, saved.state, saved.truncated
+}
+
+// Run fn reading state of transaction bound to ctx or committed state
+func (m *memStore) read(ctx context.Context, fn func(st *memState)) {
+	if t := m.txOf(ctx); t != nil {
+		fn(&t.state)
+		return
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	fn(&m.state)
+}
+
+func (m *memStore) timestamp() time.Time {
+	return m.now().UTC().Truncate(time.Microsecond)
+}
+
+func (m *memStore) ApplyChanges(batch Batch) error {
+	return m.ApplyChangesContext(context.Background(), batch)
+}
+
+func (m *memStore) ApplyChangesContext(ctx context.Context, batch Batch, opts ...TxOption) error {
+	items := batch.Items()
+	if len(items) == 0 {
+		return nil
+	} else if err := validate(items); err != nil {
+		return err
+	}
+	return m.atomically(ctx, func(ctx context.Context) error {
+		return m.apply(ctx, items)
+	})
+}
+
+func (m *memStore) ApplyChangesResult(ctx context.Context, batch Batch, opts ...TxOption) (*ApplyResult, error) {
+	if err := m.ApplyChangesContext(ctx, batch, opts...); err != nil {
+		return nil, err
+	}
+	return newApplyResult(batch), nil
+}
+
+func (m *memStore) ApplyIdempotent(ctx context.Context, batch Batch, fingerprint string) error {
+	items := batch.Items()
+	if err := validate(items); err != nil {
+		return err
+	}
+	err := m.atomically(ctx, func(ctx context.Context) error {
+		st := m.writable(ctx)
+		if _, ok := st.fingerprints[fingerprint]; ok {
+			return nil
+		}
+		st.fingerprints[fingerprint] = m.timestamp()
+		return m.apply(ctx, items)
+	})
+	// concurrent duplicate committed first, like in Postgres store batch is not applied twice
+	if errors.Is(err, errFingerprintTaken) {
+		return nil
+	}
+	return err
+}
+
+func (m *memStore) PurgeFingerprints(ctx context.Context, before time.Time) (int64, error) {
+	var num int64
+	err := m.atomically(ctx, func(ctx context.Context) error {
+		st := m.writable(ctx)
+		for fp, at := range st.fingerprints {
+			if at.Before(before) {
+				delete(st.fingerprints, fp)
+				num++
+			}
+		}
+		return nil
+	})
+	return num, err
+}
+
+func (m *memStore) ApplyChangesChunked(ctx context.Context, batch Batch, chunkSize int, opts ...ChunkOption) error {
+	return applyChunked(ctx, batch, chunkSize, opts, func(changes []Change) error {
+		return m.atomically(ctx, func(ctx context.Context) error {
+			return m.apply(ctx, changes)
+		})
+	})
+}
+
+func (m *memStore) ApplyWithRetry(ctx context.Context, maxAttempts int, reload func() (Batch, error)) error {
+	return m.ApplyWithRetryOptions(ctx, maxAttempts, DefaultRetryOptions, reload)
+}
+
+func (m *memStore) ApplyWithRetryOptions(ctx context.Context, maxAttempts int, opts RetryOptions, reload func() (Batch, error)) error {
+	return applyWithRetry(ctx, maxAttempts, opts, reload, m.ApplyChangesContext)
+}
+
+// Apply changes within transaction
+func (m *memStore) apply(ctx context.Context, changes []Change) error {
+	for _, change := range changes {
+		if err := beforeChange(ctx, change); err != nil {
+			return err
+		}
+		var err error
+		switch change.T {
+		case AddChangeType:
+			err = m.add(ctx, change.V)
+		case UpdateChangeType:
+			err = m.update(ctx, change.V)
+		case DeleteChangeType:
+			err = m.remove(ctx, change.V)
+		}
+		if err != nil {
+			return err
+		}
+		afterChange(ctx, change)
+	}
+	return nil
+}
+
+func (m *memStore) add(ctx context.Context, e *Entity) error {
+	defaultColumnName(e)
+	now := m.timestamp()
+	data, err := memData(e.Model)
+	if err != nil {
+		return err
+	}
+	key, st := e.Ref.Key(), m.writable(ctx)
+	if _, ok := st.cells[key]; ok {
+		return fmt.Errorf("insert %s: %w", key, ErrDuplicate)
+	}
+	st.cells[key] = memCell{ref: insertRef(e.Ref, now), data: data}
+	stampInsert(ctx, e, now)
+	return nil
+}
+
+func (m *memStore) update(ctx context.Context, e *Entity) error {
+	key := e.Ref.Key()
+	c, ok := m.writable(ctx).cells[key]
+	if !ok || c.deleted || c.ref.Version != e.Ref.Version {
+		return ErrOptimisticLock
+	}
+	data, err := memData(e.Model)
+	if err != nil {
+		return err
+	}
+	now := m.timestamp()
+	m.overwrite(ctx, c, data, now)
+	stampUpdate(ctx, e, now)
+	return nil
+}
+
+// Store data as next version of cell, overwritten version is archived when versioning is on
+func (m *memStore) overwrite(ctx context.Context, c memCell, data types.JSONText, now time.Time) {
+	key, st := c.ref.Key(), m.writable(ctx)
+	if m.versioning {
+		st.history[key] = append(st.history[key], VersionRecord{
+			RowId:      c.ref.RowId,
+			ColumnName: c.ref.ColumnName,
+			Version:    c.ref.Version,
+			Data:       c.data,
+			Format:     JSONFormat,
+			CreatedAt:  c.ref.CreatedAt,
+			UpdatedAt:  c.ref.UpdatedAt,
+		})
+	}
+	c.data = data
+	c.ref.Version++
+	c.ref.UpdatedAt = now
+	st.cells[key] = c
+}
+
+func (m *memStore) remove(ctx context.Context, e *Entity) error {
+	key, st := e.Ref.Key(), m.writable(ctx)
+	if c, ok := st.cells[key]; !ok || c.ref.Version != e.Ref.Version {
+		return ErrOptimisticLock
+	}
+	delete(st.cells, key)
+	return nil
+}
+
+// Marshalled model, empty data is stored as null like in Postgres store
+func memData(model Model) (types.JSONText, error) {
+	item := model.Marshall()
+	if item.E != nil {
+		return nil, item.E
+	} else if len(bytes.TrimSpace(item.V)) == 0 {
+		return types.JSONText("null"), nil
+	} else if !json.Valid(item.V) {
+		return nil, fmt.Errorf("%w: %T", ErrInvalidJSON, model)
+	}
+	return append(types.JSONText(nil), item.V...), nil
+}
+
+func (c memCell) entity(m Model) (*Entity, error) {
+	if err := m.Unmarshall(c.ref, c.data); err != nil {
+		return nil, err
+	}
+	return &Entity{Model: m, Ref: c.ref}, nil
+}
+
+func (m *memStore) Load(ctx context.Context, model Model, rowId, columnName string) (*Entity, error) {
+	return m.load(ctx, model, Key{RowId: rowId, ColumnName: columnName}, false)
+}
+
+func (m *memStore) LoadIncludingDeleted(ctx context.Context, model Model, rowId, columnName string) (*Entity, error) {
+	return m.load(ctx, model, Key{RowId: rowId, ColumnName: columnName}, true)
+}
+
+func (m *memStore) load(ctx context.Context, model Model, key Key, deleted bool) (*Entity, error) {
+	var (
+		c  memCell
+		ok bool
+	)
+	m.read(ctx, func(st *memState) {
+		c, ok = st.cells[key]
+	})
+	if !ok || c.deleted && !deleted {
+		return nil, ErrNotFound
+	}
+	return c.entity(model)
+}
+
+func (m *memStore) LoadMany(ctx context.Context, keys []Key, factory func(Key) Model) (map[Key]*Entity, error) {
+	var cells []memCell
+	m.read(ctx, func(st *memState) {
+		for _, key := range keys {
+			if c, ok := st.cells[key]; ok && !c.deleted {
+				cells = append(cells, c)
+			}
+		}
+	})
+	res := make(map[Key]*Entity, len(cells))
+	for _, c := range cells {
+		e, err := c.entity(factory(c.ref.Key()))
+		if err != nil {
+			return nil, err
+		}
+		res[c.ref.Key()] = e
+	}
+	return res, nil
+}
+
+func (m *memStore) LoadRow(ctx context.Context, rowId string) (map[string]*Entity, error) {
+	var cells []memCell
+	m.read(ctx, func(st *memState) {
+		for _, c := range st.cells {
+			if c.ref.RowId == rowId && !c.deleted {
+				cells = append(cells, c)
+			}
+		}
+	})
+	if len(cells) == 0 {
+		return nil, ErrNotFound
+	}
+	res := make(map[string]*Entity, len(cells))
+	for _, c := range cells {
+		e, err := c.entity(&RawModel{})
+		if err != nil {
+			return nil, err
+		}
+		res[c.ref.ColumnName] = e
+	}
+	return res, nil
+}
+
+func (m *memStore) SaveRow(ctx context.Context, rowId string, entities map[string]*Entity) error {
+	return saveRow(ctx, m.ApplyChangesContext, rowId, entities)
+}
+
+func (m *memStore) SoftDelete(ctx context.Context, e *Entity) error {
+	if err := validate([]Change{{V: e, T: DeleteChangeType}}); err != nil {
+		return err
+	}
+	return m.atomically(ctx, func(ctx context.Context) error {
+		st := m.writable(ctx)
+		key := e.Ref.Key()
+		c, ok := st.cells[key]
+		if !ok || c.deleted || c.ref.Version != e.Ref.Version {
+			return ErrOptimisticLock
+		}
+		now := m.timestamp()
+		c.deleted = true
+		c.ref.Version++
+		c.ref.UpdatedAt = now
+		st.cells[key] = c
+		onCommit(ctx, func() {
+			e.Ref.Version++
+			e.Ref.UpdatedAt = now
+		})
+		return nil
+	})
+}
+
+func (m *memStore) RunAction(ctx context.Context, action Action, params Params) error {
+	return m.atomically(ctx, func(ctx context.Context) error {
+		batch := NewBatch()
+		if err := action.Exec(params, batch); err != nil {
+			return err
+		} else if err := validate(batch.Items()); err != nil {
+			return err
+		} else if err := m.writeLog(ctx, actionName(action), params, affected(batch.Items())); err != nil {
+			return err
+		}
+		return m.apply(ctx, batch.Items())
+	})
+}
+
+// Run action again while concurrent transaction commits cell it changed first, up to maxAttempts times
+func (m *memStore) RunActionSerializable(ctx context.Context, action Action, params Params, maxAttempts int) error {
+	var err error
+	for attempt := 0; attempt < maxAttempts || attempt == 0; attempt++ {
+		if err = m.RunAction(ctx, action, params); !errors.Is(err, errMemConflict) || m.txOf(ctx) != nil {
+			return err
+		}
+	}
+	return err
+}
+
+func (m *memStore) LogAction(ctx context.Context, name string, params Params) error {
+	return m.atomically(ctx, func(ctx context.Context) error {
+		return m.writeLog(ctx, name, params, nil)
+	})
+}
+
+func (m *memStore) writeLog(ctx context.Context, name string, params Params, changes []Change) error {
+	st := m.writable(ctx)
+	data, err := json.Marshal(params.Data)
+	if err != nil {
+		return err
+	}
+	if params.IdempotencyKey != "" {
+		for _, a := range st.actions {
+			if a.key == params.IdempotencyKey {
+				return fmt.Errorf("action %s with key %s: %w", name, params.IdempotencyKey, ErrAlreadyProcessed)
+			}
+		}
+	}
+	id, err := m.newID()
+	if err != nil {
+		return err
+	}
+	st.actions = append(st.actions, memAction{
+		record:  ActionRecord{RowId: id.String(), Name: name, Data: data, CreatedAt: m.timestamp()},
+		key:     params.IdempotencyKey,
+		changes: changes,
+	})
+	return nil
+}
+
+// Changes reduced to row and column of touched cells, as kept by action log
+func affected(changes []Change) []Change {
+	arr := make([]Change, len(changes))
+	for i, c := range changes {
+		arr[i] = Change{V: &Entity{Ref: Ref{RowId: c.V.Ref.RowId, ColumnName: c.V.Ref.ColumnName}}, T: c.T}
+	}
+	return arr
+}
+
+func (m *memStore) AffectedBy(ctx context.Context, actionId string) ([]Change, error) {
+	var (
+		changes []Change
+		found   bool
+	)
+	m.read(ctx, func(st *memState) {
+		for _, a := range st.actions {
+			if a.record.RowId == actionId {
+				changes, found = a.changes, true
+			}
+		}
+	})
+	if !found {
+		return nil, ErrNotFound
+	}
+	arr := make([]Change, len(changes))
+	for i, c := range changes {
+		arr[i] = Change{V: &Entity{Ref: c.V.Ref}, T: c.T}
+	}
+	return arr, nil
+}
+
+func (m *memStore) Actions(ctx context.Context, limit int, cursor string) ([]ActionRecord, string, error) {
+	if limit <= 0 {
+		limit = DefaultListLimit
+	}
+	var (
+		before    time.Time
+		beforeRow string
+	)
+	if cursor != "" {
+		var err error
+		if before, beforeRow, err = decodeCursor(cursor); err != nil {
+			return nil, "", err
+		}
+	}
+	var arr []ActionRecord
+	m.read(ctx, func(st *memState) {
+		for _, a := range st.actions {
+			if cursor == "" || compareCreated(Ref{RowId: a.record.RowId, CreatedAt: a.record.CreatedAt}, before, beforeRow) < 0 {
+				arr = append(arr, a.record)
+			}
+		}
+	})
+	sort.Slice(arr, func(i, j int) bool {
+		return compareCreated(Ref{RowId: arr[i].RowId, CreatedAt: arr[i].CreatedAt}, arr[j].CreatedAt, arr[j].RowId) > 0
+	})
+	if len(arr) > limit {
+		arr = arr[:limit]
+	}
+	return arr, actionsCursor(arr, limit), nil
+}
+
+func (m *memStore) Upsert(ctx context.Context, e *Entity) error {
+	if err := validate([]Change{{V: e, T: AddChangeType}}); err != nil {
+		return err
+	}
+	data, err := memData(e.Model)
+	if err != nil {
+		return err
+	}
+	return m.atomically(ctx, func(ctx context.Context) error {
+		st := m.writable(ctx)
+		ref := e.Ref
+		ref.UpdatedAt = m.timestamp()
+		if ref.CreatedAt.IsZero() {
+			ref.CreatedAt = ref.UpdatedAt
+		}
+		key := ref.Key()
+		if c, ok := st.cells[key]; !ok {
+			st.cells[key] = memCell{ref: ref, data: data}
+		} else {
+			c.ref.Version++
+			c.ref.UpdatedAt = ref.UpdatedAt
+			c.data = data
+			c.deleted = false
+			st.cells[key] = c
+			ref.Version = c.ref.Version
+		}
+		onCommit(ctx, func() {
+			e.Ref = ref
+		})
+		return nil
+	})
+}
+
+func (m *memStore) Save(ctx context.Context, e *Entity) error {
+	return saveEntity(ctx, m.ApplyChangesContext, e)
+}
+
+func (m *memStore) SaveChanged(ctx context.Context, e, baseline *Entity) error {
+	if e.Equals(baseline) {
+		return nil
+	}
+	return m.Save(ctx, e)
+}
+
+func (m *memStore) LoadOrCreate(ctx context.Context, model Model, rowId, columnName string, init func() Model) (e *Entity, created bool, err error) {
+	columnName = columnOf(model, columnName)
+	if err := validateRef(Ref{RowId: rowId, ColumnName: columnName}, AddChangeType); err != nil {
+		return nil, false, err
+	}
+	err = m.atomically(ctx, func(ctx context.Context) error {
+		if e, err = m.Load(ctx, model, rowId, columnName); !errors.Is(err, ErrNotFound) {
+			return err
+		}
+		fresh := &Entity{Model: init(), Ref: Ref{RowId: rowId, ColumnName: columnName}}
+		if err := m.Save(ctx, fresh); errors.Is(err, ErrDuplicate) {
+			e, err = m.Load(ctx, model, rowId, columnName)
+			return err
+		} else if err != nil {
+			return err
+		}
+		e, created = fresh, true
+		return nil
+	})
+	// creator committed since transaction began wins the race, its cell is loaded as pg does on conflict
+	if errors.Is(err, ErrDuplicate) && m.txOf(ctx) == nil {
+		e, created = nil, false
+		e, err = m.Load(ctx, model, rowId, columnName)
+	}
+	if err != nil {
+		return nil, false, err
+	}
+	return e, created, nil
+}
+
+func (m *memStore) PatchJSON(ctx context.Context, ref Ref, patch map[string]any) error {
+	if err := validateRef(ref, UpdateChangeType); err != nil {
+		return err
+	}
+	return m.atomically(ctx, func(ctx context.Context) error {
+		st := m.writable(ctx)
+		c, ok := st.cells[ref.Key()]
+		if !ok || c.deleted || c.ref.Version != ref.Version {
+			return wrapErr("patch", ref, ErrOptimisticLock)
+		}
+		doc := map[string]any{}
+		if err := json.Unmarshal(c.data, &doc); err != nil {
+			return fmt.Errorf("patch %s: %w", ref.Key(), err)
+		}
+		for k, v := range patch {
+			doc[k] = v
+		}
+		data, err := json.Marshal(doc)
+		if err != nil {
+			return err
+		}
+		m.overwrite(ctx, c, data, m.timestamp())
+		return nil
+	})
+}
+
+func (m *memStore) FindByJSON(ctx context.Context, columnName, jsonPath string, value any, factory func() Model) ([]*Entity, error) {
+	match, err := jsonMatcher(jsonPath, value)
+	if err != nil {
+		return nil, err
+	}
+	var cells []memCell
+	m.read(ctx, func(st *memState) {
+		for _, c := range st.cells {
+			if c.ref.ColumnName == columnName && !c.deleted && match(c.data) {
+				cells = append(cells, c)
+			}
+		}
+	})
+	sortCells(cells, false)
+	arr := make([]*Entity, 0, len(cells))
+	for _, c := range cells {
+		e, err := c.entity(factory())
+		if err != nil {
+			return nil, err
+		}
+		arr = append(arr, e)
+	}
+	return arr, nil
+}
+
+func (m *memStore) DeleteWhere(ctx context.Context, columnName, jsonPath string, value any) (int64, error) {
+	match, err := jsonMatcher(jsonPath, value)
+	if err != nil {
+		return 0, err
+	}
+	var num int64
+	err = m.atomically(ctx, func(ctx context.Context) error {
+		st := m.writable(ctx)
+		for key, c := range st.cells {
+			if c.ref.ColumnName == columnName && match(c.data) {
+				delete(st.cells, key)
+				num++
+			}
+		}
+		return nil
+	})
+	return num, err
+}
+
+// Predicate of FindByJSON and DeleteWhere: scalars compare as text, other values and nil by containment
+func jsonMatcher(jsonPath string, value any) (func(types.JSONText) bool, error) {
+	if isScalar(value) {
+		text := scalarText(value)
+		return func(data types.JSONText) bool {
+			return jsonFieldText(data, jsonPath) == text
+		}, nil
+	}
+	doc, err := json.Marshal(map[string]any{jsonPath: value})
+	if err != nil {
+		return nil, err
+	}
+	var want any
+	if err := json.Unmarshal(doc, &want); err != nil {
+		return nil, err
+	}
+	return func(data types.JSONText) bool {
+		var have any
+		return json.Unmarshal(data, &have) == nil && jsonContains(have, want)
+	}, nil
+}
+
+// Text of top level field as Postgres ->> gives it, nil in Postgres terms is reported as
+// value no text equals
+func jsonFieldText(data types.JSONText, field string) string {
+	var doc map[string]json.RawMessage
+	if err := json.Unmarshal(data, &doc); err != nil {
+		return "\x00"
+	}
+	raw, ok := doc[field]
+	if !ok || string(raw) == "null" {
+		return "\x00"
+	}
+	var s string
+	if json.Unmarshal(raw, &s) == nil {
+		return s
+	}
+	var buf bytes.Buffer
+	if json.Compact(&buf, raw) != nil {
+		return "\x00"
+	}
+	return buf.String()
+}
+
+// Postgres @> containment of decoded JSON documents
+func jsonContains(have, want any) bool {
+	switch w := want.(type) {
+	case map[string]any:
+		h, ok := have.(map[string]any)
+		if !ok {
+			return false
+		}
+		for k, v := range w {
+			if hv, ok := h[k]; !ok || !jsonContains(hv, v) {
+				return false
+			}
+		}
+		return true
+	case []any:
+		h, ok := have.([]any)
+		if !ok {
+			return false
+		}
+		for _, v := range w {
+			found := false
+			for _, hv := range h {
+				if jsonContains(hv, v) {
+					found = true
+					break
+				}
+			}
+			if !found {
+				return false
+			}
+		}
+		return true
+	default:
+		return have == want
+	}
+}
+
+func (m *memStore) EnsureSchema(ctx context.Context) error {
+	return nil
+}
+
+// Remove everything kept by store, tables are ignored
+func (m *memStore) Truncate(ctx context.Context, tables ...string) error {
+	return m.atomically(ctx, func(ctx context.Context) error {
+		t := m.txOf(ctx)
+		t.state, t.truncated = newMemState(), true
+		return nil
+	})
+}
+
+func (m *memStore) MigrateData(ctx context.Context, columnName string, transform func(types.JSONText) (types.JSONText, error)) (int64, error) {
+	var num int64
+	err := m.atomically(ctx, func(ctx context.Context) error {
+		st := m.writable(ctx)
+		num = 0
+		var cells []memCell
+		for _, c := range st.cells {
+			if c.ref.ColumnName == columnName && !c.deleted {
+				cells = append(cells, c)
+			}
+		}
+		sortCells(cells, true)
+		now := m.timestamp()
+		for _, c := range cells {
+			out, err := transform(append(types.JSONText(nil), c.data...))
+			if err != nil {
+				return fmt.Errorf("migrate %s: %w", c.ref.Key(), err)
+			} else if bytes.Equal(out, c.data) {
+				continue
+			} else if !json.Valid(out) {
+				return fmt.Errorf("migrate %s: %w", c.ref.Key(), ErrInvalidJSON)
+			}
+			m.overwrite(ctx, c, append(types.JSONText(nil), out...), now)
+			num++
+		}
+		return nil
+	})
+	return num, err
+}
+
+func (m *memStore) List(ctx context.Context, columnName string, opts ListOptions) ([]*Entity, string, error) {
+	if opts.Limit <= 0 {
+		opts.Limit = DefaultListLimit
+	}
+	var (
+		after     time.Time
+		afterRow  string
+		hasCursor = opts.Cursor != ""
+	)
+	if hasCursor {
+		var err error
+		if after, afterRow, err = decodeCursor(opts.Cursor); err != nil {
+			return nil, "", err
+		}
+	}
+	desc := opts.Order == Descending
+	var cells []memCell
+	m.read(ctx, func(st *memState) {
+		for _, c := range st.cells {
+			if c.ref.ColumnName != columnName || c.deleted {
+				continue
+			}
+			if hasCursor {
+				cmp := compareCreated(c.ref, after, afterRow)
+				if !desc && cmp <= 0 || desc && cmp >= 0 {
+					continue
+				}
+			}
+			cells = append(cells, c)
+		}
+	})
+	sortCells(cells, true)
+	if desc {
+		for i, j := 0, len(cells)-1; i < j; i, j = i+1, j-1 {
+			cells[i], cells[j] = cells[j], cells[i]
+		}
+	}
+	if len(cells) > opts.Limit {
+		cells = cells[:opts.Limit]
+	}
+	arr := make([]*Entity, 0, len(cells))
+	for _, c := range cells {
+		e, err := c.entity(opts.newModel())
+		if err != nil {
+			return nil, "", err
+		}
+		arr = append(arr, e)
+	}
+	if len(cells) < opts.Limit {
+		return arr, "", nil
+	}
+	last := cells[len(cells)-1].ref
+	return arr, encodeCursor(last.CreatedAt, last.RowId), nil
+}
+
+// Position of ref against (created_at, row_id) cursor
+func compareCreated(ref Ref, createdAt time.Time, rowId string) int {
+	switch {
+	case ref.CreatedAt.Before(createdAt):
+		return -1
+	case ref.CreatedAt.After(createdAt):
+		return 1
+	case ref.RowId < rowId:
+		return -1
+	case ref.RowId > rowId:
+		return 1
+	default:
+		return 0
+	}
+}
+
+// Order cells by (created_at, row_id) or by key
+func sortCells(cells []memCell, byCreated bool) {
+	sort.Slice(cells, func(i, j int) bool {
+		if byCreated {
+			return compareCreated(cells[i].ref, cells[j].ref.CreatedAt, cells[j].ref.RowId) < 0
+		}
+		return cells[i].ref.Key().Compare(cells[j].ref.Key()) < 0
+	})
+}
+
+func (m *memStore) Stream(ctx context.Context, columnName string, factory func() Model) (*EntityIterator, error) {
+	var cells []memCell
+	m.read(ctx, func(st *memState) {
+		for _, c := range st.cells {
+			if c.ref.ColumnName == columnName && !c.deleted {
+				cells = append(cells, c)
+			}
+		}
+	})
+	sortCells(cells, false)
+	return &EntityIterator{ctx: ctx, src: &memSource{cells: cells, factory: factory}}, nil
+}
+
+func (s *memSource) fetch() (*Entity, bool, error) {
+	if len(s.cells) == 0 {
+		return nil, false, nil
+	}
+	c := s.cells[0]
+	s.cells = s.cells[1:]
+	if e, err := c.entity(s.factory()); err != nil {
+		return nil, false, err
+	} else {
+		return e, true, nil
+	}
+}
+
+func (s *memSource) Close() error {
+	s.cells = nil
+	return nil
+}
+
+func (m *memStore) History(ctx context.Context, rowId, columnName string) ([]VersionRecord, error) {
+	var arr []VersionRecord
+	m.read(ctx, func(st *memState) {
+		arr = append(arr, st.history[Key{RowId: rowId, ColumnName: columnName}]...)
+	})
+	return arr, nil
+}
+
+func (m *memStore) Ping(ctx context.Context) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.err != nil {
+		return m.err
+	} else if m.closed {
+		return ErrClosed
+	}
+	return nil
+}
+
+// Refuse new transactions and wait for running ones to finish or ctx to expire
+func (m *memStore) Close(ctx context.Context) error {
+	m.mu.Lock()
+	if m.closed {
+		m.mu.Unlock()
+		return ErrClosed
+	}
+	m.closed = true
+	m.mu.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		m.running.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (m *memStore) WithTx(ctx context.Context, fn func(tx Tx) error) error {
+	return m.atomically(ctx, func(ctx context.Context) error {
+		return fn(&memScope{m: m, tx: m.txOf(ctx), hooks: commitHooksFrom(ctx)})
+	})
+}
+
+// Load model within tx taking row lock, so concurrent LoadForUpdate of the same cell waits until tx ends
+// and then continues from version it committed
+func (m *memStore) LoadForUpdate(ctx context.Context, tx Tx, model Model, rowId, columnName string) (*Entity, error) {
+	scope, ok := tx.(*memScope)
+	if !ok || scope.m != m {
+		return nil, ErrNoTx
+	}
+	m.lock(scope.tx, Key{RowId: rowId, ColumnName: columnName})
+	return m.Load(scope.bind(ctx), model, rowId, columnName)
+}
+
+func (m *memStore) ExportNDJSON(ctx context.Context, columnName string, w io.Writer) (int64, error) {
+	var cells []memCell
+	m.read(ctx, func(st *memState) {
+		for _, c := range st.cells {
+			if c.ref.ColumnName == columnName && !c.deleted {
+				cells = append(cells, c)
+			}
+		}
+	})
+	sortCells(cells, false)
+	enc := json.NewEncoder(w)
+	for i, c := range cells {
+		if err := enc.Encode(ndjsonRecord{
+			RowId:      c.ref.RowId,
+			ColumnName: c.ref.ColumnName,
+			Version:    c.ref.Version,
+			Data:       json.RawMessage(c.data),
+			CreatedAt:  c.ref.CreatedAt,
+			UpdatedAt:  c.ref.UpdatedAt,
+		}); err != nil {
+			return int64(i), err
+		}
+	}
+	return int64(len(cells)), nil
+}
+
+func (m *memStore) ImportNDJSON(ctx context.Context, r io.Reader) (int64, error) {
+	var (
+		num int64
+		dec = json.NewDecoder(r)
+	)
+	for {
+		var rec ndjsonRecord
+		if err := dec.Decode(&rec); errors.Is(err, io.EOF) {
+			return num, nil
+		} else if err != nil {
+			return num, fmt.Errorf("line %d: %w", num+1, err)
+		}
+		data, format, err := rec.payload()
+		if err != nil {
+			return num, fmt.Errorf("line %d: %w", num+1, err)
+		} else if err := validateRef(rec.ref(), AddChangeType); err != nil {
+			return num, fmt.Errorf("line %d: %w", num+1, err)
+		} else if format != JSONFormat {
+			return num, fmt.Errorf("line %d: memory store cannot import %s data", num+1, format)
+		} else if !json.Valid(data) {
+			return num, fmt.Errorf("line %d: %w", num+1, ErrInvalidJSON)
+		}
+		ref := rec.ref()
+		err = m.atomically(ctx, func(ctx context.Context) error {
+			st := m.writable(ctx)
+			c, ok := st.cells[ref.Key()]
+			if !ok {
+				st.cells[ref.Key()] = memCell{ref: ref, data: append(types.JSONText(nil), data...)}
+				return nil
+			}
+			c.ref.Version++
+			c.ref.UpdatedAt = ref.UpdatedAt
+			c.data = append(types.JSONText(nil), data...)
+			c.deleted = false
+			st.cells[ref.Key()] = c
+			return nil
+		})
+		if err != nil {
+			return num, err
+		}
+		num++
+	}
+}
+
+func (m *memStore) Touch(ctx context.Context, ref Ref) error {
+	if err := validateRef(ref, UpdateChangeType); err != nil {
+		return err
+	}
+	return m.atomically(ctx, func(ctx context.Context) error {
+		st := m.writable(ctx)
+		c, ok := st.cells[ref.Key()]
+		if !ok || c.deleted || c.ref.Version != ref.Version {
+			return wrapErr("touch", ref, ErrOptimisticLock)
+		}
+		m.overwrite(ctx, c, c.data, m.timestamp())
+		return nil
+	})
+}
+
+func (m *memStore) PrecheckVersions(ctx context.Context, refs []Ref) ([]Key, error) {
+	var stale []Key
+	m.read(ctx, func(st *memState) {
+		for _, ref := range refs {
+			if c, ok := st.cells[ref.Key()]; !ok || c.deleted || c.ref.Version != ref.Version {
+				stale = append(stale, ref.Key())
+			}
+		}
+	})
+	return stale, nil
+}
+
+func (m *memStore) Snapshot(ctx context.Context, rowId, columnName string) ([]byte, error) {
+	var (
+		c  memCell
+		ok bool
+	)
+	m.read(ctx, func(st *memState) {
+		c, ok = st.cells[Key{RowId: rowId, ColumnName: columnName}]
+	})
+	if !ok || c.deleted {
+		return nil, ErrNotFound
+	}
+	return json.Marshal(snapshot{
+		RowId:      c.ref.RowId,
+		ColumnName: c.ref.ColumnName,
+		Version:    c.ref.Version,
+		Data:       c.data,
+		Format:     JSONFormat,
+		CreatedAt:  c.ref.CreatedAt,
+		UpdatedAt:  c.ref.UpdatedAt,
+	})
+}
+
+func (m *memStore) Restore(ctx context.Context, blob []byte, force bool) error {
+	s, err := parseSnapshot(blob)
+	if err != nil {
+		return err
+	} else if err := validateRef(s.ref(), AddChangeType); err != nil {
+		return err
+	} else if s.Format != JSONFormat || s.Compressed || s.KeyId != "" {
+		return fmt.Errorf("model: memory store cannot restore %s data", s.Format)
+	}
+	return m.atomically(ctx, func(ctx context.Context) error {
+		st := m.writable(ctx)
+		key := s.ref().Key()
+		c, ok := st.cells[key]
+		if !ok {
+			st.cells[key] = memCell{ref: s.ref(), data: append(types.JSONText(nil), s.Data...)}
+			return nil
+		} else if c.ref.Version > s.Version && !force {
+			return wrapErr("restore", s.ref(), ErrOptimisticLock)
+		}
+		m.overwrite(ctx, c, append(types.JSONText(nil), s.Data...), m.timestamp())
+		c = st.cells[key]
+		c.deleted = false
+		st.cells[key] = c
+		return nil
+	})
+}
+
+func (m *memStore) InReadTx(ctx context.Context, fn func(ReadStore) error) error {
+	return m.atomically(ctx, func(ctx context.Context) error {
+		return fn(&memScope{m: m, tx: m.txOf(ctx)})
+	})
+}
+
+func (m *memStore) InTx(ctx context.Context, fn func(ctx context.Context) error, opts ...TxOption) error {
+	return m.atomically(ctx, fn)
+}
+
+// Memory store executes no statements
+func (m *memStore) Plan(batch Batch) ([]PlannedStatement, error) {
+	return nil, nil
+}
+
+func (m *memStore) Count(ctx context.Context, columnName string) (int64, error) {
+	return m.count(ctx, func(c memCell) bool { return c.ref.ColumnName == columnName }), nil
+}
+
+func (m *memStore) CountByRow(ctx context.Context, rowId string) (int64, error) {
+	return m.count(ctx, func(c memCell) bool { return c.ref.RowId == rowId }), nil
+}
+
+func (m *memStore) count(ctx context.Context, match func(memCell) bool) int64 {
+	var num int64
+	m.read(ctx, func(st *memState) {
+		for _, c := range st.cells {
+			if !c.deleted && match(c) {
+				num++
+			}
+		}
+	})
+	return num
+}
+
+func (m *memStore) Exists(ctx context.Context, rowId, columnName string) (bool, error) {
+	var ok bool
+	m.read(ctx, func(st *memState) {
+		c, found := st.cells[Key{RowId: rowId, ColumnName: columnName}]
+		ok = found && !c.deleted
+	})
+	return ok, nil
+}
+
+func (s *memScope) bind(ctx context.Context) context.Context {
+	if s.hooks != nil {
+		ctx = context.WithValue(ctx, commitKey{}, s.hooks)
+	}
+	return context.WithValue(ctx, memTxKey{}, s.tx)
+}
+
+func (s *memScope) Load(ctx context.Context, m Model, rowId, columnName string) (*Entity, error) {
+	return s.m.Load(s.bind(ctx), m, rowId, columnName)
+}
+
+func (s *memScope) LoadMany(ctx context.Context, keys []Key, factory func(Key) Model) (map[Key]*Entity, error) {
+	return s.m.LoadMany(s.bind(ctx), keys, factory)
+}
+
+func (s *memScope) Count(ctx context.Context, columnName string) (int64, error) {
+	return s.m.Count(s.bind(ctx), columnName)
+}
+
+func (s *memScope) CountByRow(ctx context.Context, rowId string) (int64, error) {
+	return s.m.CountByRow(s.bind(ctx), rowId)
+}
+
+func (s *memScope) Exists(ctx context.Context, rowId, columnName string) (bool, error) {
+	return s.m.Exists(s.bind(ctx), rowId, columnName)
+}
+
+func (s *memScope) Add(ctx context.Context, e *Entity) error {
+	return s.write(ctx, Change{V: e, T: AddChangeType})
+}
+
+func (s *memScope) Update(ctx context.Context, e *Entity) error {
+	if err := s.write(ctx, Change{V: e, T: UpdateChangeType}); err != nil {
+		return err
+	}
+	onCommit(s.bind(ctx), func() {
+		e.Ref.Version++
+	})
+	return nil
+}
+
+func (s *memScope) Delete(ctx context.Context, e *Entity) error {
+	return s.write(ctx, Change{V: e, T: DeleteChangeType})
+}
+
+func (s *memScope) write(ctx context.Context, change Change) error {
+	changes := []Change{change}
+	if change.T == AddChangeType {
+		defaultColumnName(change.V)
+	}
+	if err := validate(changes); err != nil {
+		return err
+	}
+	return s.m.atomically(s.bind(ctx), func(ctx context.Context) error {
+		return s.m.apply(ctx, changes)
+	})
+}
@@ -0,0 +1,84 @@
+package active
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Store metrics, nil value records nothing
+type metrics struct {
+	applyTotal    *prometheus.CounterVec
+	lockConflicts prometheus.Counter
+	applyDuration prometheus.Histogram
+}
+
+// Register store metrics in reg, the default registry is never touched
+func WithMetrics(reg prometheus.Registerer) Option {
+	return func(p *pg) {
+		if reg == nil {
+			return
+		} else if m, err := newMetrics(reg); err != nil {
+			p.fail(fmt.Errorf("%w: metrics: %w", ErrInvalidOption, err))
+		} else {
+			p.metrics = m
+		}
+	}
+}
+
+func newMetrics(reg prometheus.Registerer) (*metrics, error) {
+	var errs []error
+	m := &metrics{
+		applyTotal: register(reg, &errs, prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "active_apply_total",
+			Help: "Number of applied batches by result.",
+		}, []string{"result"})),
+		lockConflicts: register(reg, &errs, prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "active_lock_conflicts_total",
+			Help: "Number of optimistic lock conflicts.",
+		})),
+		applyDuration: register(reg, &errs, prometheus.NewHistogram(prometheus.HistogramOpts{
+			Name:    "active_apply_duration_seconds",
+			Help:    "Batch apply latency.",
+			Buckets: prometheus.DefBuckets,
+		})),
+	}
+	return m, errors.Join(errs...)
+}
+
+// Register collector, reusing already registered one with same description. Failure is added to errs
+func register[C prometheus.Collector](reg prometheus.Registerer, errs *[]error, c C) C {
+	if err := reg.Register(c); err != nil {
+		var already prometheus.AlreadyRegisteredError
+		if errors.As(err, &already) {
+			if existing, ok := already.ExistingCollector.(C); ok {
+				return existing
+			}
+		}
+		*errs = append(*errs, err)
+	}
+	return c
+}
+
+func (m *metrics) observeApply(started time.Time, err error) {
+	if m == nil {
+		return
+	}
+	result := "ok"
+	if err != nil {
+		result = "error"
+	}
+	m.applyTotal.WithLabelValues(result).Inc()
+	m.applyDuration.Observe(time.Since(started).Seconds())
+}
+
+func (m *metrics) observeChange(err error) {
+	if m == nil {
+		return
+	}
+	if errors.Is(err, ErrOptimisticLock) {
+		m.lockConflicts.Inc()
+	}
+}
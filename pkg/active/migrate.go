@@ -0,0 +1,106 @@
+package active
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/jmoiron/sqlx/types"
+)
+
+const (
+	// Rows of column rewritten by single MigrateData transaction
+	migrateBatchSize = 500
+)
+
+// Rewrite stored JSON data of not deleted cells of column with transform, page by page in
+// transactions of migrateBatchSize rows. Rewritten cells get version bumped and archived when
+// versioning is on, rows transform returns unchanged and cells stored in other than JSON format
+// are skipped. Cell changed concurrently fails its page with ErrOptimisticLock, pages committed
+// before stay migrated. Returns number of rewritten cells
+func (pg *pg) MigrateData(ctx context.Context, columnName string, transform func(types.JSONText) (types.JSONText, error)) (int64, error) {
+	ctx, err := pg.enter(ctx)
+	if err != nil {
+		return 0, err
+	}
+	var (
+		num  int64
+		opts = ListOptions{Limit: migrateBatchSize}
+	)
+	for {
+		query, args, err := pg.listQuery(ctx, columnName, opts)
+		if err != nil {
+			return num, err
+		}
+		var cells []cell
+		if err := sqlx.SelectContext(ctx, pg.queryer(ctx), &cells, query, args...); err != nil {
+			return num, err
+		}
+		var migrated int64
+		err = pg.inTx(ctx, func(ctx context.Context, tx *sqlx.Tx) error {
+			migrated = 0
+			for _, aCell := range cells {
+				if ok, err := pg.migrateCell(ctx, tx, aCell, transform); err != nil {
+					return err
+				} else if ok {
+					migrated++
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			return num, err
+		}
+		num += migrated
+		if len(cells) < opts.Limit {
+			return num, nil
+		}
+		last := cells[len(cells)-1]
+		opts.Cursor = encodeCursor(last.CreatedAt, last.RowId)
+	}
+}
+
+func (pg *pg) migrateCell(ctx context.Context, tx *sqlx.Tx, c cell, transform func(types.JSONText) (types.JSONText, error)) (bool, error) {
+	if c.Format.Valid && c.Format.String != JSONFormat {
+		return false, nil
+	}
+	ref := c.toRef()
+	data, err := pg.unseal(c)
+	if err != nil {
+		return false, err
+	}
+	out, err := transform(data)
+	if err != nil {
+		return false, fmt.Errorf("migrate %s: %w", ref.Key(), err)
+	} else if bytes.Equal(out, data) {
+		return false, nil
+	} else if !json.Valid(out) {
+		return false, fmt.Errorf("migrate %s: %w", ref.Key(), ErrInvalidJSON)
+	}
+	p, err := pg.seal(out, JSONFormat)
+	if err != nil {
+		return false, err
+	}
+	if pg.versioning {
+		if err := pg.archive(ctx, tx, &Entity{Ref: ref}); err != nil {
+			return false, err
+		}
+	}
+	if r, err := tx.ExecContext(ctx, pg.q(ctx).update,
+		p.data,
+		JSONFormat,
+		p.compressed,
+		p.keyId,
+		ref.Version+1,
+		pg.timestamp(),
+		ref.RowId,
+		ref.ColumnName,
+		ref.Version); err != nil {
+		return false, wrapErr("migrate", ref, err)
+	} else if err := pg.expectOne(ctx, r, ref); err != nil {
+		return false, wrapErr("migrate", ref, err)
+	}
+	return true, nil
+}
@@ -0,0 +1,42 @@
+package active
+
+import (
+	"context"
+	"errors"
+)
+
+// Decides outcome of statement meant for single cell that changed rows of several, possible only
+// without unique (row_id, column_name). Returned error fails the statement, nil lets it pass
+type MultiRowPolicy func(ctx context.Context, ref Ref, rows int64) error
+
+var (
+	// Statement changed several rows under MultiRowError policy
+	ErrMultipleRows = errors.New("model: more than one record updated")
+
+	// Fail statement with ErrMultipleRows, default
+	MultiRowError MultiRowPolicy = func(context.Context, Ref, int64) error {
+		return ErrMultipleRows
+	}
+
+	// Accept statement as if it changed single row
+	MultiRowIgnore MultiRowPolicy = func(context.Context, Ref, int64) error {
+		return nil
+	}
+)
+
+// Handle statements changing several rows with policy, MultiRowError, MultiRowIgnore or own callback,
+// e.g. one logging rows and returning nil while schema lacks unique constraint
+func WithMultiRowPolicy(policy MultiRowPolicy) Option {
+	return func(p *pg) {
+		if policy != nil {
+			p.multiRowPolicy = policy
+		}
+	}
+}
+
+func (pg *pg) multiRow(ctx context.Context, ref Ref, rows int64) error {
+	if pg.multiRowPolicy == nil {
+		return MultiRowError(ctx, ref, rows)
+	}
+	return pg.multiRowPolicy(ctx, ref, rows)
+}
@@ -0,0 +1,113 @@
+package active
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/jmoiron/sqlx/types"
+)
+
+func TestMultiRowPolicy(t *testing.T) {
+	type call struct {
+		key  Key
+		rows int64
+	}
+	paths := []struct {
+		name   string
+		change ChangeType
+		rows   int
+		expect func(mock sqlmock.Sqlmock)
+	}{
+		{
+			name:   "single update",
+			change: UpdateChangeType,
+			rows:   1,
+			expect: func(mock sqlmock.Sqlmock) {
+				mock.ExpectPrepare(`UPDATE models`).ExpectExec().WillReturnResult(sqlmock.NewResult(0, 2))
+			},
+		},
+		{
+			name:   "grouped update",
+			change: UpdateChangeType,
+			rows:   2,
+			expect: func(mock sqlmock.Sqlmock) {
+				mock.ExpectQuery(`UPDATE models AS m .* RETURNING m.row_id, m.column_name, m.version`).
+					WillReturnRows(sqlmock.NewRows([]string{"row_id", "column_name", "version"}).
+						AddRow("r1", "doc", 2).AddRow("r1", "doc", 2).AddRow("r2", "doc", 2))
+			},
+		},
+		{
+			name:   "grouped insert",
+			change: AddChangeType,
+			rows:   2,
+			expect: func(mock sqlmock.Sqlmock) {
+				mock.ExpectQuery(`INSERT INTO models .* RETURNING row_id, column_name`).
+					WillReturnRows(sqlmock.NewRows([]string{"row_id", "column_name"}).
+						AddRow("r1", "doc").AddRow("r1", "doc").AddRow("r2", "doc"))
+			},
+		},
+	}
+	for _, path := range paths {
+		var calls []call
+		policies := []struct {
+			name      string
+			policy    MultiRowPolicy
+			wantErr   error
+			wantCalls []call
+		}{
+			{
+				name:    "error by default",
+				wantErr: ErrMultipleRows,
+			},
+			{
+				name:    "error",
+				policy:  MultiRowError,
+				wantErr: ErrMultipleRows,
+			},
+			{
+				name:   "ignore",
+				policy: MultiRowIgnore,
+			},
+			{
+				name: "callback",
+				policy: func(_ context.Context, ref Ref, rows int64) error {
+					calls = append(calls, call{key: ref.Key(), rows: rows})
+					return nil
+				},
+				wantCalls: []call{{key: Key{RowId: "r1", ColumnName: "doc"}, rows: 2}},
+			},
+		}
+		for _, tt := range policies {
+			t.Run(path.name+"/"+tt.name, func(t *testing.T) {
+				calls = nil
+				store, mock := newMockStore(t, WithMultiRowPolicy(tt.policy))
+				mock.ExpectBegin()
+				path.expect(mock)
+				if tt.wantErr != nil {
+					mock.ExpectRollback()
+				} else {
+					mock.ExpectCommit()
+				}
+
+				batch := NewBatch()
+				for _, rowId := range []string{"r1", "r2"}[:path.rows] {
+					e := &Entity{Model: &RawModel{Data: types.JSONText(`{}`)}, Ref: Ref{RowId: rowId, ColumnName: "doc"}}
+					if path.change == UpdateChangeType {
+						e.Ref.Version, e.Ref.CreatedAt, e.Ref.UpdatedAt = 1, time.Now(), time.Now()
+						batch.Update(e)
+					} else {
+						batch.Add(e)
+					}
+				}
+				if err := store.ApplyChangesContext(context.Background(), *batch); !errors.Is(err, tt.wantErr) || tt.wantErr == nil && err != nil {
+					t.Fatalf("expected %v, got %v", tt.wantErr, err)
+				} else if len(calls) != len(tt.wantCalls) || len(calls) > 0 && calls[0] != tt.wantCalls[0] {
+					t.Fatalf("expected policy calls %v, got %v", tt.wantCalls, calls)
+				}
+			})
+		}
+	}
+}
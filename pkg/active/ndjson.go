@@ -0,0 +1,134 @@
+package active
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+)
+
+// Line of NDJSON export, data of other than JSON format is kept base64 encoded and format is named
+type ndjsonRecord struct {
+	RowId      string          `json:"row_id"`
+	ColumnName string          `json:"column_name"`
+	Version    uint            `json:"version"`
+	Format     string          `json:"format,omitempty"`
+	Data       json.RawMessage `json:"data"`
+	CreatedAt  time.Time       `json:"created_at"`
+	UpdatedAt  time.Time       `json:"updated_at"`
+}
+
+// Write not deleted cells of column to w as one JSON object per line, ordered by row. Rows are read
+// through cursor, so memory stays bounded. Data is written decrypted and inflated. Returns number of lines
+func (pg *pg) ExportNDJSON(ctx context.Context, columnName string, w io.Writer) (int64, error) {
+	ctx, err := pg.enter(ctx)
+	if err != nil {
+		return 0, err
+	}
+	rows, err := pg.queryer(ctx).QueryxContext(ctx, pg.q(ctx).stream, columnName)
+	if err != nil {
+		return 0, err
+	}
+	defer rows.Close()
+
+	var (
+		num int64
+		enc = json.NewEncoder(w)
+	)
+	for rows.Next() {
+		var c cell
+		if err := rows.StructScan(&c); err != nil {
+			return num, err
+		}
+		data, err := pg.unseal(c)
+		if err != nil {
+			return num, err
+		}
+		rec := ndjsonRecord{
+			RowId:      c.RowId,
+			ColumnName: c.ColumnName,
+			Version:    c.Version,
+			Data:       data,
+			CreatedAt:  c.CreatedAt,
+			UpdatedAt:  c.UpdatedAt,
+		}
+		if c.Format.Valid && c.Format.String != JSONFormat {
+			rec.Format = c.Format.String
+			if rec.Data, err = json.Marshal(data); err != nil {
+				return num, err
+			}
+		}
+		if err := enc.Encode(rec); err != nil {
+			return num, err
+		}
+		num++
+	}
+	return num, rows.Err()
+}
+
+// Upsert cells of NDJSON written by ExportNDJSON, compressed and encrypted as configured.
+// Cells already stored are overwritten bumping their version. Returns number of imported lines,
+// lines before failing one stay imported unless ctx carries transaction
+func (pg *pg) ImportNDJSON(ctx context.Context, r io.Reader) (int64, error) {
+	ctx, err := pg.enter(ctx)
+	if err != nil {
+		return 0, err
+	}
+	var (
+		num int64
+		dec = json.NewDecoder(r)
+	)
+	for {
+		var rec ndjsonRecord
+		if err := dec.Decode(&rec); errors.Is(err, io.EOF) {
+			return num, nil
+		} else if err != nil {
+			return num, fmt.Errorf("line %d: %w", num+1, err)
+		}
+		data, format, err := rec.payload()
+		if err != nil {
+			return num, fmt.Errorf("line %d: %w", num+1, err)
+		} else if err := validateRef(rec.ref(), AddChangeType); err != nil {
+			return num, fmt.Errorf("line %d: %w", num+1, err)
+		}
+		p, err := pg.seal(data, format)
+		if err != nil {
+			return num, err
+		}
+		ref := rec.ref()
+		var version uint
+		if err := pg.writer(ctx).QueryRowxContext(ctx, pg.q(ctx).upsert,
+			rec.RowId,
+			rec.ColumnName,
+			rec.Version,
+			p.data,
+			format,
+			p.compressed,
+			p.keyId,
+			rec.CreatedAt,
+			rec.UpdatedAt).Scan(&version); err != nil {
+			return num, wrapErr("import", ref, err)
+		}
+		num++
+	}
+}
+
+func (rec ndjsonRecord) ref() Ref {
+	return Ref{RowId: rec.RowId, ColumnName: rec.ColumnName, Version: rec.Version, CreatedAt: rec.CreatedAt, UpdatedAt: rec.UpdatedAt}
+}
+
+// Stored data and format of record
+func (rec ndjsonRecord) payload() ([]byte, string, error) {
+	if rec.RowId == "" || rec.ColumnName == "" {
+		return nil, "", errors.New("model: record without row and column")
+	} else if rec.Format == "" || rec.Format == JSONFormat {
+		return rec.Data, JSONFormat, nil
+	}
+	var data []byte
+	if err := json.Unmarshal(rec.Data, &data); err != nil {
+		return nil, "", err
+	}
+	return data, rec.Format, nil
+}
@@ -0,0 +1,53 @@
+package active
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+)
+
+// Payload of change notification
+type changeNotice struct {
+	RowId      string `json:"row_id"`
+	ColumnName string `json:"column_name"`
+	Version    uint   `json:"version"`
+	ChangeType string `json:"change_type"`
+}
+
+// Notify channel about every applied change with pg_notify, payload is JSON with row_id, column_name,
+// resulting version and change_type. Notifications are sent within transaction, so listeners get them
+// only once it commits
+func WithNotify(channel string) Option {
+	return func(p *pg) {
+		if channel == "" {
+			p.fail(fmt.Errorf("%w: empty notify channel", ErrInvalidOption))
+		} else {
+			p.notifyChannel = channel
+		}
+	}
+}
+
+func (pg *pg) notify(ctx context.Context, tx execer, changes []Change) error {
+	if pg.notifyChannel == "" {
+		return nil
+	}
+	for _, change := range changes {
+		version := change.V.Ref.Version
+		if change.T == UpdateChangeType {
+			version++
+		}
+		payload, err := json.Marshal(changeNotice{
+			RowId:      change.V.Ref.RowId,
+			ColumnName: change.V.Ref.ColumnName,
+			Version:    version,
+			ChangeType: change.T.String(),
+		})
+		if err != nil {
+			return err
+		}
+		if _, err := tx.ExecContext(ctx, pg.q(ctx).notify, pg.notifyChannel, string(payload)); err != nil {
+			return wrapErr("notify", change.V.Ref, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,93 @@
+package active
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+type (
+	// Store configuration
+	Option func(*pg)
+
+	// Observer of changes applied within transaction
+	Logger interface {
+		// Called after each change statement with its result
+		LogChange(ctx context.Context, c Change, err error)
+	}
+
+	nopLogger struct{}
+)
+
+var (
+	// Option given to New is invalid, reported by Ping and every operation of store
+	ErrInvalidOption = errors.New("model: invalid option")
+)
+
+// Record invalid option, store keeps reporting it instead of running operations
+func (p *pg) fail(err error) {
+	p.err = errors.Join(p.err, err)
+}
+
+// Report applied changes to logger
+func WithLogger(l Logger) Option {
+	return func(p *pg) {
+		if l != nil {
+			p.logger = l
+		}
+	}
+}
+
+func (nopLogger) LogChange(context.Context, Change, error) {}
+
+// UUID version of action log ids, 4 (random, default) or 7 (time ordered, better index locality)
+func WithUUIDVersion(version int) Option {
+	var gen func() (uuid.UUID, error)
+	switch version {
+	case 4:
+		gen = uuid.NewRandom
+	case 7:
+		gen = uuid.NewV7
+	}
+	return func(p *pg) {
+		if gen == nil {
+			p.fail(fmt.Errorf("%w: unsupported uuid version %d", ErrInvalidOption, version))
+		} else {
+			p.newID = gen
+		}
+	}
+}
+
+// Take current time from clock instead of time.Now, so tests can pin stored timestamps.
+// Times are converted to UTC before they are stored
+func WithClock(now func() time.Time) Option {
+	return func(p *pg) {
+		if now != nil {
+			p.now = now
+		}
+	}
+}
+
+// Trace transactions and statements with tracer
+func WithTracer(t trace.Tracer) Option {
+	return func(p *pg) {
+		if t != nil {
+			p.tracer = t
+		}
+	}
+}
+
+func endSpan(span trace.Span, err error) {
+	if errors.Is(err, ErrOptimisticLock) {
+		span.SetStatus(codes.Error, "optimistic lock: row version changed concurrently")
+	} else if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
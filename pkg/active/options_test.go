@@ -0,0 +1,92 @@
+package active
+
+import (
+	"context"
+	"sync"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/jmoiron/sqlx/types"
+	"go.opentelemetry.io/otel/trace"
+)
+
+type (
+	// Tracer recording started spans as "parent>name", parent empty for root spans
+	recordingTracer struct {
+		mu    sync.Mutex
+		spans []string
+	}
+
+	namedSpan struct {
+		trace.Span
+		name string
+	}
+)
+
+func (r *recordingTracer) Start(ctx context.Context, name string, _ ...trace.SpanStartOption) (context.Context, trace.Span) {
+	parent := ""
+	if s, ok := trace.SpanFromContext(ctx).(*namedSpan); ok {
+		parent = s.name
+	}
+	r.mu.Lock()
+	r.spans = append(r.spans, parent+">"+name)
+	r.mu.Unlock()
+	span := &namedSpan{Span: trace.SpanFromContext(context.Background()), name: name}
+	return trace.ContextWithSpan(ctx, span), span
+}
+
+func TestTransactionSpans(t *testing.T) {
+	tests := []struct {
+		name   string
+		expect func(mock sqlmock.Sqlmock)
+		run    func(store *pg, e *Entity) error
+		spans  []string
+	}{
+		{
+			name: "change span is child of transaction",
+			expect: func(mock sqlmock.Sqlmock) {
+				mock.ExpectBegin()
+				mock.ExpectPrepare(`INSERT INTO models`).ExpectExec().WillReturnResult(sqlmock.NewResult(0, 1))
+				mock.ExpectCommit()
+			},
+			run: func(store *pg, e *Entity) error {
+				return store.Save(context.Background(), e)
+			},
+			spans: []string{">active.tx", "active.tx>active.add"},
+		},
+		{
+			name: "nested transaction span is child of outer one",
+			expect: func(mock sqlmock.Sqlmock) {
+				mock.ExpectBegin()
+				mock.ExpectExec(`SAVEPOINT active_sp_1`).WillReturnResult(sqlmock.NewResult(0, 0))
+				mock.ExpectPrepare(`INSERT INTO models`).ExpectExec().WillReturnResult(sqlmock.NewResult(0, 1))
+				mock.ExpectExec(`RELEASE SAVEPOINT active_sp_1`).WillReturnResult(sqlmock.NewResult(0, 0))
+				mock.ExpectCommit()
+			},
+			run: func(store *pg, e *Entity) error {
+				return store.InTx(context.Background(), func(ctx context.Context) error {
+					return store.Save(ctx, e)
+				})
+			},
+			spans: []string{">active.tx", "active.tx>active.tx", "active.tx>active.add"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tracer := &recordingTracer{}
+			store, mock := newMockStore(t, WithTracer(tracer))
+			tt.expect(mock)
+			e := &Entity{Model: &RawModel{Data: types.JSONText(`{}`)}, Ref: Ref{RowId: "r1", ColumnName: "doc"}}
+			if err := tt.run(store, e); err != nil {
+				t.Fatal(err)
+			} else if len(tracer.spans) != len(tt.spans) {
+				t.Fatalf("expected spans %v, got %v", tt.spans, tracer.spans)
+			}
+			for i := range tt.spans {
+				if tracer.spans[i] != tt.spans[i] {
+					t.Fatalf("expected spans %v, got %v", tt.spans, tracer.spans)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,349 @@
+package active
+
+import (
+	"context"
+	"errors"
+	"os"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jmoiron/sqlx"
+	"github.com/jmoiron/sqlx/types"
+	_ "github.com/lib/pq"
+)
+
+// Stores the parity suite runs against, Postgres one only when ACTIVE_TEST_DSN points to a database
+func parityStores(t *testing.T) map[string]func(t *testing.T, opts ...Option) Store {
+	stores := map[string]func(t *testing.T, opts ...Option) Store{
+		"mem": func(t *testing.T, opts ...Option) Store {
+			return NewMemStore(opts...)
+		},
+	}
+	dsn := os.Getenv("ACTIVE_TEST_DSN")
+	if dsn == "" {
+		return stores
+	}
+	stores["pg"] = func(t *testing.T, opts ...Option) Store {
+		db, err := sqlx.Open("postgres", dsn)
+		if err != nil {
+			t.Fatal(err)
+		}
+		store := New(db, append([]Option{WithTruncate(true)}, opts...)...)
+		ctx := context.Background()
+		if err := store.EnsureSchema(ctx); errors.Is(err, ErrInvalidOption) {
+			return store
+		} else if err != nil {
+			t.Fatal(err)
+		} else if err := store.Truncate(ctx, defaultTable, defaultActionTable, defaultVersionsTable, defaultFingerprintTable); err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() {
+			store.Close(context.Background())
+		})
+		return store
+	}
+	return stores
+}
+
+func rawEntity(rowId, data string) *Entity {
+	return &Entity{Model: &RawModel{Data: types.JSONText(data)}, Ref: Ref{RowId: rowId, ColumnName: "doc"}}
+}
+
+func TestStoreParity(t *testing.T) {
+	clock := time.Date(2024, 3, 1, 12, 30, 0, 123456789, time.FixedZone("CET", 3600))
+	tests := []struct {
+		name string
+		opts []Option
+		run  func(t *testing.T, store Store)
+	}{
+		{
+			name: "history is off by default",
+			run: func(t *testing.T, store Store) {
+				ctx := context.Background()
+				e := rawEntity("r1", `{"a":1}`)
+				mustSave(t, store, e)
+				e.Model = &RawModel{Data: types.JSONText(`{"a":2}`)}
+				mustSave(t, store, e)
+				if arr, err := store.History(ctx, "r1", "doc"); err != nil {
+					t.Fatal(err)
+				} else if len(arr) != 0 {
+					t.Fatalf("expected no history, got %d versions", len(arr))
+				}
+			},
+		},
+		{
+			name: "versioning keeps overwritten version",
+			opts: []Option{WithVersioning(true)},
+			run: func(t *testing.T, store Store) {
+				ctx := context.Background()
+				e := rawEntity("r1", `{"a":1}`)
+				mustSave(t, store, e)
+				e.Model = &RawModel{Data: types.JSONText(`{"a":2}`)}
+				mustSave(t, store, e)
+				if arr, err := store.History(ctx, "r1", "doc"); err != nil {
+					t.Fatal(err)
+				} else if len(arr) != 1 || arr[0].Version != 0 {
+					t.Fatalf("expected version 0 in history, got %+v", arr)
+				}
+			},
+		},
+		{
+			name: "timestamps are UTC microseconds",
+			opts: []Option{WithClock(func() time.Time { return clock })},
+			run: func(t *testing.T, store Store) {
+				ctx := context.Background()
+				want := clock.UTC().Truncate(time.Microsecond)
+				e := rawEntity("r1", `{}`)
+				mustSave(t, store, e)
+				if !e.Ref.CreatedAt.Equal(want) || e.Ref.CreatedAt.Location() != time.UTC {
+					t.Fatalf("expected created at %v, got %v", want, e.Ref.CreatedAt)
+				}
+				if err := store.LogAction(ctx, "noop", Params{}); err != nil {
+					t.Fatal(err)
+				}
+				arr, _, err := store.Actions(ctx, 1, "")
+				if err != nil {
+					t.Fatal(err)
+				} else if len(arr) != 1 || !arr[0].CreatedAt.Equal(want) {
+					t.Fatalf("expected action logged at %v, got %+v", want, arr)
+				}
+			},
+		},
+		{
+			name: "actions logged at the same time page through",
+			opts: []Option{WithClock(func() time.Time { return clock })},
+			run: func(t *testing.T, store Store) {
+				ctx := context.Background()
+				for i := 0; i < 5; i++ {
+					if err := store.LogAction(ctx, "noop", Params{}); err != nil {
+						t.Fatal(err)
+					}
+				}
+				seen := map[string]bool{}
+				for cursor, pages := "", 0; pages == 0 || cursor != ""; pages++ {
+					arr, next, err := store.Actions(ctx, 2, cursor)
+					if err != nil {
+						t.Fatal(err)
+					}
+					for _, a := range arr {
+						if seen[a.RowId] {
+							t.Fatalf("action %s listed twice", a.RowId)
+						}
+						seen[a.RowId] = true
+					}
+					cursor = next
+				}
+				if len(seen) != 5 {
+					t.Fatalf("expected 5 actions, got %d", len(seen))
+				}
+			},
+		},
+		{
+			name: "nil matches JSON null only",
+			run: func(t *testing.T, store Store) {
+				ctx := context.Background()
+				mustSave(t, store, rawEntity("null", `{"email":null}`))
+				mustSave(t, store, rawEntity("text", `{"email":"<nil>"}`))
+				mustSave(t, store, rawEntity("missing", `{}`))
+				arr, err := store.FindByJSON(ctx, "doc", "email", nil, func() Model { return &RawModel{} })
+				if err != nil {
+					t.Fatal(err)
+				} else if len(arr) != 1 || arr[0].Ref.RowId != "null" {
+					t.Fatalf("expected only null row, got %d rows", len(arr))
+				}
+				if num, err := store.DeleteWhere(ctx, "doc", "email", nil); err != nil {
+					t.Fatal(err)
+				} else if num != 1 {
+					t.Fatalf("expected 1 deleted row, got %d", num)
+				}
+			},
+		},
+		{
+			name: "list without factory returns raw models",
+			run: func(t *testing.T, store Store) {
+				mustSave(t, store, rawEntity("r1", `{"a":1}`))
+				arr, _, err := store.List(context.Background(), "doc", ListOptions{})
+				if err != nil {
+					t.Fatal(err)
+				} else if len(arr) != 1 {
+					t.Fatalf("expected 1 entity, got %d", len(arr))
+				} else if m, ok := arr[0].Model.(*RawModel); !ok || string(m.Data) != `{"a":1}` {
+					t.Fatalf("expected raw model, got %#v", arr[0].Model)
+				}
+			},
+		},
+		{
+			name: "action ids follow uuid version",
+			opts: []Option{WithUUIDVersion(7)},
+			run: func(t *testing.T, store Store) {
+				ctx := context.Background()
+				if err := store.LogAction(ctx, "noop", Params{}); err != nil {
+					t.Fatal(err)
+				}
+				arr, _, err := store.Actions(ctx, 1, "")
+				if err != nil {
+					t.Fatal(err)
+				} else if len(arr) != 1 || uuid.MustParse(arr[0].RowId).Version() != 7 {
+					t.Fatalf("expected uuid v7 action id, got %+v", arr)
+				}
+			},
+		},
+		{
+			name: "invalid option is reported",
+			opts: []Option{WithUUIDVersion(5)},
+			run: func(t *testing.T, store Store) {
+				if err := store.Ping(context.Background()); !errors.Is(err, ErrInvalidOption) {
+					t.Fatalf("expected ErrInvalidOption, got %v", err)
+				}
+			},
+		},
+		{
+			name: "unrelated store call within transaction",
+			run: func(t *testing.T, store Store) {
+				mustSave(t, store, rawEntity("r2", `{}`))
+				done := make(chan error, 1)
+				go func() {
+					done <- store.WithTx(context.Background(), func(tx Tx) error {
+						if err := tx.Add(context.Background(), rawEntity("r1", `{}`)); err != nil {
+							return err
+						} else if _, err := store.Load(context.Background(), &RawModel{}, "r2", "doc"); err != nil {
+							return err
+						}
+						return store.Save(context.Background(), rawEntity("r3", `{}`))
+					})
+				}()
+				select {
+				case err := <-done:
+					if err != nil {
+						t.Fatal(err)
+					}
+				case <-time.After(5 * time.Second):
+					t.Fatal("store call within transaction did not return")
+				}
+			},
+		},
+		{
+			name: "creator committed meanwhile is loaded",
+			run: func(t *testing.T, store Store) {
+				e, created, err := store.LoadOrCreate(context.Background(), &RawModel{}, "r1", "doc", func() Model {
+					mustSave(t, store, rawEntity("r1", `{"a":1}`))
+					return &RawModel{Data: types.JSONText(`{"a":2}`)}
+				})
+				if err != nil {
+					t.Fatal(err)
+				} else if created {
+					t.Fatal("expected cell of concurrent creator, got created")
+				} else if data := string(e.Model.(*RawModel).Data); data != `{"a":1}` {
+					t.Fatalf("expected data of concurrent creator, got %s", data)
+				}
+			},
+		},
+		{
+			name: "soft deleted cell is not created again",
+			run: func(t *testing.T, store Store) {
+				ctx := context.Background()
+				e := rawEntity("r1", `{}`)
+				mustSave(t, store, e)
+				if err := store.SoftDelete(ctx, e); err != nil {
+					t.Fatal(err)
+				}
+				_, _, err := store.LoadOrCreate(ctx, &RawModel{}, "r1", "doc", func() Model {
+					return &RawModel{Data: types.JSONText(`{}`)}
+				})
+				if !errors.Is(err, ErrNotFound) {
+					t.Fatalf("expected %v, got %v", ErrNotFound, err)
+				}
+			},
+		},
+		{
+			name: "concurrent updates of cell conflict",
+			run: func(t *testing.T, store Store) {
+				ctx := context.Background()
+				mustSave(t, store, rawEntity("r1", `{}`))
+				loaded := make(chan struct{}, 2)
+				proceed := make(chan struct{})
+				errs := make([]error, 2)
+				var wg sync.WaitGroup
+				for i := range errs {
+					wg.Add(1)
+					go func(i int) {
+						defer wg.Done()
+						errs[i] = store.WithTx(ctx, func(tx Tx) error {
+							e, err := tx.Load(ctx, &RawModel{}, "r1", "doc")
+							if err != nil {
+								return err
+							}
+							loaded <- struct{}{}
+							<-proceed
+							return tx.Update(ctx, e)
+						})
+					}(i)
+				}
+				<-loaded
+				<-loaded
+				close(proceed)
+				wg.Wait()
+				failed := 0
+				for _, err := range errs {
+					if errors.Is(err, ErrOptimisticLock) {
+						failed++
+					} else if err != nil {
+						t.Fatal(err)
+					}
+				}
+				if failed != 1 {
+					t.Fatalf("expected exactly one update to fail, got %v", errs)
+				}
+			},
+		},
+		{
+			name: "locked cell is updated in turn",
+			run: func(t *testing.T, store Store) {
+				ctx := context.Background()
+				mustSave(t, store, rawEntity("r1", `{}`))
+				var wg sync.WaitGroup
+				errs := make([]error, 4)
+				for i := range errs {
+					wg.Add(1)
+					go func(i int) {
+						defer wg.Done()
+						errs[i] = store.WithTx(ctx, func(tx Tx) error {
+							e, err := store.LoadForUpdate(ctx, tx, &RawModel{}, "r1", "doc")
+							if err != nil {
+								return err
+							}
+							return tx.Update(ctx, e)
+						})
+					}(i)
+				}
+				wg.Wait()
+				for _, err := range errs {
+					if err != nil {
+						t.Fatal(err)
+					}
+				}
+				if e, err := store.Load(ctx, &RawModel{}, "r1", "doc"); err != nil {
+					t.Fatal(err)
+				} else if e.Ref.Version != uint(len(errs)) {
+					t.Fatalf("expected version %d, got %d", len(errs), e.Ref.Version)
+				}
+			},
+		},
+	}
+	for name, newStore := range parityStores(t) {
+		for _, tt := range tests {
+			t.Run(name+"/"+tt.name, func(t *testing.T) {
+				tt.run(t, newStore(t, tt.opts...))
+			})
+		}
+	}
+}
+
+func mustSave(t *testing.T, store Store, e *Entity) {
+	t.Helper()
+	if err := store.Save(context.Background(), e); err != nil {
+		t.Fatal(err)
+	}
+}
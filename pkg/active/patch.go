@@ -0,0 +1,43 @@
+package active
+
+import (
+	"context"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// Merge patch into top level fields of stored JSON data server side (jsonb ||), bumping version
+// under optimistic lock. Cells stored compressed, encrypted or in other than JSON format are not
+// matched and reported as ErrOptimisticLock. Store writing data of other codec, compressed or encrypted
+// refuses patches with ErrOpaqueData
+func (pg *pg) PatchJSON(ctx context.Context, ref Ref, patch map[string]any) error {
+	if err := validateRef(ref, UpdateChangeType); err != nil {
+		return err
+	} else if err := pg.checkPlainJSON(); err != nil {
+		return err
+	}
+	doc, err := pg.json.marshal(patch)
+	if err != nil {
+		return err
+	}
+	now := pg.timestamp()
+	return pg.inTx(ctx, func(ctx context.Context, tx *sqlx.Tx) error {
+		if pg.versioning {
+			if err := pg.archive(ctx, tx, &Entity{Ref: ref}); err != nil {
+				return err
+			}
+		}
+		if r, err := tx.ExecContext(ctx, pg.q(ctx).patch,
+			string(doc),
+			ref.Version+1,
+			now,
+			ref.RowId,
+			ref.ColumnName,
+			ref.Version); err != nil {
+			return wrapErr("patch", ref, err)
+		} else if err := pg.expectOne(ctx, r, ref); err != nil {
+			return wrapErr("patch", ref, err)
+		}
+		return nil
+	})
+}
@@ -0,0 +1,29 @@
+package active
+
+import (
+	"context"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// Check primary database is reachable, invalid option store was created with is reported first
+func (pg *pg) Ping(ctx context.Context) error {
+	if pg.err != nil {
+		return pg.err
+	}
+	ctx, cancel := pg.withDefaultTimeout(ctx)
+	defer cancel()
+	return pg.db.PingContext(ctx)
+}
+
+// Create Postgres backed store making sure database answers within timeout
+func NewWithPing(ctx context.Context, db *sqlx.DB, timeout time.Duration, opts ...Option) (Store, error) {
+	store := New(db, opts...)
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	if err := store.Ping(ctx); err != nil {
+		return nil, err
+	}
+	return store, nil
+}
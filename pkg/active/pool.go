@@ -0,0 +1,41 @@
+package active
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+)
+
+type (
+	// Connection pool limits of primary and replica databases, zero value keeps database/sql default
+	PoolOptions struct {
+		MaxOpenConns    int
+		MaxIdleConns    int
+		ConnMaxLifetime time.Duration
+	}
+)
+
+// Tune connection pools of databases store is created with. Idle limit exceeding open one
+// is ErrInvalidOption
+func WithPool(o PoolOptions) Option {
+	return func(p *pg) {
+		if o.MaxOpenConns > 0 && o.MaxIdleConns > o.MaxOpenConns {
+			p.fail(fmt.Errorf("%w: max idle connections %d exceed max open %d", ErrInvalidOption, o.MaxIdleConns, o.MaxOpenConns))
+		} else {
+			p.pool = &o
+		}
+	}
+}
+
+func (o *PoolOptions) apply(db *sqlx.DB) {
+	if o.MaxOpenConns > 0 {
+		db.SetMaxOpenConns(o.MaxOpenConns)
+	}
+	if o.MaxIdleConns > 0 {
+		db.SetMaxIdleConns(o.MaxIdleConns)
+	}
+	if o.ConnMaxLifetime > 0 {
+		db.SetConnMaxLifetime(o.ConnMaxLifetime)
+	}
+}
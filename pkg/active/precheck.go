@@ -0,0 +1,96 @@
+package active
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// Updates and deletes of batch expected versions no longer stored
+type VersionConflictError struct {
+	Keys []Key
+}
+
+// Check versions of updated and deleted entities with single query before batch statements run,
+// failing fast with VersionConflictError listing every stale cell. Not done when conflict resolver is set
+func WithVersionPrecheck() Option {
+	return func(p *pg) {
+		p.versionPrecheck = true
+	}
+}
+
+func (e *VersionConflictError) Error() string {
+	return fmt.Sprintf("model: %d stale cells, first %s: optimistic lock", len(e.Keys), e.Keys[0])
+}
+
+func (e *VersionConflictError) Unwrap() error {
+	return ErrOptimisticLock
+}
+
+// Keys of refs whose stored version differs from Ref.Version, missing and soft deleted cells included,
+// in order of refs
+func (pg *pg) PrecheckVersions(ctx context.Context, refs []Ref) ([]Key, error) {
+	ctx, err := pg.enter(ctx)
+	if err != nil {
+		return nil, err
+	}
+	ctx, cancel := pg.withDefaultTimeout(ctx)
+	defer cancel()
+	return pg.staleKeys(ctx, pg.queryer(ctx), refs)
+}
+
+func (pg *pg) staleKeys(ctx context.Context, q sqlx.QueryerContext, refs []Ref) ([]Key, error) {
+	keys := make([]Key, len(refs))
+	for i, ref := range refs {
+		keys[i] = ref.Key()
+	}
+	chunks, err := pg.keyChunks(keys)
+	if err != nil {
+		return nil, err
+	}
+	stored := make(map[Key]uint, len(keys))
+	for _, chunk := range chunks {
+		var cells []cell
+		query, args := pg.keysQuery(pg.q(ctx).versions, chunk)
+		if err := sqlx.SelectContext(ctx, q, &cells, query, args...); err != nil {
+			return nil, err
+		}
+		for _, c := range cells {
+			stored[c.toRef().Key()] = c.Version
+		}
+	}
+	var stale []Key
+	for i, ref := range refs {
+		if version, ok := stored[keys[i]]; !ok || version != ref.Version {
+			stale = append(stale, keys[i])
+		}
+	}
+	return stale, nil
+}
+
+// Fail with VersionConflictError when any update or delete of changes is stale. Cell changed by earlier
+// change of the same batch, e.g. added then updated, has no stored version to check yet
+func (pg *pg) precheck(ctx context.Context, q sqlx.QueryerContext, changes []Change) error {
+	if !pg.versionPrecheck || pg.resolver != nil {
+		return nil
+	}
+	var refs []Ref
+	touched := make(map[Key]struct{}, len(changes))
+	for _, change := range changes {
+		key := change.V.Ref.Key()
+		if _, ok := touched[key]; !ok && change.T != AddChangeType {
+			refs = append(refs, change.V.Ref)
+		}
+		touched[key] = struct{}{}
+	}
+	if len(refs) == 0 {
+		return nil
+	}
+	if stale, err := pg.staleKeys(ctx, q, refs); err != nil {
+		return err
+	} else if len(stale) > 0 {
+		return &VersionConflictError{Keys: stale}
+	}
+	return nil
+}
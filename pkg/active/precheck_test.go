@@ -0,0 +1,117 @@
+package active
+
+import (
+	"context"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/jmoiron/sqlx/types"
+)
+
+func TestVersionPrecheck(t *testing.T) {
+	created := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	entity := func(rowId string, version uint) *Entity {
+		return &Entity{Model: &RawModel{Data: types.JSONText(`{}`)}, Ref: Ref{RowId: rowId, ColumnName: "doc", Version: version, CreatedAt: created, UpdatedAt: created}}
+	}
+	tests := []struct {
+		name    string
+		batch   func() *Batch
+		checked []string
+		stored  map[string]uint
+		stale   []string
+	}{
+		{
+			name: "stale and missing cells are listed",
+			batch: func() *Batch {
+				return NewBatch().Update(entity("r1", 1)).Update(entity("r2", 1)).Update(entity("r3", 1))
+			},
+			checked: []string{"r1", "r2", "r3"},
+			stored:  map[string]uint{"r1": 1, "r2": 2},
+			stale:   []string{"r2", "r3"},
+		},
+		{
+			name: "deletes are checked",
+			batch: func() *Batch {
+				return NewBatch().Update(entity("r1", 1)).Delete(entity("r2", 1))
+			},
+			checked: []string{"r1", "r2"},
+			stored:  map[string]uint{"r1": 1, "r2": 4},
+			stale:   []string{"r2"},
+		},
+		{
+			name: "cell added earlier in batch is not checked",
+			batch: func() *Batch {
+				return NewOrderedBatch().Add(entity("r1", 0)).Update(entity("r1", 0)).Update(entity("r2", 1))
+			},
+			checked: []string{"r2"},
+			stored:  map[string]uint{"r2": 3},
+			stale:   []string{"r2"},
+		},
+		{
+			name: "cell updated twice is checked by first update",
+			batch: func() *Batch {
+				return NewOrderedBatch().Update(entity("r1", 1)).Update(entity("r1", 2)).Update(entity("r2", 1))
+			},
+			checked: []string{"r1", "r2"},
+			stored:  map[string]uint{"r1": 1, "r2": 3},
+			stale:   []string{"r2"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store, mock := newMockStore(t, WithVersionPrecheck())
+			var (
+				placeholders string
+				args         []driver.Value
+			)
+			for i, rowId := range tt.checked {
+				if i > 0 {
+					placeholders += ", "
+				}
+				placeholders += fmt.Sprintf(`\(\$%d, \$%d\)`, 2*i+1, 2*i+2)
+				args = append(args, rowId, "doc")
+			}
+			rows := sqlmock.NewRows([]string{"row_id", "column_name", "version"})
+			for _, rowId := range tt.checked {
+				if version, ok := tt.stored[rowId]; ok {
+					rows.AddRow(rowId, "doc", version)
+				}
+			}
+			mock.ExpectBegin()
+			mock.ExpectQuery(`SELECT row_id, column_name, version FROM models WHERE deleted_at IS NULL AND \(row_id, column_name\) IN \(` + placeholders + `\)$`).
+				WithArgs(args...).WillReturnRows(rows)
+			mock.ExpectRollback()
+
+			err := store.ApplyChangesContext(context.Background(), *tt.batch())
+			var conflict *VersionConflictError
+			if !errors.As(err, &conflict) || !errors.Is(err, ErrOptimisticLock) {
+				t.Fatalf("expected version conflict, got %v", err)
+			}
+			if len(conflict.Keys) != len(tt.stale) {
+				t.Fatalf("expected stale %v, got %v", tt.stale, conflict.Keys)
+			}
+			for i, rowId := range tt.stale {
+				if conflict.Keys[i] != (Key{RowId: rowId, ColumnName: "doc"}) {
+					t.Fatalf("expected stale %v, got %v", tt.stale, conflict.Keys)
+				}
+			}
+		})
+	}
+}
+
+func TestVersionPrecheckPasses(t *testing.T) {
+	store, mock := newMockStore(t, WithVersionPrecheck())
+	mock.ExpectBegin()
+	mock.ExpectQuery(`SELECT row_id, column_name, version FROM models`).
+		WillReturnRows(sqlmock.NewRows([]string{"row_id", "column_name", "version"}).AddRow("r1", "doc", 1))
+	mock.ExpectPrepare(`DELETE FROM models`).ExpectExec().WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+	e := &Entity{Model: &RawModel{Data: types.JSONText(`{}`)}, Ref: Ref{RowId: "r1", ColumnName: "doc", Version: 1}}
+	if err := store.ApplyChangesContext(context.Background(), *NewBatch().Delete(e)); err != nil {
+		t.Fatal(err)
+	}
+}
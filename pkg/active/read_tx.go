@@ -0,0 +1,73 @@
+package active
+
+import (
+	"context"
+	"database/sql"
+
+	"github.com/jmoiron/sqlx"
+)
+
+type (
+	// Reads of store sharing single snapshot
+	ReadStore interface {
+		Load(ctx context.Context, m Model, rowId, columnName string) (*Entity, error)
+
+		LoadMany(ctx context.Context, keys []Key, factory func(Key) Model) (map[Key]*Entity, error)
+
+		Count(ctx context.Context, columnName string) (int64, error)
+
+		CountByRow(ctx context.Context, rowId string) (int64, error)
+
+		Exists(ctx context.Context, rowId, columnName string) (bool, error)
+	}
+
+	// ReadStore running every call in transaction of state
+	readTx struct {
+		pg    *pg
+		state *txState
+		hooks *commitHooks
+		route *route
+	}
+)
+
+// Run fn in read only repeatable read transaction, so all reads made through ReadStore see same snapshot.
+// Within transaction already bound to ctx the reads join it and its isolation applies
+func (pg *pg) InReadTx(ctx context.Context, fn func(ReadStore) error) error {
+	return pg.inTxOpts(ctx, []TxOption{WithIsolation(sql.LevelRepeatableRead), ReadOnly()}, func(ctx context.Context, tx *sqlx.Tx) error {
+		state := txFromContext(ctx)
+		if state == nil {
+			state = &txState{tx: tx}
+		}
+		return fn(&readTx{pg: pg, state: state, route: pg.routeOf(ctx)})
+	})
+}
+
+func (r *readTx) bind(ctx context.Context) context.Context {
+	if r.hooks != nil {
+		ctx = context.WithValue(ctx, commitKey{}, r.hooks)
+	}
+	if r.route != nil {
+		ctx = context.WithValue(ctx, routeKey{r.pg}, r.route)
+	}
+	return context.WithValue(ctx, txKey{}, r.state)
+}
+
+func (r *readTx) Load(ctx context.Context, m Model, rowId, columnName string) (*Entity, error) {
+	return r.pg.Load(r.bind(ctx), m, rowId, columnName)
+}
+
+func (r *readTx) LoadMany(ctx context.Context, keys []Key, factory func(Key) Model) (map[Key]*Entity, error) {
+	return r.pg.LoadMany(r.bind(ctx), keys, factory)
+}
+
+func (r *readTx) Count(ctx context.Context, columnName string) (int64, error) {
+	return r.pg.Count(r.bind(ctx), columnName)
+}
+
+func (r *readTx) CountByRow(ctx context.Context, rowId string) (int64, error) {
+	return r.pg.CountByRow(r.bind(ctx), rowId)
+}
+
+func (r *readTx) Exists(ctx context.Context, rowId, columnName string) (bool, error) {
+	return r.pg.Exists(r.bind(ctx), rowId, columnName)
+}
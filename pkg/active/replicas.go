@@ -0,0 +1,26 @@
+package active
+
+import (
+	"github.com/jmoiron/sqlx"
+)
+
+// Create Postgres backed store serving reads from replicas in round robin, writes always go to primary
+func NewWithReplicas(primary *sqlx.DB, replicas ...*sqlx.DB) Store {
+	return New(primary, WithReplicas(replicas...))
+}
+
+// Serve read queries from replicas in round robin
+func WithReplicas(replicas ...*sqlx.DB) Option {
+	return func(p *pg) {
+		p.replicas = append(p.replicas, replicas...)
+	}
+}
+
+// Database for read only queries, primary when there are no replicas
+func (pg *pg) reader() *sqlx.DB {
+	if len(pg.replicas) == 0 {
+		return pg.db
+	}
+	n := pg.nextReplica.Add(1)
+	return pg.replicas[(n-1)%uint64(len(pg.replicas))]
+}
@@ -0,0 +1,77 @@
+package active
+
+import (
+	"context"
+	"errors"
+	"reflect"
+)
+
+type (
+	// Model aware of reference it was loaded with, SetReference gets stored reference once saved
+	Referenced interface {
+		Reference() Ref
+
+		SetReference(ref Ref)
+	}
+
+	// Typed access to models of single type.
+	// Only pointer types are supported as T, since Unmarshall has to populate the receiver,
+	// other types fail with ErrNotPointer
+	Repo[T Model] struct {
+		store Store
+	}
+)
+
+var (
+	ErrNoReference = errors.New("model: reference unknown")
+
+	// Repo of model type other than pointer
+	ErrNotPointer = errors.New("model: repo type must be pointer")
+)
+
+// Create typed repository over store
+func NewRepo[T Model](store Store) *Repo[T] {
+	return &Repo[T]{store: store}
+}
+
+// Load model by row and column
+func (r *Repo[T]) Load(ctx context.Context, rowId, columnName string) (T, error) {
+	var zero T
+	m, err := newModel[T]()
+	if err != nil {
+		return zero, err
+	} else if _, err := r.store.Load(ctx, m, rowId, columnName); err != nil {
+		return zero, err
+	}
+	return m, nil
+}
+
+// Insert model never stored before or update it otherwise, model must implement Referenced.
+// Stored reference with new version is passed to SetReference once saved
+func (r *Repo[T]) Save(ctx context.Context, m T) error {
+	aRef, ok := any(m).(Referenced)
+	if reflect.TypeOf(&m).Elem().Kind() != reflect.Ptr {
+		return ErrNotPointer
+	} else if !ok {
+		return ErrNoReference
+	}
+	e := &Entity{Model: m, Ref: aRef.Reference()}
+	if err := r.store.Save(ctx, e); err != nil {
+		return err
+	}
+	// store updates e.Ref once transaction commits, reference follows it
+	onCommit(ctx, func() {
+		aRef.SetReference(e.Ref)
+	})
+	return nil
+}
+
+// Allocate model, fresh value behind pointer
+func newModel[T Model]() (T, error) {
+	var m T
+	if t := reflect.TypeOf(&m).Elem(); t.Kind() != reflect.Ptr {
+		return m, ErrNotPointer
+	} else {
+		return reflect.New(t.Elem()).Interface().(T), nil
+	}
+}
@@ -0,0 +1,111 @@
+package active
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+
+	"github.com/jmoiron/sqlx/types"
+)
+
+type (
+	// Model keeping reference it was loaded and saved with
+	refModel struct {
+		Name string
+		ref  Ref
+	}
+
+	// Model implemented on value receiver, not usable as Repo type
+	valueModel struct{}
+)
+
+func (m *refModel) Marshall() Item {
+	b, err := json.Marshal(m)
+	return Item{V: b, E: err}
+}
+
+func (m *refModel) Unmarshall(ref Ref, data types.JSONText) error {
+	m.ref = ref
+	return json.Unmarshal(data, m)
+}
+
+func (m *refModel) ColumnName() string                  { return "ref" }
+func (m *refModel) Reference() Ref                      { return m.ref }
+func (m *refModel) SetReference(ref Ref)                { m.ref = ref }
+func (valueModel) Marshall() Item                       { return Item{V: []byte(`{}`)} }
+func (valueModel) Unmarshall(Ref, types.JSONText) error { return nil }
+
+func TestRepo(t *testing.T) {
+	rollback := errors.New("rolled back")
+	tests := []struct {
+		name string
+		run  func(t *testing.T, store Store)
+	}{
+		{
+			name: "saved reference follows stored version",
+			run: func(t *testing.T, store Store) {
+				ctx, repo := context.Background(), NewRepo[*refModel](store)
+				m := &refModel{Name: "a", ref: Ref{RowId: "r1", ColumnName: "ref"}}
+				if err := repo.Save(ctx, m); err != nil {
+					t.Fatal(err)
+				} else if m.ref.CreatedAt.IsZero() || m.ref.Version != 0 {
+					t.Fatalf("expected stamped fresh reference, got %+v", m.ref)
+				}
+				m.Name = "b"
+				if err := repo.Save(ctx, m); err != nil {
+					t.Fatal(err)
+				} else if m.ref.Version != 1 {
+					t.Fatalf("expected version 1, got %d", m.ref.Version)
+				}
+				if loaded, err := repo.Load(ctx, "r1", "ref"); err != nil {
+					t.Fatal(err)
+				} else if loaded.Name != "b" || loaded.ref.Version != 1 {
+					t.Fatalf("expected stored model, got %+v", loaded)
+				}
+			},
+		},
+		{
+			name: "reference of rolled back save is kept",
+			run: func(t *testing.T, store Store) {
+				ctx, repo := context.Background(), NewRepo[*refModel](store)
+				m := &refModel{Name: "a", ref: Ref{RowId: "r1", ColumnName: "ref"}}
+				err := store.InTx(ctx, func(ctx context.Context) error {
+					if err := repo.Save(ctx, m); err != nil {
+						return err
+					}
+					return rollback
+				})
+				if !errors.Is(err, rollback) {
+					t.Fatalf("expected rollback, got %v", err)
+				} else if !m.ref.CreatedAt.IsZero() {
+					t.Fatalf("expected reference untouched, got %+v", m.ref)
+				}
+			},
+		},
+		{
+			name: "model without reference is refused",
+			run: func(t *testing.T, store Store) {
+				if err := NewRepo[*RawModel](store).Save(context.Background(), &RawModel{}); !errors.Is(err, ErrNoReference) {
+					t.Fatalf("expected ErrNoReference, got %v", err)
+				}
+			},
+		},
+		{
+			name: "non pointer type is refused",
+			run: func(t *testing.T, store Store) {
+				ctx, repo := context.Background(), NewRepo[valueModel](store)
+				if _, err := repo.Load(ctx, "r1", "doc"); !errors.Is(err, ErrNotPointer) {
+					t.Fatalf("expected load refused, got %v", err)
+				} else if err := repo.Save(ctx, valueModel{}); !errors.Is(err, ErrNotPointer) {
+					t.Fatalf("expected save refused, got %v", err)
+				}
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.run(t, NewMemStore())
+		})
+	}
+}
@@ -0,0 +1,115 @@
+package active
+
+import (
+	"context"
+
+	"github.com/jmoiron/sqlx"
+)
+
+type (
+	// Outcome of applied batch
+	ApplyResult struct {
+		Added   int
+		Updated int
+		Deleted int
+
+		// Updates dropped by UpdateChanged as equal to their baseline
+		SkippedUnchanged int
+
+		// Stored version of every inserted or updated entity
+		NewVersion map[Key]uint
+	}
+
+	// Versions returned by update statements of ApplyChangesResult
+	versionCollector struct {
+		versions map[Key]uint
+	}
+
+	versionsKey struct{}
+)
+
+// Appended to update statements when versions are collected
+const (
+	sqlReturningVersion     = ` RETURNING row_id, column_name, version`
+	sqlReturningVersionMany = ` RETURNING m.row_id, m.column_name, m.version`
+)
+
+// Apply batch in single transaction reporting what was written. Versions of updated cells are
+// those returned by update statements
+func (pg *pg) ApplyChangesResult(ctx context.Context, batch Batch, opts ...TxOption) (*ApplyResult, error) {
+	c := &versionCollector{versions: map[Key]uint{}}
+	if err := pg.ApplyChangesContext(context.WithValue(ctx, versionsKey{}, c), batch, opts...); err != nil {
+		return nil, err
+	}
+	res := newApplyResult(batch)
+	for key, version := range c.versions {
+		if _, ok := res.NewVersion[key]; ok {
+			res.NewVersion[key] = version
+		}
+	}
+	return res, nil
+}
+
+func collectorFrom(ctx context.Context) *versionCollector {
+	c, _ := ctx.Value(versionsKey{}).(*versionCollector)
+	return c
+}
+
+// Record versions of returned rows, reports their number
+func (c *versionCollector) collect(rows *sqlx.Rows) (int64, error) {
+	defer rows.Close()
+	var num int64
+	for rows.Next() {
+		var (
+			key     Key
+			version uint
+		)
+		if err := rows.Scan(&key.RowId, &key.ColumnName, &version); err != nil {
+			return num, err
+		}
+		c.versions[key] = version
+		num++
+	}
+	return num, rows.Err()
+}
+
+// Execute update statement of single cell, returning its new version when ctx collects versions
+func (pg *pg) execUpdate(ctx context.Context, tx execer, st PlannedStatement, ref Ref) error {
+	c := collectorFrom(ctx)
+	if c == nil {
+		if r, err := tx.ExecContext(ctx, st.SQL, st.Args...); err != nil {
+			return wrapErr("update", ref, err)
+		} else {
+			return pg.expectOne(ctx, r, ref)
+		}
+	}
+	rows, err := tx.QueryxContext(ctx, st.SQL+sqlReturningVersion, st.Args...)
+	if err != nil {
+		return wrapErr("update", ref, err)
+	}
+	if num, err := c.collect(rows); err != nil {
+		return wrapErr("update", ref, err)
+	} else {
+		return pg.expectRows(ctx, ref, num)
+	}
+}
+
+// Outcome of successfully applied batch
+func newApplyResult(batch Batch) *ApplyResult {
+	res := &ApplyResult{NewVersion: map[Key]uint{}, SkippedUnchanged: batch.unchanged}
+	for _, change := range batch.Items() {
+		key := change.V.Ref.Key()
+		switch change.T {
+		case AddChangeType:
+			res.Added++
+			res.NewVersion[key] = change.V.Ref.Version
+		case UpdateChangeType:
+			res.Updated++
+			res.NewVersion[key] = change.V.Ref.Version + 1
+		case DeleteChangeType:
+			res.Deleted++
+			delete(res.NewVersion, key)
+		}
+	}
+	return res
+}
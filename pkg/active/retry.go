@@ -0,0 +1,61 @@
+package active
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/avast/retry-go"
+)
+
+type (
+	// Exponential backoff bounds between retries
+	RetryOptions struct {
+		Base time.Duration
+		Cap  time.Duration
+	}
+)
+
+var (
+	DefaultRetryOptions = RetryOptions{Base: 50 * time.Millisecond, Cap: 2 * time.Second}
+)
+
+// Apply batch retrying on optimistic lock, reload rebuilds batch from fresh versions before each attempt
+func (pg *pg) ApplyWithRetry(ctx context.Context, maxAttempts int, reload func() (Batch, error)) error {
+	return applyWithRetry(ctx, maxAttempts, pg.backoffOr(DefaultRetryOptions), reload, pg.ApplyChangesContext)
+}
+
+func (pg *pg) ApplyWithRetryOptions(ctx context.Context, maxAttempts int, opts RetryOptions, reload func() (Batch, error)) error {
+	return applyWithRetry(ctx, maxAttempts, opts, reload, pg.ApplyChangesContext)
+}
+
+// Retry loop shared by stores
+func applyWithRetry(ctx context.Context, maxAttempts int, backoff Backoff, reload func() (Batch, error),
+	apply func(ctx context.Context, batch Batch, opts ...TxOption) error) error {
+	if maxAttempts < 1 {
+		maxAttempts = 1
+	}
+	return retry.Do(func() error {
+		if batch, err := reload(); err != nil {
+			return err
+		} else {
+			return apply(ctx, batch)
+		}
+	}, retryOptions(ctx, maxAttempts, backoff, func(err error) bool {
+		return errors.Is(err, ErrOptimisticLock)
+	})...)
+}
+
+// Retry up to attempts times waiting delays of backoff
+func retryOptions(ctx context.Context, attempts int, backoff Backoff, retryIf func(error) bool) []retry.Option {
+	return []retry.Option{
+		retry.Context(ctx),
+		retry.Attempts(uint(attempts)),
+		retry.DelayType(func(n uint, _ error, _ *retry.Config) time.Duration {
+			// n counts retries from 0
+			return backoff.Next(int(n) + 1)
+		}),
+		retry.RetryIf(retryIf),
+		retry.LastErrorOnly(true),
+	}
+}
@@ -0,0 +1,70 @@
+package active
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/jmoiron/sqlx/types"
+)
+
+func TestApplyWithRetry(t *testing.T) {
+	failure := errors.New("connection reset")
+	tests := []struct {
+		name        string
+		maxAttempts int
+		attempts    []error
+		wantErr     error
+	}{
+		{
+			name:        "conflicts are retried until update succeeds",
+			maxAttempts: 3,
+			attempts:    []error{ErrOptimisticLock, ErrOptimisticLock, nil},
+		},
+		{
+			name:        "last conflict is returned once attempts are exhausted",
+			maxAttempts: 2,
+			attempts:    []error{ErrOptimisticLock, ErrOptimisticLock},
+			wantErr:     ErrOptimisticLock,
+		},
+		{
+			name:        "other failure is not retried",
+			maxAttempts: 3,
+			attempts:    []error{failure},
+			wantErr:     failure,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store, mock := newMockStore(t)
+			for _, err := range tt.attempts {
+				mock.ExpectBegin()
+				exec := mock.ExpectPrepare(`UPDATE models`).ExpectExec()
+				switch {
+				case err == nil:
+					exec.WillReturnResult(sqlmock.NewResult(0, 1))
+					mock.ExpectCommit()
+				case errors.Is(err, ErrOptimisticLock):
+					exec.WillReturnResult(sqlmock.NewResult(0, 0))
+					mock.ExpectRollback()
+				default:
+					exec.WillReturnError(err)
+					mock.ExpectRollback()
+				}
+			}
+			reloads := 0
+			err := store.ApplyWithRetryOptions(context.Background(), tt.maxAttempts, RetryOptions{Base: time.Microsecond, Cap: time.Microsecond}, func() (Batch, error) {
+				reloads++
+				e := &Entity{Model: &RawModel{Data: types.JSONText(`{}`)}, Ref: Ref{RowId: "r1", ColumnName: "doc", Version: uint(reloads)}}
+				return *NewBatch().Update(e), nil
+			})
+			if !errors.Is(err, tt.wantErr) || tt.wantErr == nil && err != nil {
+				t.Fatalf("expected %v, got %v", tt.wantErr, err)
+			} else if reloads != len(tt.attempts) {
+				t.Fatalf("expected %d attempts, got %d", len(tt.attempts), reloads)
+			}
+		})
+	}
+}
@@ -0,0 +1,54 @@
+package active
+
+import (
+	"context"
+	"time"
+)
+
+// Insert cells with RETURNING version, created_at, updated_at and write returned values into Ref,
+// so values the database generates are seen by caller. Timestamps of new entities are left to
+// column defaults, which models table then has to have, e.g. created_at DEFAULT now().
+// Entities with CreatedAt set keep their timestamps. Consecutive adds are inserted one by one
+func WithInsertReturning() Option {
+	return func(p *pg) {
+		p.insertReturning = true
+	}
+}
+
+func (pg *pg) returningStatement(ctx context.Context, entity *Entity, now time.Time) (PlannedStatement, error) {
+	defaultColumnName(entity)
+	p, err := pg.encode(entity.Model)
+	if err != nil {
+		return PlannedStatement{}, err
+	}
+	ref := insertRef(entity.Ref, now)
+	args := []interface{}{
+		ref.RowId,
+		ref.ColumnName,
+		ref.Version,
+		p.data,
+		pg.codec.Format(),
+		p.compressed,
+		p.keyId,
+	}
+	if entity.Ref.CreatedAt.IsZero() {
+		return PlannedStatement{SQL: pg.q(ctx).insertDefault, Args: args}, nil
+	}
+	return PlannedStatement{SQL: pg.q(ctx).insertStamped, Args: append(args, ref.CreatedAt, ref.UpdatedAt)}, nil
+}
+
+func (pg *pg) addReturning(ctx context.Context, tx execer, entity *Entity) error {
+	st, err := pg.returningStatement(ctx, entity, pg.timestamp())
+	if err != nil {
+		return err
+	}
+	ref := entity.Ref
+	if err := tx.QueryRowxContext(ctx, st.SQL, st.Args...).Scan(&ref.Version, &ref.CreatedAt, &ref.UpdatedAt); err != nil {
+		return wrapErr("insert", entity.Ref, err)
+	}
+	ref.CreatedAt, ref.UpdatedAt = ref.CreatedAt.UTC(), ref.UpdatedAt.UTC()
+	onCommit(ctx, func() {
+		entity.Ref = ref
+	})
+	return nil
+}
@@ -0,0 +1,94 @@
+package active
+
+import (
+	"context"
+	"fmt"
+	"sort"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/jmoiron/sqlx/types"
+)
+
+// Model keeping JSON data as stored, LoadRow fills entities with it. Decode it with DataTo
+type RawModel struct {
+	Data types.JSONText
+}
+
+func (m *RawModel) Marshall() Item {
+	return Item{V: m.Data}
+}
+
+func (m *RawModel) Unmarshall(_ Ref, data types.JSONText) error {
+	m.Data = append(types.JSONText(nil), data...)
+	return nil
+}
+
+// All not deleted cells of row by column name, ErrNotFound when row has none
+func (pg *pg) LoadRow(ctx context.Context, rowId string) (map[string]*Entity, error) {
+	ctx, err := pg.enter(ctx)
+	if err != nil {
+		return nil, err
+	}
+	ctx, cancel := pg.withDefaultTimeout(ctx)
+	defer cancel()
+	var cells []cell
+	if err := sqlx.SelectContext(ctx, pg.queryer(ctx), &cells, pg.q(ctx).getRow, rowId); err != nil {
+		return nil, err
+	} else if len(cells) == 0 {
+		return nil, ErrNotFound
+	}
+	res := make(map[string]*Entity, len(cells))
+	for _, aCell := range cells {
+		m := &RawModel{}
+		if err := pg.decode(aCell, m); err != nil {
+			return nil, fmt.Errorf("%s: %w", aCell.toRef().Key(), err)
+		}
+		res[aCell.ColumnName] = &Entity{Model: m, Ref: aCell.toRef()}
+	}
+	return res, nil
+}
+
+// Save entities of row keyed by column name in single transaction, each is inserted or updated
+// like in Save. Stale version of any column fails and rolls back all of them
+func (pg *pg) SaveRow(ctx context.Context, rowId string, entities map[string]*Entity) error {
+	return saveRow(ctx, pg.ApplyChangesContext, rowId, entities)
+}
+
+// Save row through apply, shared by stores
+func saveRow(ctx context.Context, apply func(ctx context.Context, batch Batch, opts ...TxOption) error,
+	rowId string, entities map[string]*Entity) error {
+	cols := make([]string, 0, len(entities))
+	for col, e := range entities {
+		if e == nil {
+			return fmt.Errorf("%s/%s: %w: nil entity", rowId, col, ErrInvalidRef)
+		} else if e.Ref.RowId != "" && e.Ref.RowId != rowId || e.Ref.ColumnName != "" && e.Ref.ColumnName != col {
+			return fmt.Errorf("%s: %w: saved as %s/%s", e.Ref.Key(), ErrInvalidRef, rowId, col)
+		}
+		cols = append(cols, col)
+	}
+	// same statement order for every caller
+	sort.Strings(cols)
+	batch := NewOrderedBatch()
+	isNew := make(map[string]bool, len(cols))
+	for _, col := range cols {
+		e := entities[col]
+		e.Ref.RowId, e.Ref.ColumnName = rowId, col
+		isNew[col] = e.Ref.Version == 0 && e.Ref.CreatedAt.IsZero()
+		if isNew[col] {
+			batch.Add(e)
+		} else {
+			batch.Update(e)
+		}
+	}
+	if err := apply(ctx, *batch); err != nil {
+		return err
+	}
+	onCommit(ctx, func() {
+		for _, col := range cols {
+			if !isNew[col] {
+				entities[col].Ref.Version++
+			}
+		}
+	})
+	return nil
+}
@@ -0,0 +1,68 @@
+package active
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/jmoiron/sqlx/types"
+)
+
+func TestSaveRowStaleColumnRollsBackRow(t *testing.T) {
+	store, mock := newMockStore(t)
+	created := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	added := &Entity{Model: &RawModel{Data: types.JSONText(`{}`)}}
+	updated := &Entity{Model: &RawModel{Data: types.JSONText(`{}`)}, Ref: Ref{Version: 1, CreatedAt: created, UpdatedAt: created}}
+	stale := &Entity{Model: &RawModel{Data: types.JSONText(`{}`)}, Ref: Ref{Version: 2, CreatedAt: created, UpdatedAt: created}}
+
+	mock.ExpectBegin()
+	mock.ExpectPrepare(`INSERT INTO models`).ExpectExec().
+		WithArgs("r1", "a", 0, sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectQuery(`UPDATE models AS m`).
+		WillReturnRows(sqlmock.NewRows([]string{"row_id", "column_name", "version"}).AddRow("r1", "b", 2))
+	mock.ExpectRollback()
+
+	err := store.SaveRow(context.Background(), "r1", map[string]*Entity{"a": added, "b": updated, "c": stale})
+	if !errors.Is(err, ErrOptimisticLock) {
+		t.Fatalf("expected %v, got %v", ErrOptimisticLock, err)
+	}
+	if !added.Ref.CreatedAt.IsZero() || added.Ref.Version != 0 {
+		t.Fatalf("expected added column unstamped, got %+v", added.Ref)
+	} else if updated.Ref.Version != 1 || stale.Ref.Version != 2 {
+		t.Fatalf("expected versions 1 and 2 kept, got %d and %d", updated.Ref.Version, stale.Ref.Version)
+	}
+}
+
+func TestSaveRowParity(t *testing.T) {
+	for name, newStore := range parityStores(t) {
+		t.Run(name+"/stale column rolls back row", func(t *testing.T) {
+			ctx := context.Background()
+			store := newStore(t)
+			e := rawEntity("r1", `{"b":1}`)
+			e.Ref.ColumnName = "b"
+			mustSave(t, store, e)
+
+			stale := *e
+			stale.Ref.ColumnName, stale.Ref.Version = "", stale.Ref.Version+1
+			err := store.SaveRow(ctx, "r1", map[string]*Entity{
+				"a": {Model: &RawModel{Data: types.JSONText(`{"a":2}`)}},
+				"b": {Model: &RawModel{Data: types.JSONText(`{"b":2}`)}, Ref: e.Ref},
+				"c": &stale,
+			})
+			if !errors.Is(err, ErrOptimisticLock) {
+				t.Fatalf("expected %v, got %v", ErrOptimisticLock, err)
+			}
+			row, err := store.LoadRow(ctx, "r1")
+			if err != nil {
+				t.Fatal(err)
+			} else if len(row) != 1 || row["b"] == nil {
+				t.Fatalf("expected only column b, got %d columns", len(row))
+			} else if data := string(row["b"].Model.(*RawModel).Data); data != `{"b":1}` {
+				t.Fatalf("expected column b untouched, got %s", data)
+			}
+		})
+	}
+}
@@ -0,0 +1,26 @@
+package active
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// Create models, action log, applied batch fingerprints and, with versioning, history tables with their indexes unless they exist, safe to run repeatedly
+func (pg *pg) EnsureSchema(ctx context.Context) error {
+	return pg.inTx(ctx, func(ctx context.Context, tx *sqlx.Tx) error {
+		versions := pg.versionsTable()
+		if versions != "" {
+			versions = pg.tableIn(ctx, versions)
+		}
+		ddls := append(pg.dialect.Schema(pg.tableIn(ctx, pg.table), pg.tableIn(ctx, pg.actionTable), versions),
+			fmt.Sprintf(ddlFingerprints, pg.tableIn(ctx, pg.fingerprintTable)))
+		for _, ddl := range ddls {
+			if _, err := tx.ExecContext(ctx, ddl); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
@@ -0,0 +1,81 @@
+package active
+
+import (
+	"context"
+	"errors"
+)
+
+type (
+	// Reads and writes sharing single transaction, writes are executed immediately
+	Tx interface {
+		ReadStore
+
+		// Insert new entity
+		Add(ctx context.Context, e *Entity) error
+
+		// Update entity, on success e.Ref tracks stored version
+		Update(ctx context.Context, e *Entity) error
+
+		Delete(ctx context.Context, e *Entity) error
+	}
+
+	txScope struct {
+		readTx
+	}
+)
+
+var (
+	// Locking read called without transaction of store
+	ErrNoTx = errors.New("model: transaction required")
+)
+
+// Run fn in transaction committed when fn succeeds and rolled back otherwise,
+// so entity can be loaded, modified and saved atomically
+func (pg *pg) WithTx(ctx context.Context, fn func(tx Tx) error) error {
+	return pg.InTx(ctx, func(ctx context.Context) error {
+		return fn(&txScope{readTx{pg: pg, state: txFromContext(ctx), hooks: commitHooksFrom(ctx), route: pg.routeOf(ctx)}})
+	})
+}
+
+// Load model within tx taking row lock, so concurrent LoadForUpdate of the same cell blocks
+// until tx commits or rolls back. Tx has to come from WithTx of this store, ErrNoTx otherwise
+func (pg *pg) LoadForUpdate(ctx context.Context, tx Tx, m Model, rowId, columnName string) (*Entity, error) {
+	scope, ok := tx.(*txScope)
+	if !ok || scope.pg != pg || scope.state == nil {
+		return nil, ErrNoTx
+	}
+	ctx, err := pg.enter(scope.bind(ctx))
+	if err != nil {
+		return nil, err
+	}
+	return pg.load(ctx, pg.q(ctx).getForUpdate, m, rowId, columnName)
+}
+
+func (s *txScope) Add(ctx context.Context, e *Entity) error {
+	return s.write(ctx, Change{V: e, T: AddChangeType})
+}
+
+func (s *txScope) Update(ctx context.Context, e *Entity) error {
+	if err := s.write(ctx, Change{V: e, T: UpdateChangeType}); err != nil {
+		return err
+	}
+	onCommit(s.bind(ctx), func() {
+		e.Ref.Version++
+	})
+	return nil
+}
+
+func (s *txScope) Delete(ctx context.Context, e *Entity) error {
+	return s.write(ctx, Change{V: e, T: DeleteChangeType})
+}
+
+func (s *txScope) write(ctx context.Context, change Change) error {
+	changes := []Change{change}
+	if change.T == AddChangeType {
+		defaultColumnName(change.V)
+	}
+	if err := validate(changes); err != nil {
+		return err
+	}
+	return s.pg.apply(s.bind(ctx), s.state.tx, changes)
+}
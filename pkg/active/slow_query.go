@@ -0,0 +1,31 @@
+package active
+
+import (
+	"context"
+	"time"
+)
+
+// Called with statement that ran longer than threshold
+type SlowQueryHandler func(ctx context.Context, sql string, dur time.Duration)
+
+// Report statements running longer than d to h, independently of Logger. Statements of applied
+// batches are timed without preparing them and without acquiring connection, which transaction holds
+// already. Bulk statements outside of transactions, like DeleteWhere, include connection acquisition
+func WithSlowQueryThreshold(d time.Duration, h SlowQueryHandler) Option {
+	return func(p *pg) {
+		if d > 0 && h != nil {
+			p.slowThreshold = d
+			p.slowHandler = h
+		}
+	}
+}
+
+// Report statement started at started when it was slow
+func (pg *pg) observeSlow(ctx context.Context, query string, started time.Time) {
+	if pg.slowHandler == nil {
+		return
+	}
+	if dur := time.Since(started); dur >= pg.slowThreshold {
+		pg.slowHandler(ctx, query, dur)
+	}
+}
@@ -0,0 +1,130 @@
+package active
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// Self-contained copy of stored cell, data is kept as stored so compressed and encrypted cells
+// restore as they were
+type snapshot struct {
+	RowId      string    `json:"row_id"`
+	ColumnName string    `json:"column_name"`
+	Version    uint      `json:"version"`
+	Data       []byte    `json:"data"`
+	Format     string    `json:"format"`
+	Compressed bool      `json:"compressed,omitempty"`
+	KeyId      string    `json:"key_id,omitempty"`
+	CreatedAt  time.Time `json:"created_at"`
+	UpdatedAt  time.Time `json:"updated_at"`
+}
+
+func newSnapshot(c cell) snapshot {
+	format := c.Format.String
+	if format == "" {
+		format = JSONFormat
+	}
+	return snapshot{
+		RowId:      c.RowId,
+		ColumnName: c.ColumnName,
+		Version:    c.Version,
+		Data:       c.Data,
+		Format:     format,
+		Compressed: c.Compressed,
+		KeyId:      c.KeyId.String,
+		CreatedAt:  c.CreatedAt,
+		UpdatedAt:  c.UpdatedAt,
+	}
+}
+
+func (s snapshot) ref() Ref {
+	return Ref{RowId: s.RowId, ColumnName: s.ColumnName, Version: s.Version, CreatedAt: s.CreatedAt, UpdatedAt: s.UpdatedAt}
+}
+
+func parseSnapshot(blob []byte) (snapshot, error) {
+	s := snapshot{}
+	if err := json.Unmarshal(blob, &s); err != nil {
+		return s, err
+	} else if s.RowId == "" || s.ColumnName == "" {
+		return s, errors.New("model: snapshot without row and column")
+	}
+	return s, nil
+}
+
+// Blob with ref and stored data of not deleted cell, restored by Restore
+func (pg *pg) Snapshot(ctx context.Context, rowId, columnName string) ([]byte, error) {
+	ctx, err := pg.enter(ctx)
+	if err != nil {
+		return nil, err
+	}
+	c, err := get(ctx, pg.queryer(ctx), pg.q(ctx).get, rowId, columnName)
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(newSnapshot(c))
+}
+
+// Write cell of snapshot back: missing cell is inserted as it was, otherwise stored data is
+// replaced bumping current version and undeleting the cell. Cell changed since snapshot was taken
+// fails with ErrOptimisticLock unless force is set
+func (pg *pg) Restore(ctx context.Context, blob []byte, force bool) error {
+	s, err := parseSnapshot(blob)
+	if err != nil {
+		return err
+	}
+	ref := s.ref()
+	if err := validateRef(ref, AddChangeType); err != nil {
+		return err
+	}
+	return pg.inTx(ctx, func(ctx context.Context, tx *sqlx.Tx) error {
+		current, err := get(ctx, tx, pg.q(ctx).getAny, s.RowId, s.ColumnName)
+		if errors.Is(err, ErrNotFound) {
+			if _, err := tx.ExecContext(ctx, pg.q(ctx).insert,
+				s.RowId,
+				s.ColumnName,
+				s.Version,
+				s.Data,
+				s.Format,
+				s.Compressed,
+				nullString(s.KeyId),
+				s.CreatedAt,
+				s.UpdatedAt); err != nil {
+				return wrapErr("restore", ref, err)
+			}
+			return nil
+		} else if err != nil {
+			return err
+		} else if current.Version > s.Version && !force {
+			return wrapErr("restore", ref, ErrOptimisticLock)
+		}
+		if pg.versioning {
+			if err := pg.archive(ctx, tx, &Entity{Ref: current.toRef()}); err != nil {
+				return err
+			}
+		}
+		if r, err := tx.ExecContext(ctx, pg.q(ctx).restore,
+			s.Data,
+			s.Format,
+			s.Compressed,
+			nullString(s.KeyId),
+			current.Version+1,
+			pg.timestamp(),
+			s.RowId,
+			s.ColumnName,
+			current.Version); err != nil {
+			return wrapErr("restore", ref, err)
+		} else if err := pg.expectOne(ctx, r, ref); err != nil {
+			return wrapErr("restore", ref, err)
+		}
+		return nil
+	})
+}
+
+func nullString(s string) sql.NullString {
+	return sql.NullString{String: s, Valid: s != ""}
+}
@@ -0,0 +1,25 @@
+package active
+
+import (
+	"errors"
+	"net/http"
+)
+
+// HTTP status of store error: 409 for optimistic lock and duplicate, 404 for not found, 500 otherwise
+func HTTPStatus(err error) int {
+	if IsConflict(err) {
+		return http.StatusConflict
+	} else if IsNotFound(err) {
+		return http.StatusNotFound
+	}
+	return http.StatusInternalServerError
+}
+
+// Error is caused by concurrent update or already taken key
+func IsConflict(err error) bool {
+	return errors.Is(err, ErrOptimisticLock) || errors.Is(err, ErrDuplicate)
+}
+
+func IsNotFound(err error) bool {
+	return errors.Is(err, ErrNotFound)
+}
@@ -0,0 +1,69 @@
+package active
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+)
+
+type (
+	// Statement executor, either transaction or its prepared statements
+	execer interface {
+		sqlx.QueryerContext
+		ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+	}
+
+	// Prepared statements of single transaction, each distinct query is parsed once
+	stmtCache struct {
+		tx      *sqlx.Tx
+		stmts   map[string]*sqlx.Stmt
+		observe func(ctx context.Context, query string, started time.Time)
+	}
+)
+
+func newStmtCache(tx *sqlx.Tx) *stmtCache {
+	return &stmtCache{tx: tx, stmts: map[string]*sqlx.Stmt{}}
+}
+
+func (c *stmtCache) ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error) {
+	stmt, ok := c.stmts[query]
+	if !ok {
+		var err error
+		if stmt, err = c.tx.PreparexContext(ctx, query); err != nil {
+			return nil, err
+		}
+		c.stmts[query] = stmt
+	}
+	if c.observe != nil {
+		defer c.observe(ctx, query, time.Now())
+	}
+	return stmt.ExecContext(ctx, args...)
+}
+
+// Queries are rare within apply, they run on transaction unprepared
+func (c *stmtCache) QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error) {
+	return c.tx.QueryContext(ctx, query, args...)
+}
+
+func (c *stmtCache) QueryxContext(ctx context.Context, query string, args ...interface{}) (*sqlx.Rows, error) {
+	return c.tx.QueryxContext(ctx, query, args...)
+}
+
+func (c *stmtCache) QueryRowxContext(ctx context.Context, query string, args ...interface{}) *sqlx.Row {
+	return c.tx.QueryRowxContext(ctx, query, args...)
+}
+
+// Close all prepared statements, must happen before transaction ends
+func (c *stmtCache) Close() error {
+	var errs []error
+	for query, stmt := range c.stmts {
+		if err := stmt.Close(); err != nil {
+			errs = append(errs, err)
+		}
+		delete(c.stmts, query)
+	}
+	return errors.Join(errs...)
+}
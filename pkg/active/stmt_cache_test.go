@@ -0,0 +1,105 @@
+package active
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+func TestStmtCache(t *testing.T) {
+	failure := errors.New("prepare failed")
+	tests := []struct {
+		name       string
+		deletes    int
+		prepareErr error
+	}{
+		{name: "statement is prepared once per transaction", deletes: 3},
+		{name: "prepare failure rolls back", deletes: 3, prepareErr: failure},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store, mock := newMockStore(t)
+			mock.ExpectBegin()
+			prepare := mock.ExpectPrepare(`DELETE FROM models WHERE row_id = \$1`)
+			if tt.prepareErr != nil {
+				prepare.WillReturnError(tt.prepareErr)
+				mock.ExpectRollback()
+			} else {
+				for i := 0; i < tt.deletes; i++ {
+					prepare.ExpectExec().WithArgs(fmt.Sprintf("r%d", i), "doc", 1).WillReturnResult(sqlmock.NewResult(0, 1))
+				}
+				prepare.WillBeClosed()
+				mock.ExpectCommit()
+			}
+			if err := store.ApplyChangesContext(context.Background(), deleteBatch(tt.deletes)); !errors.Is(err, tt.prepareErr) || tt.prepareErr == nil && err != nil {
+				t.Fatalf("expected %v, got %v", tt.prepareErr, err)
+			}
+		})
+	}
+}
+
+// Mock parses nothing, so this measures client side cost of preparing, parse time saved shows only against Postgres
+func BenchmarkStmtCache(b *testing.B) {
+	const deletes = 100
+	benchmarks := []struct {
+		name     string
+		prepared bool
+	}{
+		{name: "prepared once", prepared: true},
+		{name: "parsed every time"},
+	}
+	for _, bm := range benchmarks {
+		b.Run(bm.name, func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				b.StopTimer()
+				// fresh mock per run, so matching does not scan expectations of earlier runs
+				store, mock := newMockStore(b)
+				mock.ExpectBegin()
+				if bm.prepared {
+					prepare := mock.ExpectPrepare(`DELETE FROM models`)
+					for j := 0; j < deletes; j++ {
+						prepare.ExpectExec().WillReturnResult(sqlmock.NewResult(0, 1))
+					}
+				} else {
+					for j := 0; j < deletes; j++ {
+						mock.ExpectExec(`DELETE FROM models`).WillReturnResult(sqlmock.NewResult(0, 1))
+					}
+				}
+				mock.ExpectCommit()
+				ctx, batch := context.Background(), deleteBatch(deletes)
+				b.StartTimer()
+				tx, err := store.db.BeginTxx(ctx, nil)
+				if err != nil {
+					b.Fatal(err)
+				}
+				var ex execer = tx
+				stmts := newStmtCache(tx)
+				if bm.prepared {
+					ex = stmts
+				}
+				for _, change := range batch.Items() {
+					if err := store.remove(ctx, ex, change.V); err != nil {
+						b.Fatal(err)
+					}
+				}
+				if err := stmts.Close(); err != nil {
+					b.Fatal(err)
+				} else if err := tx.Commit(); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+// Batch deleting version 1 of n raw cells r0..rn-1
+func deleteBatch(n int) Batch {
+	batch := NewOrderedBatch()
+	for i := 0; i < n; i++ {
+		batch.Delete(&Entity{Model: &RawModel{}, Ref: Ref{RowId: fmt.Sprintf("r%d", i), ColumnName: "doc", Version: 1}})
+	}
+	return *batch
+}
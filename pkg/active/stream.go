@@ -0,0 +1,102 @@
+package active
+
+import (
+	"context"
+
+	"github.com/jmoiron/sqlx"
+)
+
+type (
+	// Cursor over stored entities hydrating one row at a time
+	EntityIterator struct {
+		ctx     context.Context
+		src     entitySource
+		current *Entity
+		err     error
+	}
+
+	// Rows behind iterator, fetch reports false once they are exhausted
+	entitySource interface {
+		fetch() (*Entity, bool, error)
+		Close() error
+	}
+
+	// Source reading query result
+	rowsSource struct {
+		pg      *pg
+		rows    *sqlx.Rows
+		factory func() Model
+	}
+)
+
+// Stream not deleted entities of column ordered by row, caller must Close the iterator.
+// Rows are released once ctx is cancelled
+func (pg *pg) Stream(ctx context.Context, columnName string, factory func() Model) (*EntityIterator, error) {
+	ctx, err := pg.enter(ctx)
+	if err != nil {
+		return nil, err
+	}
+	rows, err := pg.queryer(ctx).QueryxContext(ctx, pg.q(ctx).stream, columnName)
+	if err != nil {
+		return nil, err
+	}
+	return &EntityIterator{ctx: ctx, src: &rowsSource{pg: pg, rows: rows, factory: factory}}, nil
+}
+
+// Advance to next entity, false when rows are exhausted or failed
+func (it *EntityIterator) Next() bool {
+	if it.err != nil {
+		return false
+	}
+	if err := it.ctx.Err(); err != nil {
+		it.fail(err)
+		return false
+	}
+	e, ok, err := it.src.fetch()
+	if !ok || err != nil {
+		it.fail(err)
+		return false
+	}
+	it.current = e
+	return true
+}
+
+// Entity read by last successful Next
+func (it *EntityIterator) Entity() *Entity {
+	return it.current
+}
+
+// Failure stopped iteration, nil when rows were just exhausted
+func (it *EntityIterator) Err() error {
+	return it.err
+}
+
+func (it *EntityIterator) Close() error {
+	it.current = nil
+	return it.src.Close()
+}
+
+func (it *EntityIterator) fail(err error) {
+	it.err = err
+	it.current = nil
+	it.src.Close()
+}
+
+func (s *rowsSource) fetch() (*Entity, bool, error) {
+	if !s.rows.Next() {
+		return nil, false, s.rows.Err()
+	}
+	aCell := cell{}
+	if err := s.rows.StructScan(&aCell); err != nil {
+		return nil, false, err
+	}
+	m := s.factory()
+	if err := s.pg.decode(aCell, m); err != nil {
+		return nil, false, err
+	}
+	return &Entity{Model: m, Ref: aCell.toRef()}, true, nil
+}
+
+func (s *rowsSource) Close() error {
+	return s.rows.Close()
+}
@@ -0,0 +1,44 @@
+package active
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+)
+
+// Update expected version other than stored one, Current tells version to retry from
+type ConflictError struct {
+	Key      Key
+	Expected uint
+	Current  uint
+}
+
+// Lock and compare stored version of every updated cell before its update statement, failing with
+// ConflictError that carries current version. Costs extra query per update
+func WithStrictVersionCheck() Option {
+	return func(p *pg) {
+		p.strictVersions = true
+	}
+}
+
+func (e *ConflictError) Error() string {
+	return fmt.Sprintf("model: %s expected version %d, current is %d: optimistic lock", e.Key, e.Expected, e.Current)
+}
+
+func (e *ConflictError) Unwrap() error {
+	return ErrOptimisticLock
+}
+
+// Lock row of entity and make sure its version is still the loaded one
+func (pg *pg) checkVersion(ctx context.Context, tx execer, entity *Entity) error {
+	var current uint
+	if err := tx.QueryRowxContext(ctx, pg.q(ctx).versionLock, entity.Ref.RowId, entity.Ref.ColumnName).Scan(&current); errors.Is(err, sql.ErrNoRows) {
+		return wrapErr("update", entity.Ref, ErrOptimisticLock)
+	} else if err != nil {
+		return wrapErr("update", entity.Ref, err)
+	} else if current != entity.Ref.Version {
+		return &ConflictError{Key: entity.Ref.Key(), Expected: entity.Ref.Version, Current: current}
+	}
+	return nil
+}
@@ -0,0 +1,84 @@
+package active
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/jmoiron/sqlx/types"
+)
+
+func TestStrictVersionCheck(t *testing.T) {
+	const lock = `SELECT version FROM models WHERE row_id = \$1 AND column_name = \$2 AND deleted_at IS NULL FOR UPDATE`
+	created := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name string
+		// stored version of each updated row, missing one has no row
+		stored       []*uint
+		updated      bool
+		wantErr      error
+		wantConflict *ConflictError
+	}{
+		{
+			name:    "current version is updated",
+			stored:  []*uint{ptr(uint(3))},
+			updated: true,
+		},
+		{
+			name:         "stale version reports current one",
+			stored:       []*uint{ptr(uint(5))},
+			wantErr:      ErrOptimisticLock,
+			wantConflict: &ConflictError{Key: Key{RowId: "r0", ColumnName: "doc"}, Expected: 3, Current: 5},
+		},
+		{
+			name:    "missing cell is plain optimistic lock",
+			stored:  []*uint{nil},
+			wantErr: ErrOptimisticLock,
+		},
+		{
+			name:         "every row of group is checked",
+			stored:       []*uint{ptr(uint(3)), ptr(uint(4))},
+			wantErr:      ErrOptimisticLock,
+			wantConflict: &ConflictError{Key: Key{RowId: "r1", ColumnName: "doc"}, Expected: 3, Current: 4},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store, mock := newMockStore(t, WithStrictVersionCheck())
+			mock.ExpectBegin()
+			batch := NewBatch()
+			for i, version := range tt.stored {
+				rowId := fmt.Sprintf("r%d", i)
+				rows := sqlmock.NewRows([]string{"version"})
+				if version != nil {
+					rows.AddRow(*version)
+				}
+				mock.ExpectQuery(lock).WithArgs(rowId, "doc").WillReturnRows(rows)
+				batch.Update(&Entity{Model: &RawModel{Data: types.JSONText(`{}`)}, Ref: Ref{RowId: rowId, ColumnName: "doc", Version: 3, CreatedAt: created, UpdatedAt: created}})
+			}
+			if tt.updated {
+				mock.ExpectPrepare(`UPDATE models`).ExpectExec().WillReturnResult(sqlmock.NewResult(0, 1))
+				mock.ExpectCommit()
+			} else {
+				mock.ExpectRollback()
+			}
+			err := store.ApplyChangesContext(context.Background(), *batch)
+			if !errors.Is(err, tt.wantErr) || tt.wantErr == nil && err != nil {
+				t.Fatalf("expected %v, got %v", tt.wantErr, err)
+			}
+			var conflict *ConflictError
+			if isConflict := errors.As(err, &conflict); isConflict != (tt.wantConflict != nil) {
+				t.Fatalf("expected conflict %v, got %v", tt.wantConflict, err)
+			} else if isConflict && *conflict != *tt.wantConflict {
+				t.Fatalf("expected conflict %+v, got %+v", tt.wantConflict, conflict)
+			}
+		})
+	}
+}
+
+func ptr[T any](v T) *T {
+	return &v
+}
@@ -0,0 +1,33 @@
+package active
+
+import (
+	"context"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// Bump version and updated_at of cell under optimistic lock keeping its data, e.g. to invalidate
+// entities loaded by others. Previous version is archived when versioning is on
+func (pg *pg) Touch(ctx context.Context, ref Ref) error {
+	if err := validateRef(ref, UpdateChangeType); err != nil {
+		return err
+	}
+	now := pg.timestamp()
+	return pg.inTx(ctx, func(ctx context.Context, tx *sqlx.Tx) error {
+		if pg.versioning {
+			if err := pg.archive(ctx, tx, &Entity{Ref: ref}); err != nil {
+				return err
+			}
+		}
+		if r, err := tx.ExecContext(ctx, pg.q(ctx).touch,
+			now,
+			ref.RowId,
+			ref.ColumnName,
+			ref.Version); err != nil {
+			return wrapErr("touch", ref, err)
+		} else if err := pg.expectOne(ctx, r, ref); err != nil {
+			return wrapErr("touch", ref, err)
+		}
+		return nil
+	})
+}
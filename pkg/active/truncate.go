@@ -0,0 +1,44 @@
+package active
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+var (
+	ErrTruncateDisabled = errors.New("model: truncate is not allowed, see WithTruncate")
+)
+
+// Allow Truncate, meant for test fixtures only
+func WithTruncate(allowed bool) Option {
+	return func(p *pg) {
+		p.truncateAllowed = allowed
+	}
+}
+
+// Remove all rows of tables, models and action log tables of store when none are given.
+// Refused unless store was created with WithTruncate(true)
+func (pg *pg) Truncate(ctx context.Context, tables ...string) error {
+	ctx, err := pg.enter(ctx)
+	if err != nil {
+		return err
+	}
+	if !pg.truncateAllowed {
+		return ErrTruncateDisabled
+	}
+	if len(tables) == 0 {
+		tables = []string{pg.table, pg.actionTable}
+	}
+	names := make([]string, len(tables))
+	for i, table := range tables {
+		if !_identifierRe.MatchString(table) {
+			return fmt.Errorf("%w: %q", ErrInvalidIdentifier, table)
+		}
+		names[i] = pg.tableIn(ctx, table)
+	}
+	query := "TRUNCATE " + strings.Join(names, ", ") + " RESTART IDENTITY CASCADE"
+	_, err = pg.exec(ctx, query)
+	return err
+}
@@ -0,0 +1,391 @@
+package active
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/avast/retry-go"
+	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
+)
+
+type (
+	// Transaction bound to context
+	txState struct {
+		tx         *sqlx.Tx
+		savepoints int
+	}
+
+	txKey struct{}
+
+	// Updates of entities deferred until transaction commits
+	commitHooks struct {
+		fns []func()
+	}
+
+	commitKey struct{}
+
+	// Failed commit, outcome of transaction is unknown
+	commitError struct {
+		err error
+	}
+
+	// Statements of store qualified by tenant schema
+	route struct {
+		schema string
+		sql    statements
+	}
+
+	// Context key of route, per store since stores differ in tables
+	routeKey struct {
+		pg *pg
+	}
+)
+
+// Schemas whose statements are cached by store
+const maxRoutes = 256
+
+// Use given isolation level
+func WithIsolation(lvl sql.IsolationLevel) TxOption {
+	return func(o *sql.TxOptions) {
+		o.Isolation = lvl
+	}
+}
+
+// Open read only transaction
+func ReadOnly() TxOption {
+	return func(o *sql.TxOptions) {
+		o.ReadOnly = true
+	}
+}
+
+func (p *pg) inTx(ctx context.Context, fn func(ctx context.Context, tx *sqlx.Tx) error) error {
+	return p.inTxOpts(ctx, nil, fn)
+}
+
+// Run fn in transaction or savepoint of transaction bound to ctx, fn gets ctx of transaction span
+// so its statements are traced as children
+func (p *pg) inTxOpts(ctx context.Context, opts []TxOption, fn func(ctx context.Context, tx *sqlx.Tx) error) (err error) {
+	ctx, span := p.tracer.Start(ctx, "active.tx")
+	defer func() {
+		endSpan(span, err)
+	}()
+
+	if ctx, err = p.enter(ctx); err != nil {
+		return err
+	}
+	if state := txFromContext(ctx); state != nil {
+		return state.savepoint(ctx, fn)
+	}
+	ctx, cancel := p.withDefaultTimeout(ctx)
+	defer cancel()
+	release, err := p.acquire()
+	if err != nil {
+		return err
+	}
+	defer release()
+
+	lvl := _defaultLvl
+	for _, opt := range opts {
+		opt(&lvl)
+	}
+	if p.txRetries == 0 && p.connRetries == 0 {
+		return p.runTx(ctx, &lvl, fn)
+	}
+	retries, backoff := p.txRetries, p.txBackoff
+	if p.connRetries > retries {
+		retries, backoff = p.connRetries, p.connBackoff
+	}
+	return retry.Do(func() error {
+		return p.runTx(ctx, &lvl, fn)
+	}, retryOptions(ctx, retries+1, p.backoffOr(jittered(backoff)), p.isRetryableTxErr)...)
+}
+
+func (p *pg) runTx(ctx context.Context, lvl *sql.TxOptions, fn func(ctx context.Context, tx *sqlx.Tx) error) error {
+	if tx, err := p.db.BeginTxx(ctx, lvl); err != nil {
+		return err
+	} else {
+		if err := p.setupTx(ctx, tx); err != nil {
+			return errors.Join(err, tx.Rollback())
+		}
+		ctx, hooks := withCommitHooks(ctx)
+		if err := fn(ctx, tx); err != nil {
+			if rbErr := tx.Rollback(); rbErr != nil && !errors.Is(rbErr, sql.ErrTxDone) {
+				return errors.Join(err, fmt.Errorf("rollback: %w", rbErr))
+			}
+			return err
+		} else if err := tx.Commit(); err != nil {
+			return &commitError{err: err}
+		}
+		hooks.run()
+		return nil
+	}
+}
+
+// Ctx collecting hooks of transaction or savepoint run by caller once it commits
+func withCommitHooks(ctx context.Context) (context.Context, *commitHooks) {
+	hooks := &commitHooks{}
+	return context.WithValue(ctx, commitKey{}, hooks), hooks
+}
+
+// Run fn once transaction ctx belongs to commits, right away outside of transaction.
+// Failed transaction drops its hooks, so entities keep state they had before it
+func onCommit(ctx context.Context, fn func()) {
+	if hooks := commitHooksFrom(ctx); hooks != nil {
+		hooks.fns = append(hooks.fns, fn)
+	} else {
+		fn()
+	}
+}
+
+// Hooks of transaction ctx belongs to, nil outside of transaction
+func commitHooksFrom(ctx context.Context) *commitHooks {
+	hooks, _ := ctx.Value(commitKey{}).(*commitHooks)
+	return hooks
+}
+
+func (h *commitHooks) run() {
+	for _, fn := range h.fns {
+		fn()
+	}
+}
+
+// Retry whole transaction up to retries times when it fails with deadlock or serialization failure
+func WithTxRetry(retries int, backoff RetryOptions) Option {
+	return func(p *pg) {
+		if retries > 0 {
+			p.txRetries = retries
+			p.txBackoff = backoff
+		}
+	}
+}
+
+// Abort any statement of store transactions running longer than d on server side,
+// even when caller context allows more. Queries outside of transactions are not limited
+func WithStatementTimeout(d time.Duration) Option {
+	return func(p *pg) {
+		p.statementTimeout = d
+	}
+}
+
+// Bound operations called with context without deadline, e.g. by ApplyChanges, to d, so stuck
+// connection cannot hang caller forever. Deadline of caller context is kept as is, even a later one.
+// Iterators of Stream are not bound
+func WithDefaultTimeout(d time.Duration) Option {
+	return func(p *pg) {
+		p.defaultTimeout = d
+	}
+}
+
+// Context bounded by default timeout unless it has deadline already
+func (p *pg) withDefaultTimeout(ctx context.Context) (context.Context, context.CancelFunc) {
+	if _, ok := ctx.Deadline(); ok || p.defaultTimeout <= 0 {
+		return ctx, func() {}
+	}
+	return context.WithTimeout(ctx, p.defaultTimeout)
+}
+
+// Route every operation, reads and writes alike, to schema resolved from its context, e.g. tenant schema.
+// Transactions run SET LOCAL search_path to it, so any SQL of the transaction resolves tables there,
+// and store statements name its tables outright, so reads outside of transaction are routed too.
+// Empty schema keeps connection default. Plan shows default statements
+func WithSchemaResolver(fn func(ctx context.Context) string) Option {
+	return func(p *pg) {
+		p.schemaResolver = fn
+	}
+}
+
+// Context of store operation: reports invalid option and binds statements of schema resolved from ctx.
+// Context already routed by store keeps its route, so nested calls stay in the same schema
+func (pg *pg) enter(ctx context.Context) (context.Context, error) {
+	if pg.err != nil {
+		return ctx, pg.err
+	} else if pg.schemaResolver == nil || ctx.Value(routeKey{pg}) != nil {
+		return ctx, nil
+	}
+	schema := pg.schemaResolver(ctx)
+	if schema == "" {
+		return ctx, nil
+	} else if !_identifierRe.MatchString(schema) || strings.Contains(schema, ".") {
+		return ctx, fmt.Errorf("%w: schema %q", ErrInvalidIdentifier, schema)
+	}
+	if r, ok := pg.routes.Load(schema); ok {
+		return context.WithValue(ctx, routeKey{pg}, r), nil
+	}
+	r := &route{
+		schema: schema,
+		sql: buildStatements(pg.dialect,
+			qualify(schema, pg.table),
+			qualify(schema, pg.actionTable),
+			qualify(schema, pg.historyTable),
+			qualify(schema, pg.fingerprintTable)),
+	}
+	// past maxRoutes schemas statements are built per operation instead of growing cache
+	if pg.routeCount.Add(1) > maxRoutes {
+		pg.routeCount.Add(-1)
+	} else if cached, loaded := pg.routes.LoadOrStore(schema, r); loaded {
+		pg.routeCount.Add(-1)
+		r = cached.(*route)
+	}
+	return context.WithValue(ctx, routeKey{pg}, r), nil
+}
+
+// Route ctx is bound to, nil for default schema
+func (pg *pg) routeOf(ctx context.Context) *route {
+	r, _ := ctx.Value(routeKey{pg}).(*route)
+	return r
+}
+
+// Statements of schema ctx is routed to
+func (pg *pg) q(ctx context.Context) *statements {
+	if r := pg.routeOf(ctx); r != nil {
+		return &r.sql
+	}
+	return &pg.sql
+}
+
+// Table as seen from schema ctx is routed to
+func (pg *pg) tableIn(ctx context.Context, table string) string {
+	if r := pg.routeOf(ctx); r != nil {
+		return qualify(r.schema, table)
+	}
+	return table
+}
+
+// Qualify table by schema unless it names its schema already
+func qualify(schema, table string) string {
+	if strings.Contains(table, ".") {
+		return table
+	}
+	return schema + "." + table
+}
+
+// Transaction scoped settings, SET LOCAL is reverted once transaction ends
+func (p *pg) setupTx(ctx context.Context, tx *sqlx.Tx) error {
+	// SET takes no bind parameters
+	if p.statementTimeout > 0 {
+		if _, err := tx.ExecContext(ctx, fmt.Sprintf("SET LOCAL statement_timeout = %d", p.statementTimeout.Milliseconds())); err != nil {
+			return err
+		}
+	}
+	if r := p.routeOf(ctx); r != nil {
+		if _, err := tx.ExecContext(ctx, "SET LOCAL search_path TO "+pq.QuoteIdentifier(r.schema)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Postgres reports transaction which is safe to run again
+func isTransientTxErr(err error) bool {
+	var pqErr *pq.Error
+	return errors.As(err, &pqErr) && (pqErr.Code == pqSerializationFailure || pqErr.Code == pqDeadlockDetected)
+}
+
+// Retry whole transaction up to retries times when connection to database is lost before commit,
+// transaction rolled back with the connection is safe to run again. Failed commit is never retried
+// since it might have been applied
+func WithConnectionRetry(retries int, backoff RetryOptions) Option {
+	return func(p *pg) {
+		if retries > 0 {
+			p.connRetries = retries
+			p.connBackoff = backoff
+		}
+	}
+}
+
+// Connection lost, reported by driver, network or Postgres connection exception (class 08)
+func isConnErr(err error) bool {
+	var (
+		pqErr  *pq.Error
+		netErr net.Error
+		cErr   *commitError
+	)
+	if errors.As(err, &cErr) {
+		return false
+	}
+	return errors.Is(err, driver.ErrBadConn) ||
+		errors.As(err, &netErr) ||
+		errors.As(err, &pqErr) && pqErr.Code.Class() == "08"
+}
+
+func (p *pg) isRetryableTxErr(err error) bool {
+	return p.txRetries > 0 && isTransientTxErr(err) || p.connRetries > 0 && isConnErr(err)
+}
+
+func (e *commitError) Error() string {
+	return "commit: " + e.err.Error()
+}
+
+func (e *commitError) Unwrap() error {
+	return e.err
+}
+
+// Run fn in transaction. Store calls made with ctx passed to fn join the transaction,
+// nested InTx and writes are isolated by savepoints so inner failure keeps outer transaction alive
+func (pg *pg) InTx(ctx context.Context, fn func(ctx context.Context) error, opts ...TxOption) error {
+	return pg.inTxOpts(ctx, opts, func(ctx context.Context, tx *sqlx.Tx) error {
+		if txFromContext(ctx) != nil {
+			return fn(ctx)
+		}
+		return fn(context.WithValue(ctx, txKey{}, &txState{tx: tx}))
+	})
+}
+
+func txFromContext(ctx context.Context) *txState {
+	state, _ := ctx.Value(txKey{}).(*txState)
+	return state
+}
+
+// Run fn within savepoint of already open transaction
+func (s *txState) savepoint(ctx context.Context, fn func(ctx context.Context, tx *sqlx.Tx) error) error {
+	s.savepoints++
+	name := fmt.Sprintf("active_sp_%d", s.savepoints)
+	if _, err := s.tx.ExecContext(ctx, "SAVEPOINT "+name); err != nil {
+		return err
+	}
+	// hooks of released savepoint wait for outer transaction
+	spCtx, hooks := withCommitHooks(ctx)
+	if err := fn(spCtx, s.tx); err != nil {
+		if _, rbErr := s.tx.ExecContext(ctx, "ROLLBACK TO SAVEPOINT "+name); rbErr != nil {
+			return errors.Join(err, fmt.Errorf("rollback to savepoint: %w", rbErr))
+		}
+		return err
+	}
+	if _, err := s.tx.ExecContext(ctx, "RELEASE SAVEPOINT "+name); err != nil {
+		return err
+	}
+	onCommit(ctx, hooks.run)
+	return nil
+}
+
+// Queries run in transaction bound to ctx, otherwise on read database
+func (pg *pg) queryer(ctx context.Context) sqlx.QueryerContext {
+	if state := txFromContext(ctx); state != nil {
+		return state.tx
+	}
+	return pg.reader()
+}
+
+// Writes outside of batches run in transaction bound to ctx, otherwise on primary
+func (pg *pg) writer(ctx context.Context) sqlx.QueryerContext {
+	if state := txFromContext(ctx); state != nil {
+		return state.tx
+	}
+	return pg.db
+}
+
+// Execute statement in transaction bound to ctx, otherwise on primary
+func (pg *pg) exec(ctx context.Context, query string, args ...interface{}) (sql.Result, error) {
+	defer pg.observeSlow(ctx, query, time.Now())
+	if state := txFromContext(ctx); state != nil {
+		return state.tx.ExecContext(ctx, query, args...)
+	}
+	return pg.db.ExecContext(ctx, query, args...)
+}
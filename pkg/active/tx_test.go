@@ -0,0 +1,422 @@
+package active
+
+import (
+	"context"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/jmoiron/sqlx/types"
+	"github.com/lib/pq"
+)
+
+func TestStampAfterCommit(t *testing.T) {
+	at := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	failure := errors.New("connection reset")
+	tests := []struct {
+		name      string
+		expect    func(mock sqlmock.Sqlmock)
+		save      func(store *pg, e *Entity) error
+		wantErr   error
+		wantStamp bool
+	}{
+		{
+			name: "committed insert is stamped",
+			expect: func(mock sqlmock.Sqlmock) {
+				mock.ExpectBegin()
+				mock.ExpectPrepare(`INSERT INTO models`).ExpectExec().WillReturnResult(sqlmock.NewResult(0, 1))
+				mock.ExpectCommit()
+			},
+			save: func(store *pg, e *Entity) error {
+				return store.Save(context.Background(), e)
+			},
+			wantStamp: true,
+		},
+		{
+			name: "failed commit leaves entity as it was",
+			expect: func(mock sqlmock.Sqlmock) {
+				mock.ExpectBegin()
+				mock.ExpectPrepare(`INSERT INTO models`).ExpectExec().WillReturnResult(sqlmock.NewResult(0, 1))
+				mock.ExpectCommit().WillReturnError(failure)
+			},
+			save: func(store *pg, e *Entity) error {
+				return store.Save(context.Background(), e)
+			},
+			wantErr: failure,
+		},
+		{
+			name: "insert rolled back to savepoint is not stamped",
+			expect: func(mock sqlmock.Sqlmock) {
+				mock.ExpectBegin()
+				mock.ExpectExec(`SAVEPOINT active_sp_1`).WillReturnResult(sqlmock.NewResult(0, 0))
+				mock.ExpectExec(`SAVEPOINT active_sp_2`).WillReturnResult(sqlmock.NewResult(0, 0))
+				mock.ExpectPrepare(`INSERT INTO models`).ExpectExec().WillReturnResult(sqlmock.NewResult(0, 1))
+				mock.ExpectExec(`RELEASE SAVEPOINT active_sp_2`).WillReturnResult(sqlmock.NewResult(0, 0))
+				mock.ExpectExec(`SAVEPOINT active_sp_3`).WillReturnResult(sqlmock.NewResult(0, 0))
+				mock.ExpectExec(`ROLLBACK TO SAVEPOINT active_sp_3`).WillReturnResult(sqlmock.NewResult(0, 0))
+				mock.ExpectExec(`ROLLBACK TO SAVEPOINT active_sp_1`).WillReturnResult(sqlmock.NewResult(0, 0))
+				mock.ExpectCommit()
+			},
+			save: func(store *pg, e *Entity) error {
+				return store.InTx(context.Background(), func(ctx context.Context) error {
+					err := store.InTx(ctx, func(ctx context.Context) error {
+						if err := store.Save(ctx, e); err != nil {
+							return err
+						}
+						return store.InTx(ctx, func(ctx context.Context) error {
+							return failure
+						})
+					})
+					if !errors.Is(err, failure) {
+						return fmt.Errorf("expected inner failure, got %v", err)
+					}
+					return nil
+				})
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store, mock := newMockStore(t, WithClock(func() time.Time { return at }))
+			tt.expect(mock)
+			e := &Entity{Model: &RawModel{Data: types.JSONText(`{}`)}, Ref: Ref{RowId: "r1", ColumnName: "doc"}}
+			if err := tt.save(store, e); !errors.Is(err, tt.wantErr) || tt.wantErr == nil && err != nil {
+				t.Fatalf("expected %v, got %v", tt.wantErr, err)
+			} else if stamped := e.Ref.CreatedAt.Equal(at); stamped != tt.wantStamp {
+				t.Fatalf("expected stamped %v, got ref %+v", tt.wantStamp, e.Ref)
+			}
+		})
+	}
+}
+
+type tenantKey struct{}
+
+func TestSchemaRouting(t *testing.T) {
+	cols := []string{"row_id", "column_name", "version", "data", "format", "compressed", "key_id", "created_at", "updated_at"}
+	tests := []struct {
+		name    string
+		tenant  string
+		read    func(ctx context.Context, store *pg) error
+		query   string
+		columns []string
+		wantErr error
+	}{
+		{
+			name:   "load reads tenant table",
+			tenant: "t1",
+			read: func(ctx context.Context, store *pg) error {
+				_, err := store.Load(ctx, &RawModel{}, "r1", "doc")
+				return err
+			},
+			query:   `FROM t1\.models WHERE`,
+			wantErr: ErrNotFound,
+		},
+		{
+			name:   "list reads tenant table",
+			tenant: "t1",
+			read: func(ctx context.Context, store *pg) error {
+				_, _, err := store.List(ctx, "doc", ListOptions{})
+				return err
+			},
+			query: `FROM t1\.models\s+WHERE`,
+		},
+		{
+			name:   "actions read tenant log",
+			tenant: "t1",
+			read: func(ctx context.Context, store *pg) error {
+				_, _, err := store.Actions(ctx, 1, "")
+				return err
+			},
+			query:   `FROM t1\.action_models ORDER BY`,
+			columns: []string{"row_id", "name", "data", "created_at"},
+		},
+		{
+			name: "empty schema keeps default tables",
+			read: func(ctx context.Context, store *pg) error {
+				_, err := store.Load(ctx, &RawModel{}, "r1", "doc")
+				return err
+			},
+			query:   `FROM models WHERE`,
+			wantErr: ErrNotFound,
+		},
+		{
+			name:   "invalid schema is refused",
+			tenant: "t1; DROP TABLE models",
+			read: func(ctx context.Context, store *pg) error {
+				_, err := store.Load(ctx, &RawModel{}, "r1", "doc")
+				return err
+			},
+			wantErr: ErrInvalidIdentifier,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store, mock := newMockStore(t, WithSchemaResolver(func(ctx context.Context) string {
+				tenant, _ := ctx.Value(tenantKey{}).(string)
+				return tenant
+			}))
+			if tt.columns == nil {
+				tt.columns = cols
+			}
+			if tt.query != "" {
+				mock.ExpectQuery(tt.query).WillReturnRows(sqlmock.NewRows(tt.columns))
+			}
+			err := tt.read(context.WithValue(context.Background(), tenantKey{}, tt.tenant), store)
+			if !errors.Is(err, tt.wantErr) || tt.wantErr == nil && err != nil {
+				t.Fatalf("expected %v, got %v", tt.wantErr, err)
+			}
+		})
+	}
+}
+
+func TestSchemaRoutingWrites(t *testing.T) {
+	store, mock := newMockStore(t, WithSchemaResolver(func(ctx context.Context) string {
+		tenant, _ := ctx.Value(tenantKey{}).(string)
+		return tenant
+	}))
+	for _, tenant := range []string{"t1", "t2"} {
+		mock.ExpectBegin()
+		mock.ExpectExec(`SET LOCAL search_path TO "` + tenant + `"`).WillReturnResult(sqlmock.NewResult(0, 0))
+		mock.ExpectExec(`SAVEPOINT active_sp_1`).WillReturnResult(sqlmock.NewResult(0, 0))
+		mock.ExpectPrepare(`INSERT INTO ` + tenant + `\.models`).ExpectExec().WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectExec(`RELEASE SAVEPOINT active_sp_1`).WillReturnResult(sqlmock.NewResult(0, 0))
+		mock.ExpectExec(`UPDATE audit SET seen = true`).WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectCommit()
+	}
+	for _, tenant := range []string{"t1", "t2"} {
+		ctx := context.WithValue(context.Background(), tenantKey{}, tenant)
+		err := store.InTx(ctx, func(ctx context.Context) error {
+			e := &Entity{Model: &RawModel{Data: types.JSONText(`{}`)}, Ref: Ref{RowId: "r1", ColumnName: "doc"}}
+			if err := store.Save(ctx, e); err != nil {
+				return err
+			}
+			// SQL of caller resolves tables by search_path of transaction
+			_, err := txFromContext(ctx).tx.ExecContext(ctx, "UPDATE audit SET seen = true")
+			return err
+		})
+		if err != nil {
+			t.Fatalf("%s: %v", tenant, err)
+		}
+	}
+}
+
+func TestSchemaRoutesBounded(t *testing.T) {
+	store := New(nil, WithSchemaResolver(func(ctx context.Context) string {
+		tenant, _ := ctx.Value(tenantKey{}).(string)
+		return tenant
+	})).(*pg)
+	for i := 0; i < maxRoutes+10; i++ {
+		ctx, err := store.enter(context.WithValue(context.Background(), tenantKey{}, fmt.Sprintf("t%d", i)))
+		if err != nil {
+			t.Fatal(err)
+		} else if want := fmt.Sprintf("t%d.models", i); !strings.Contains(store.q(ctx).get, want) {
+			t.Fatalf("expected statements of %s, got %s", want, store.q(ctx).get)
+		}
+	}
+	if n := store.routeCount.Load(); n != maxRoutes {
+		t.Fatalf("expected %d cached routes, got %d", maxRoutes, n)
+	}
+}
+
+func TestNestedTxFailureRollsBackSavepoint(t *testing.T) {
+	failure := errors.New("inner failure")
+	store, mock := newMockStore(t)
+	mock.ExpectBegin()
+	mock.ExpectExec(`SAVEPOINT active_sp_1`).WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectPrepare(`INSERT INTO models`).ExpectExec().WithArgs("outer", sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(`RELEASE SAVEPOINT active_sp_1`).WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec(`SAVEPOINT active_sp_2`).WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec(`SAVEPOINT active_sp_3`).WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectPrepare(`INSERT INTO models`).ExpectExec().WithArgs("inner", sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(`RELEASE SAVEPOINT active_sp_3`).WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec(`ROLLBACK TO SAVEPOINT active_sp_2`).WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectCommit()
+
+	outer := &Entity{Model: &RawModel{Data: types.JSONText(`{}`)}, Ref: Ref{RowId: "outer", ColumnName: "doc"}}
+	inner := &Entity{Model: &RawModel{Data: types.JSONText(`{}`)}, Ref: Ref{RowId: "inner", ColumnName: "doc"}}
+	err := store.InTx(context.Background(), func(ctx context.Context) error {
+		if err := store.Save(ctx, outer); err != nil {
+			return err
+		}
+		err := store.InTx(ctx, func(ctx context.Context) error {
+			if err := store.Save(ctx, inner); err != nil {
+				return err
+			}
+			return failure
+		})
+		if !errors.Is(err, failure) {
+			return fmt.Errorf("expected inner failure, got %v", err)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	} else if outer.Ref.CreatedAt.IsZero() {
+		t.Fatalf("expected committed outer insert stamped, got %+v", outer.Ref)
+	} else if !inner.Ref.CreatedAt.IsZero() {
+		t.Fatalf("expected rolled back inner insert unstamped, got %+v", inner.Ref)
+	}
+}
+
+func TestTxRetry(t *testing.T) {
+	serialization := &pq.Error{Code: pqSerializationFailure}
+	deadlock := &pq.Error{Code: pqDeadlockDetected}
+	failure := errors.New("connection reset")
+	tests := []struct {
+		name    string
+		retries int
+		// outcome of each attempt's fn, commitErr fails commit of attempt whose fn succeeded
+		attempts  []error
+		commitErr []error
+		wantErr   error
+	}{
+		{
+			name:      "serialization failure is retried once",
+			retries:   3,
+			attempts:  []error{serialization, nil},
+			commitErr: []error{nil, nil},
+		},
+		{
+			name:      "serialization failure on commit is retried",
+			retries:   3,
+			attempts:  []error{nil, nil},
+			commitErr: []error{serialization, nil},
+		},
+		{
+			name:      "deadlock is retried up to limit",
+			retries:   2,
+			attempts:  []error{deadlock, deadlock, deadlock},
+			commitErr: []error{nil, nil, nil},
+			wantErr:   deadlock,
+		},
+		{
+			name:      "other failure is not retried",
+			retries:   3,
+			attempts:  []error{failure},
+			commitErr: []error{nil},
+			wantErr:   failure,
+		},
+		{
+			name:      "no retry by default",
+			attempts:  []error{serialization},
+			commitErr: []error{nil},
+			wantErr:   serialization,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store, mock := newMockStore(t, WithTxRetry(tt.retries, RetryOptions{Base: time.Microsecond, Cap: time.Microsecond}))
+			for i, err := range tt.attempts {
+				mock.ExpectBegin()
+				if err != nil {
+					mock.ExpectRollback()
+				} else if tt.commitErr[i] != nil {
+					mock.ExpectCommit().WillReturnError(tt.commitErr[i])
+				} else {
+					mock.ExpectCommit()
+				}
+			}
+			attempts := 0
+			err := store.InTx(context.Background(), func(ctx context.Context) error {
+				attempts++
+				return tt.attempts[attempts-1]
+			})
+			if !errors.Is(err, tt.wantErr) || tt.wantErr == nil && err != nil {
+				t.Fatalf("expected %v, got %v", tt.wantErr, err)
+			} else if attempts != len(tt.attempts) {
+				t.Fatalf("expected %d attempts, got %d", len(tt.attempts), attempts)
+			}
+		})
+	}
+}
+
+func TestConnectionRetry(t *testing.T) {
+	connLost := &pq.Error{Code: "08006"}
+	duplicate := &pq.Error{Code: pqUniqueViolation}
+	type attempt struct {
+		beginErr  error
+		fnErr     error
+		commitErr error
+	}
+	tests := []struct {
+		name     string
+		retries  int
+		attempts []attempt
+		wantErr  error
+		wantRuns int
+	}{
+		{
+			name:     "connection failure on begin is retried",
+			retries:  2,
+			attempts: []attempt{{beginErr: connLost}, {}},
+			wantRuns: 1,
+		},
+		{
+			name:     "connection lost within transaction is retried",
+			retries:  2,
+			attempts: []attempt{{fnErr: connLost}, {}},
+			wantRuns: 2,
+		},
+		{
+			name:     "bad connection reported by driver is retried",
+			retries:  2,
+			attempts: []attempt{{fnErr: driver.ErrBadConn}, {}},
+			wantRuns: 2,
+		},
+		{
+			name:     "retries are limited",
+			retries:  1,
+			attempts: []attempt{{beginErr: connLost}, {beginErr: connLost}},
+			wantErr:  connLost,
+		},
+		{
+			name:     "connection lost on commit is not retried",
+			retries:  2,
+			attempts: []attempt{{commitErr: connLost}},
+			wantErr:  connLost,
+			wantRuns: 1,
+		},
+		{
+			name:     "other failure is not retried",
+			retries:  2,
+			attempts: []attempt{{fnErr: duplicate}},
+			wantErr:  duplicate,
+			wantRuns: 1,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store, mock := newMockStore(t, WithConnectionRetry(tt.retries, RetryOptions{Base: time.Microsecond, Cap: time.Microsecond}))
+			var fnErrs []error
+			for _, a := range tt.attempts {
+				if a.beginErr != nil {
+					mock.ExpectBegin().WillReturnError(a.beginErr)
+					continue
+				}
+				mock.ExpectBegin()
+				fnErrs = append(fnErrs, a.fnErr)
+				if a.fnErr != nil {
+					mock.ExpectRollback()
+				} else {
+					mock.ExpectCommit().WillReturnError(a.commitErr)
+				}
+			}
+			runs := 0
+			err := store.InTx(context.Background(), func(ctx context.Context) error {
+				runs++
+				return fnErrs[runs-1]
+			})
+			if !errors.Is(err, tt.wantErr) || tt.wantErr == nil && err != nil {
+				t.Fatalf("expected %v, got %v", tt.wantErr, err)
+			} else if runs != tt.wantRuns {
+				t.Fatalf("expected %d runs, got %d", tt.wantRuns, runs)
+			}
+		})
+	}
+}
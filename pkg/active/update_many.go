@@ -0,0 +1,130 @@
+package active
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Update several entities with single statement joining their new data, each row matched by
+// its expected version. Entities whose rows were not matched are stale, they fail the whole group
+// with ErrOptimisticLock and are the ones logged as conflicts
+func (pg *pg) applyUpdates(ctx context.Context, tx execer, changes []Change) (err error) {
+	ctx, span := pg.tracer.Start(ctx, "active.update", trace.WithAttributes(
+		attribute.Int("rows", len(changes))))
+	defer func() {
+		endSpan(span, err)
+	}()
+
+	errs, err := pg.updateMany(ctx, tx, changes)
+	pg.logChanges(ctx, changes, errs)
+	return err
+}
+
+// Errors of changes aligned with them, changes past the failed hook or check were not attempted
+// and have none
+func (pg *pg) updateMany(ctx context.Context, tx execer, changes []Change) ([]error, error) {
+	errs := make([]error, 0, len(changes))
+	entities := make([]*Entity, len(changes))
+	for i, change := range changes {
+		err := beforeChange(ctx, change)
+		if err == nil && pg.strictVersions {
+			err = pg.checkVersion(ctx, tx, change.V)
+		}
+		if err == nil && pg.versioning {
+			err = pg.archive(ctx, tx, change.V)
+		}
+		if errs = append(errs, err); err != nil {
+			return errs, err
+		}
+		entities[i] = change.V
+	}
+	now := pg.timestamp()
+	st, err := pg.updateManyStatement(ctx, entities, now)
+	if err != nil {
+		return sameErr(errs, err), err
+	}
+	matched, err := pg.execUpdateMany(ctx, tx, st)
+	if err != nil {
+		err = wrapOpErr(fmt.Sprintf("update %d rows", len(entities)), err)
+		return sameErr(errs, err), err
+	}
+	var stale []Key
+	for i, entity := range entities {
+		if num := matched[entity.Ref.Key()]; num == 0 {
+			errs[i] = wrapErr("update", entity.Ref, ErrOptimisticLock)
+			stale = append(stale, entity.Ref.Key())
+		} else if err := pg.expectRows(ctx, entity.Ref, num); err != nil {
+			errs[i] = wrapErr("update", entity.Ref, err)
+			return errs, fmt.Errorf("update %d rows: %w", len(entities), errs[i])
+		}
+	}
+	if len(stale) > 0 {
+		return errs, fmt.Errorf("update %d rows, %d stale %v: %w", len(entities), len(stale), stale, ErrOptimisticLock)
+	}
+	for _, change := range changes {
+		stampUpdate(ctx, change.V, now)
+		afterChange(ctx, change)
+	}
+	return errs, nil
+}
+
+// Execute multi-row update reporting number of rows updated per key, their new versions are
+// collected for ctx when it asks
+func (pg *pg) execUpdateMany(ctx context.Context, tx execer, st PlannedStatement) (map[Key]int64, error) {
+	rows, err := tx.QueryxContext(ctx, st.SQL+sqlReturningVersionMany, st.Args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	c := collectorFrom(ctx)
+	matched := map[Key]int64{}
+	for rows.Next() {
+		var (
+			key     Key
+			version uint
+		)
+		if err := rows.Scan(&key.RowId, &key.ColumnName, &version); err != nil {
+			return nil, err
+		}
+		matched[key]++
+		if c != nil {
+			c.versions[key] = version
+		}
+	}
+	return matched, rows.Err()
+}
+
+func (pg *pg) updateManyStatement(ctx context.Context, entities []*Entity, now time.Time) (PlannedStatement, error) {
+	var (
+		query strings.Builder
+		args  = make([]interface{}, 0, len(entities)*updateManyParams)
+	)
+	query.WriteString(pg.q(ctx).updateMany)
+	for i, entity := range entities {
+		p, err := pg.encode(entity.Model)
+		if err != nil {
+			return PlannedStatement{}, err
+		}
+		if i > 0 {
+			query.WriteString(", ")
+		}
+		query.WriteString("(?, ?, ?, ?, ?, ?, ?, ?)")
+		args = append(args,
+			entity.Ref.RowId,
+			entity.Ref.ColumnName,
+			p.data,
+			pg.codec.Format(),
+			p.compressed,
+			p.keyId,
+			entity.Ref.Version,
+			now)
+	}
+	query.WriteString(sqlUpdateManyMatch)
+	return PlannedStatement{SQL: sqlx.Rebind(pg.dialect.BindType(), query.String()), Args: args}, nil
+}
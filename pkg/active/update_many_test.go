@@ -0,0 +1,118 @@
+package active
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/jmoiron/sqlx/types"
+)
+
+func TestApplyUpdates(t *testing.T) {
+	created := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	failure := errors.New("connection reset")
+	tests := []struct {
+		name    string
+		matched []string
+		execErr error
+		wantErr error
+		logged  map[string]error
+	}{
+		{
+			name:    "all rows matched",
+			matched: []string{"r1", "r2"},
+			logged:  map[string]error{"r1": nil, "r2": nil},
+		},
+		{
+			name:    "stale row fails alone",
+			matched: []string{"r1"},
+			wantErr: ErrOptimisticLock,
+			logged:  map[string]error{"r1": nil, "r2": ErrOptimisticLock},
+		},
+		{
+			name:    "statement failure fails every row",
+			execErr: failure,
+			wantErr: failure,
+			logged:  map[string]error{"r1": failure, "r2": failure},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			logger := newRecordingLogger()
+			store, mock := newMockStore(t, WithLogger(logger))
+			mock.ExpectBegin()
+			query := mock.ExpectQuery(`UPDATE models AS m .* RETURNING m.row_id, m.column_name, m.version`)
+			if tt.execErr != nil {
+				query.WillReturnError(tt.execErr)
+			} else {
+				rows := sqlmock.NewRows([]string{"row_id", "column_name", "version"})
+				for _, rowId := range tt.matched {
+					rows.AddRow(rowId, "doc", 2)
+				}
+				query.WillReturnRows(rows)
+			}
+			if tt.wantErr != nil {
+				mock.ExpectRollback()
+			} else {
+				mock.ExpectCommit()
+			}
+
+			batch := NewBatch()
+			entities := map[string]*Entity{}
+			for _, rowId := range []string{"r1", "r2"} {
+				e := &Entity{Model: &RawModel{Data: types.JSONText(`{}`)}, Ref: Ref{RowId: rowId, ColumnName: "doc", Version: 1, CreatedAt: created, UpdatedAt: created}}
+				entities[rowId] = e
+				batch.Update(e)
+			}
+			err := store.ApplyChangesContext(context.Background(), *batch)
+			if !errors.Is(err, tt.wantErr) || tt.wantErr == nil && err != nil {
+				t.Fatalf("expected %v, got %v", tt.wantErr, err)
+			}
+			for rowId, want := range tt.logged {
+				errs := logger.errs[Key{RowId: rowId, ColumnName: "doc"}]
+				if len(errs) != 1 || !errors.Is(errs[0], want) || want == nil && errs[0] != nil {
+					t.Errorf("%s: expected logged %v, got %v", rowId, want, errs)
+				}
+			}
+			for rowId, e := range entities {
+				if stamped := !e.Ref.UpdatedAt.Equal(created); stamped != (tt.wantErr == nil) {
+					t.Errorf("%s: expected updated at stamped %v, got %v", rowId, tt.wantErr == nil, e.Ref.UpdatedAt)
+				}
+			}
+		})
+	}
+}
+
+func TestApplyUpdatesStaleRowRollsBackGroup(t *testing.T) {
+	created := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	store, mock := newMockStore(t)
+	mock.ExpectBegin()
+	mock.ExpectPrepare(`INSERT INTO models`).ExpectExec().WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectQuery(`UPDATE models AS m .* RETURNING m.row_id, m.column_name, m.version`).
+		WillReturnRows(sqlmock.NewRows([]string{"row_id", "column_name", "version"}).AddRow("r1", "doc", 2).AddRow("r3", "doc", 2))
+	mock.ExpectRollback()
+
+	added := &Entity{Model: &RawModel{Data: types.JSONText(`{}`)}, Ref: Ref{RowId: "r0", ColumnName: "doc"}}
+	batch := NewBatch().Add(added)
+	var updated []*Entity
+	for _, rowId := range []string{"r1", "r2", "r3"} {
+		e := &Entity{Model: &RawModel{Data: types.JSONText(`{}`)}, Ref: Ref{RowId: rowId, ColumnName: "doc", Version: 1, CreatedAt: created, UpdatedAt: created}}
+		updated = append(updated, e)
+		batch.Update(e)
+	}
+	err := store.ApplyChangesContext(context.Background(), *batch)
+	if !errors.Is(err, ErrOptimisticLock) || !strings.Contains(err.Error(), "r2") {
+		t.Fatalf("expected stale r2 to fail group, got %v", err)
+	}
+	if !added.Ref.CreatedAt.IsZero() {
+		t.Errorf("expected rolled back add unstamped, got %+v", added.Ref)
+	}
+	for _, e := range updated {
+		if e.Ref.Version != 1 || !e.Ref.UpdatedAt.Equal(created) {
+			t.Errorf("%s: expected rolled back update to keep its ref, got %+v", e.Ref.RowId, e.Ref)
+		}
+	}
+}
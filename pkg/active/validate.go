@@ -0,0 +1,91 @@
+package active
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"strings"
+)
+
+type (
+	// Model checking its own state before it is stored
+	Validator interface {
+		Validate() error
+	}
+
+	// All validation failures of batch
+	ValidationError struct {
+		Errs []error
+	}
+)
+
+var (
+	// Ref cannot address stored cell
+	ErrInvalidRef = errors.New("model: invalid ref")
+)
+
+func (e *ValidationError) Error() string {
+	msgs := make([]string, len(e.Errs))
+	for i, err := range e.Errs {
+		msgs[i] = err.Error()
+	}
+	return "model: validation failed: " + strings.Join(msgs, "; ")
+}
+
+func (e *ValidationError) Unwrap() []error {
+	return e.Errs
+}
+
+// Validate refs of changes and models to be inserted or updated, nil when all of them are valid
+func validate(changes []Change) error {
+	var errs []error
+	for _, change := range changes {
+		if change.V == nil {
+			errs = append(errs, fmt.Errorf("%s of nil entity: %w", change.T, ErrInvalidRef))
+			continue
+		}
+		if change.T == AddChangeType {
+			defaultColumnName(change.V)
+		}
+		if err := validateRef(change.V.Ref, change.T); err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		if change.T == DeleteChangeType {
+			continue
+		}
+		if v, ok := change.V.Model.(Validator); ok {
+			if err := v.Validate(); err != nil {
+				errs = append(errs, fmt.Errorf("%s: %w", change.V.Ref.Key(), err))
+			}
+		}
+	}
+	if len(errs) > 0 {
+		return &ValidationError{Errs: errs}
+	}
+	return nil
+}
+
+// Column model is stored under, given one or default of model like for added entity
+func columnOf(m Model, columnName string) string {
+	e := &Entity{Model: m, Ref: Ref{ColumnName: columnName}}
+	defaultColumnName(e)
+	return e.Ref.ColumnName
+}
+
+// Reject ref with empty row or column and version not fitting BIGINT column, including version
+// update would store
+func validateRef(ref Ref, t ChangeType) error {
+	limit := uint64(math.MaxInt64)
+	if t == UpdateChangeType {
+		limit--
+	}
+	if ref.RowId == "" {
+		return fmt.Errorf("%s: %w: empty row id", ref.Key(), ErrInvalidRef)
+	} else if ref.ColumnName == "" {
+		return fmt.Errorf("%s: %w: empty column name", ref.Key(), ErrInvalidRef)
+	} else if uint64(ref.Version) > limit {
+		return fmt.Errorf("%s: %w: version %d out of range", ref.Key(), ErrInvalidRef, ref.Version)
+	}
+	return nil
+}
@@ -0,0 +1,103 @@
+package active
+
+import (
+	"context"
+	"errors"
+	"math"
+	"strings"
+	"testing"
+
+	"github.com/jmoiron/sqlx/types"
+)
+
+// Model refusing to be stored
+type invalidModel struct {
+	RawModel
+}
+
+func (invalidModel) Validate() error {
+	return errors.New("always invalid")
+}
+
+func TestWritesValidate(t *testing.T) {
+	huge := uint(math.MaxInt64)
+	tests := []struct {
+		name    string
+		write   func(ctx context.Context, store Store) error
+		wantErr error
+	}{
+		{
+			name: "upsert without row",
+			write: func(ctx context.Context, store Store) error {
+				return store.Upsert(ctx, &Entity{Model: &RawModel{Data: types.JSONText(`{}`)}, Ref: Ref{ColumnName: "doc"}})
+			},
+			wantErr: ErrInvalidRef,
+		},
+		{
+			name: "upsert of invalid model",
+			write: func(ctx context.Context, store Store) error {
+				return store.Upsert(ctx, &Entity{Model: &invalidModel{}, Ref: Ref{RowId: "r1", ColumnName: "doc"}})
+			},
+			wantErr: &ValidationError{},
+		},
+		{
+			name: "soft delete without column",
+			write: func(ctx context.Context, store Store) error {
+				return store.SoftDelete(ctx, &Entity{Model: &RawModel{}, Ref: Ref{RowId: "r1"}})
+			},
+			wantErr: ErrInvalidRef,
+		},
+		{
+			name: "touch without row",
+			write: func(ctx context.Context, store Store) error {
+				return store.Touch(ctx, Ref{ColumnName: "doc"})
+			},
+			wantErr: ErrInvalidRef,
+		},
+		{
+			name: "patch of version out of range",
+			write: func(ctx context.Context, store Store) error {
+				return store.PatchJSON(ctx, Ref{RowId: "r1", ColumnName: "doc", Version: huge}, map[string]any{"a": 1})
+			},
+			wantErr: ErrInvalidRef,
+		},
+		{
+			name: "load or create without row",
+			write: func(ctx context.Context, store Store) error {
+				_, _, err := store.LoadOrCreate(ctx, &RawModel{}, "", "doc", func() Model { return &RawModel{} })
+				return err
+			},
+			wantErr: ErrInvalidRef,
+		},
+		{
+			name: "import of version out of range",
+			write: func(ctx context.Context, store Store) error {
+				_, err := store.ImportNDJSON(ctx, strings.NewReader(`{"row_id":"r1","column_name":"doc","version":9223372036854775808,"data":{}}`))
+				return err
+			},
+			wantErr: ErrInvalidRef,
+		},
+	}
+	stores := map[string]func(t *testing.T) Store{
+		"pg": func(t *testing.T) Store {
+			store, _ := newMockStore(t)
+			return store
+		},
+		"mem": func(t *testing.T) Store {
+			return NewMemStore()
+		},
+	}
+	for name, newStore := range stores {
+		for _, tt := range tests {
+			t.Run(name+"/"+tt.name, func(t *testing.T) {
+				err := tt.write(context.Background(), newStore(t))
+				var ve *ValidationError
+				if _, ok := tt.wantErr.(*ValidationError); ok && !errors.As(err, &ve) {
+					t.Fatalf("expected ValidationError, got %v", err)
+				} else if !ok && !errors.Is(err, tt.wantErr) {
+					t.Fatalf("expected %v, got %v", tt.wantErr, err)
+				}
+			})
+		}
+	}
+}